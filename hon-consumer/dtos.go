@@ -1,7 +1,33 @@
 package main
 
+import (
+	"fmt"
+
+	"github.com/jirbthagoras/hon/shared"
+)
+
 type SendMail struct {
 	To      string
 	Subject string
 	Body    string
 }
+
+// ImportSummary is what the import summary email shows
+type ImportSummary struct {
+	shared.ImportMsg
+	Source   string
+	Total    int
+	Imported int
+	Skipped  int
+	Failed   int
+	Failures []string
+	Error    string
+}
+
+// fail records a row that could not be imported, only the first few are kept
+func (s *ImportSummary) fail(row int, reason string) {
+	s.Failed++
+	if len(s.Failures) < importFailureLimit {
+		s.Failures = append(s.Failures, fmt.Sprintf("Row %d: %s", row, reason))
+	}
+}
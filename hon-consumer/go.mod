@@ -2,7 +2,10 @@ module github.com/jirbthagoras/hon/hon-consumer
 
 go 1.23.2
 
-require github.com/jirbthagoras/hon/shared v0.0.0-20250519041151-c76075b8b749
+require (
+	github.com/jirbthagoras/hon/shared v0.0.0-20250519041151-c76075b8b749
+	github.com/rabbitmq/amqp091-go v1.10.0
+)
 
 require (
 	filippo.io/edwards25519 v1.1.0 // indirect
@@ -23,7 +26,6 @@ require (
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/mattn/go-runewidth v0.0.16 // indirect
 	github.com/pelletier/go-toml/v2 v2.2.3 // indirect
-	github.com/rabbitmq/amqp091-go v1.10.0 // indirect
 	github.com/rivo/uniseg v0.2.0 // indirect
 	github.com/sagikazarmark/locafero v0.7.0 // indirect
 	github.com/sourcegraph/conc v0.3.0 // indirect
@@ -6,6 +6,7 @@ import (
 	"sync"
 
 	"github.com/jirbthagoras/hon/shared"
+	"github.com/rabbitmq/amqp091-go"
 )
 
 type ConsumerHandler struct {
@@ -23,57 +24,64 @@ func NewConsumerHandler(amqp *shared.AMQP, service *ConsumerService, wg *sync.Wa
 }
 
 func (h *ConsumerHandler) BundleConsumer() []func() {
-	return []func(){h.handleGoal, h.handleDeadline}
+	return []func(){h.handleGoal, h.handleDeadline, h.handleCancelled, h.handleVerification, h.handlePasswordReset, h.handleLockout, h.handleImport, h.handleMetadata}
 }
 
 func (h *ConsumerHandler) handleGoal() {
-	// Generate Agent
-	agent, err := shared.NewAgent(h.AMQP, context.Background())
-	if err != nil {
-		slog.Error("Error when creating agent")
-		panic(err)
-	}
+	h.listen("goal_queue", "goal-consumer", h.Service.SendGoalEmail)
+}
 
-	// Generate Consumer
-	goalConsumer, err := agent.NewConsumer("goal_queue", "goal-consumer")
-	if err != nil {
-		slog.Error("Error when creating consumer")
-		panic(err)
-	}
+func (h *ConsumerHandler) handleDeadline() {
+	h.listen("deadline_queue", "deadline-consumer", h.Service.SendDeadlineEmail)
+}
 
-	// Make the consumer listens
-	for message := range goalConsumer {
-		err = h.Service.SendGoalEmail(&message)
-		if err != nil {
-			slog.Error(err.Error())
-		}
-	}
+func (h *ConsumerHandler) handleCancelled() {
+	h.listen("cancelled_queue", "cancelled-consumer", h.Service.SendCancelledEmail)
+}
 
-	defer h.WG.Done()
+func (h *ConsumerHandler) handleVerification() {
+	h.listen("verification_queue", "verification-consumer", h.Service.SendVerificationEmail)
 }
 
-func (h *ConsumerHandler) handleDeadline() {
+func (h *ConsumerHandler) handlePasswordReset() {
+	h.listen("password_reset_queue", "password-reset-consumer", h.Service.SendPasswordResetEmail)
+}
+
+func (h *ConsumerHandler) handleLockout() {
+	h.listen("lockout_queue", "lockout-consumer", h.Service.SendLockoutEmail)
+}
+
+func (h *ConsumerHandler) handleImport() {
+	h.listen("import_queue", "import-consumer", h.Service.ImportReadingHistory)
+}
+
+func (h *ConsumerHandler) handleMetadata() {
+	h.listen("metadata_queue", "metadata-consumer", h.Service.FetchBookMetadata)
+}
+
+// listen is the same loop for every queue: one agent, one consumer, feed every message to the service.
+func (h *ConsumerHandler) listen(queue string, consumerName string, handle func(*amqp091.Delivery) error) {
+	defer h.WG.Done()
+
 	// Generate Agent
 	agent, err := shared.NewAgent(h.AMQP, context.Background())
 	if err != nil {
-		slog.Error("Error when creating agent: Deadline")
+		slog.Error("Error when creating agent", "queue", queue)
 		panic(err)
 	}
 
 	// Generate Consumer
-	goalConsumer, err := agent.NewConsumer("deadline_queue", "deadline-consumer")
+	consumer, err := agent.NewConsumer(queue, consumerName)
 	if err != nil {
-		slog.Error("Error when creating consumer: Deadline")
+		slog.Error("Error when creating consumer", "queue", queue)
 		panic(err)
 	}
 
 	// Make the consumer listens
-	for message := range goalConsumer {
-		err = h.Service.SendDeadlineEmail(&message)
+	for message := range consumer {
+		err = handle(&message)
 		if err != nil {
-			slog.Error(err.Error())
+			slog.Error(err.Error(), "queue", queue)
 		}
 	}
-
-	defer h.WG.Done()
 }
@@ -7,8 +7,11 @@ import (
 	_ "embed"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"html/template"
 	"log/slog"
+	"strings"
+	"time"
 
 	"github.com/jirbthagoras/hon/shared"
 	"github.com/rabbitmq/amqp091-go"
@@ -41,6 +44,16 @@ func (s *ConsumerService) SendGoalEmail(msg *amqp091.Delivery) error {
 		return err
 	}
 
+	// The owner may have deleted the goal or their account since
+	_, err = s.checkGoal(goalMsg)
+	if errors.Is(err, errGoalGone) {
+		slog.Info("Goal or its owner is gone, dropping congratulation", "goal_id", goalMsg.Id)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
 	// parse the html template
 	templ, err := template.New("congratulation").Parse(Congratulation)
 	if err != nil {
@@ -85,6 +98,10 @@ func (s *ConsumerService) SendDeadlineEmail(msg *amqp091.Delivery) error {
 
 	// Checks the goal first, is it finished or in-progress?
 	status, err := s.checkGoal(deadlineMsg)
+	if errors.Is(err, errGoalGone) {
+		slog.Info("Goal or its owner is gone, dropping deadline", "goal_id", deadlineMsg.Id)
+		return nil
+	}
 	if err != nil {
 		return err
 	}
@@ -94,9 +111,23 @@ func (s *ConsumerService) SendDeadlineEmail(msg *amqp091.Delivery) error {
 		return errors.New("The Goal is finished, nothing to do")
 	}
 
-	err = s.SetGoalStatus("expired", deadlineMsg.Id)
-	if err != nil {
-		return err
+	// The book got abandoned before the deadline, the owner already got the cancellation email
+	if status == "cancelled" {
+		slog.Info("Goal cancelled, dropping deadline", "goal_id", deadlineMsg.Id)
+		return nil
+	}
+
+	// An admin re-sending the email of an already expired goal, anything else is a duplicate
+	if status == "expired" {
+		if retrigger, _ := msg.Headers[shared.HeaderRetrigger].(bool); !retrigger {
+			slog.Info("Goal already expired, dropping duplicate deadline", "goal_id", deadlineMsg.Id)
+			return nil
+		}
+	} else {
+		err = s.SetGoalStatus("expired", deadlineMsg.Id)
+		if err != nil {
+			return err
+		}
 	}
 
 	// parse the html template
@@ -127,12 +158,199 @@ func (s *ConsumerService) SendDeadlineEmail(msg *amqp091.Delivery) error {
 	return nil
 }
 
+//go:embed templates/cancelled.html
+var Cancelled string
+
+// service to tell the owner their goal got cancelled along with the book
+func (s *ConsumerService) SendCancelledEmail(msg *amqp091.Delivery) error {
+	// Create var to contain the message
+	cancelledMsg := &shared.Msg{}
+
+	// Parse the json cihuy
+	err := json.Unmarshal(msg.Body, cancelledMsg)
+	if err != nil {
+		return err
+	}
+
+	// The owner may have deleted the goal or their account since
+	status, err := s.checkGoal(cancelledMsg)
+	if errors.Is(err, errGoalGone) {
+		slog.Info("Goal or its owner is gone, dropping cancellation", "goal_id", cancelledMsg.Id)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if status != "cancelled" {
+		slog.Info("Goal is not cancelled, dropping cancellation", "goal_id", cancelledMsg.Id, "status", status)
+		return nil
+	}
+
+	// parse the html template
+	templ, err := template.New("cancelled").Parse(Cancelled)
+	if err != nil {
+		return err
+	}
+
+	// Inject the msg to the templ var
+	var body bytes.Buffer
+	if err := templ.Execute(&body, cancelledMsg); err != nil {
+		return err
+	}
+
+	// Make the email data that will be injected to Mailer
+	emailData := SendMail{
+		To:      cancelledMsg.Email,
+		Subject: "Hon Goal Cancelled",
+		Body:    body.String(),
+	}
+
+	if err := s.Mailer.SendMail(&emailData); err != nil {
+		return err
+	}
+
+	slog.Info("Email sent successfully", "to", cancelledMsg.Email, "subject", emailData.Subject)
+
+	return nil
+}
+
+//go:embed templates/verification.html
+var Verification string
+
+// service to send the email verification link
+func (s *ConsumerService) SendVerificationEmail(msg *amqp091.Delivery) error {
+	// Create var to contain the message
+	verificationMsg := &shared.VerificationMsg{}
+
+	// Parse the json cihuy
+	err := json.Unmarshal(msg.Body, verificationMsg)
+	if err != nil {
+		return err
+	}
+
+	// parse the html template
+	templ, err := template.New("verification").Parse(Verification)
+	if err != nil {
+		return err
+	}
+
+	// Inject the msg to the templ var
+	var body bytes.Buffer
+	if err := templ.Execute(&body, verificationMsg); err != nil {
+		return err
+	}
+
+	// Make the email data that will be injected to Mailer
+	emailData := SendMail{
+		To:      verificationMsg.Email,
+		Subject: "Hon Email Verification",
+		Body:    body.String(),
+	}
+
+	if err := s.Mailer.SendMail(&emailData); err != nil {
+		return err
+	}
+
+	slog.Info("Email sent successfully", "to", verificationMsg.Email, "subject", emailData.Subject)
+
+	return nil
+}
+
+//go:embed templates/password_reset.html
+var PasswordReset string
+
+// service to send the password reset token
+func (s *ConsumerService) SendPasswordResetEmail(msg *amqp091.Delivery) error {
+	// Create var to contain the message
+	resetMsg := &shared.PasswordResetMsg{}
+
+	// Parse the json cihuy
+	err := json.Unmarshal(msg.Body, resetMsg)
+	if err != nil {
+		return err
+	}
+
+	// parse the html template
+	templ, err := template.New("password_reset").Parse(PasswordReset)
+	if err != nil {
+		return err
+	}
+
+	// Inject the msg to the templ var
+	var body bytes.Buffer
+	if err := templ.Execute(&body, resetMsg); err != nil {
+		return err
+	}
+
+	// Make the email data that will be injected to Mailer
+	emailData := SendMail{
+		To:      resetMsg.Email,
+		Subject: "Hon Password Reset",
+		Body:    body.String(),
+	}
+
+	if err := s.Mailer.SendMail(&emailData); err != nil {
+		return err
+	}
+
+	slog.Info("Email sent successfully", "to", resetMsg.Email, "subject", emailData.Subject)
+
+	return nil
+}
+
+//go:embed templates/lockout.html
+var Lockout string
+
+// service to warn the owner their account got locked after failed logins
+func (s *ConsumerService) SendLockoutEmail(msg *amqp091.Delivery) error {
+	// Create var to contain the message
+	lockoutMsg := &shared.LockoutMsg{}
+
+	// Parse the json cihuy
+	err := json.Unmarshal(msg.Body, lockoutMsg)
+	if err != nil {
+		return err
+	}
+
+	// parse the html template
+	templ, err := template.New("lockout").Parse(Lockout)
+	if err != nil {
+		return err
+	}
+
+	// Inject the msg to the templ var
+	var body bytes.Buffer
+	if err := templ.Execute(&body, lockoutMsg); err != nil {
+		return err
+	}
+
+	// Make the email data that will be injected to Mailer
+	emailData := SendMail{
+		To:      lockoutMsg.Email,
+		Subject: "Hon Account Locked",
+		Body:    body.String(),
+	}
+
+	if err := s.Mailer.SendMail(&emailData); err != nil {
+		return err
+	}
+
+	slog.Info("Email sent successfully", "to", lockoutMsg.Email, "subject", emailData.Subject)
+
+	return nil
+}
+
+// errGoalGone means the goal was deleted, or its owner deleted their account or asked for it to be deleted.
+// Nothing goes out for those, the message is simply dropped.
+var errGoalGone = errors.New("goal or its owner no longer exists")
+
 func (s *ConsumerService) checkGoal(msg *shared.Msg) (string, error) {
 	// Init var
 	var status string
+	var pendingDeletion bool
 
 	// make a query
-	query := "SELECT status FROM goals WHERE id = ?"
+	query := "SELECT g.status, u.deletion_scheduled_at IS NOT NULL FROM goals g JOIN users u ON u.id = g.user_id WHERE g.id = ?"
 
 	// tx stuffs
 	tx, err := s.DB.Begin()
@@ -142,19 +360,19 @@ func (s *ConsumerService) checkGoal(msg *shared.Msg) (string, error) {
 		return status, err
 	}
 
-	// Query and checks if the book exist
-	err = tx.QueryRowContext(context.Background(), query, msg.Id).Scan(&status)
+	// Query and checks if the goal still exist
+	err = tx.QueryRowContext(context.Background(), query, msg.Id).Scan(&status, &pendingDeletion)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			slog.Error("Book not found", "err", err)
-			return status, err
+			return status, errGoalGone
 		}
-
-	}
-	if err != nil {
 		return status, err
 	}
 
+	if pendingDeletion {
+		return status, errGoalGone
+	}
+
 	return status, nil
 }
 
@@ -191,3 +409,385 @@ func (s *ConsumerService) SetGoalStatus(status string, goalId int) error {
 
 	return nil
 }
+
+//go:embed templates/import_summary.html
+var ImportSummaryTemplate string
+
+// importFailureLimit caps the failed rows kept on a job, a broken export could otherwise fail every row
+const importFailureLimit = 50
+
+// service to import a Goodreads or StoryGraph export and mail the summary
+func (s *ConsumerService) ImportReadingHistory(msg *amqp091.Delivery) (err error) {
+	// Create var to contain the message
+	importMsg := &shared.ImportMsg{}
+
+	// Parse the json cihuy
+	err = json.Unmarshal(msg.Body, importMsg)
+	if err != nil {
+		return err
+	}
+
+	// Claim the job, a redelivered message finds it running or done already
+	query := "UPDATE import_jobs SET status = 'running', started_at = ? WHERE id = ? AND status = 'queued'"
+
+	result, err := s.DB.ExecContext(context.Background(), query, time.Now(), importMsg.JobId)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		slog.Info("Import already picked up or gone, dropping", "job_id", importMsg.JobId)
+		return nil
+	}
+
+	// The job is ours now, messages are auto acked so nobody else will finish it when we fail
+	saved := false
+	defer func() {
+		if err != nil && !saved {
+			s.failImportJob(importMsg.JobId)
+		}
+	}()
+
+	// Load the export that waits in the job
+	var userId int
+	var source string
+	var payload sql.NullString
+
+	query = "SELECT user_id, source, payload FROM import_jobs WHERE id = ?"
+
+	err = s.DB.QueryRowContext(context.Background(), query, importMsg.JobId).Scan(&userId, &source, &payload)
+	if err != nil {
+		return err
+	}
+
+	summary := &ImportSummary{
+		ImportMsg: *importMsg,
+		Source:    shared.ImportSourceNames[source],
+		Failures:  []string{},
+	}
+
+	status := "completed"
+	err = s.importBooks(userId, payload.String, summary)
+	if err != nil {
+		slog.Error("Import stopped", "job_id", importMsg.JobId, "err", err)
+		status = "failed"
+		summary.Error = "Something went wrong while importing, the books imported before it happened are kept. Importing the same file again skips them."
+	}
+
+	// Save how it went, the export itself is not needed anymore
+	failures, err := json.Marshal(summary.Failures)
+	if err != nil {
+		return err
+	}
+
+	query = `UPDATE import_jobs SET status = ?, imported = ?, skipped = ?, failed = ?, failures = ?, error = ?, finished_at = ?, payload = NULL
+		WHERE id = ?`
+
+	_, err = s.DB.ExecContext(context.Background(), query,
+		status,
+		summary.Imported,
+		summary.Skipped,
+		summary.Failed,
+		string(failures),
+		sql.NullString{String: summary.Error, Valid: summary.Error != ""},
+		time.Now(),
+		importMsg.JobId,
+	)
+	if err != nil {
+		return err
+	}
+	saved = true
+
+	// parse the html template
+	templ, err := template.New("import_summary").Parse(ImportSummaryTemplate)
+	if err != nil {
+		return err
+	}
+
+	// Inject the msg to the templ var
+	var body bytes.Buffer
+	if err := templ.Execute(&body, summary); err != nil {
+		return err
+	}
+
+	// Make the email data that will be injected to Mailer
+	emailData := SendMail{
+		To:      importMsg.Email,
+		Subject: "Hon Import Finished",
+		Body:    body.String(),
+	}
+
+	if err := s.Mailer.SendMail(&emailData); err != nil {
+		return err
+	}
+
+	slog.Info("Email sent successfully", "to", importMsg.Email, "subject", emailData.Subject)
+
+	return nil
+}
+
+// failImportJob marks a job failed when the import broke off before saving how it went
+func (s *ConsumerService) failImportJob(jobId int) {
+	query := `UPDATE import_jobs SET status = 'failed', finished_at = ?, payload = NULL,
+		error = 'Something went wrong while importing, the books imported before it happened are kept. Importing the same file again skips them.'
+		WHERE id = ? AND status = 'running'`
+
+	if _, err := s.DB.ExecContext(context.Background(), query, time.Now(), jobId); err != nil {
+		slog.Error("Error while marking import failed", "job_id", jobId, "err", err)
+	}
+}
+
+// importBooks saves every book of the export the user doesn't have yet.
+// A row that can't be saved is recorded on the summary, only a failure that stops the whole import is returned.
+func (s *ConsumerService) importBooks(userId int, payload string, summary *ImportSummary) error {
+	// The export dates are days in the user's timezone
+	source, books, err := shared.ParseReadingHistory(strings.NewReader(payload), shared.LoadLocation(summary.Timezone))
+	if err != nil {
+		return err
+	}
+	summary.Total = len(books)
+
+	// Books the user already has, the rows are matched on title and author, or on the ISBN when both have one
+	existing, err := s.getBookKeys(userId)
+	if err != nil {
+		return err
+	}
+
+	for _, book := range books {
+		if book.Title == "" {
+			summary.fail(book.Row, "the book has no title")
+			continue
+		}
+
+		// The same book twice in the file counts as already there too
+		key := importKey(book.Title, book.Author)
+		isbn, _ := shared.NormalizeISBN(book.ISBN)
+		if existing[key] || (isbn != "" && existing[isbn]) {
+			summary.Skipped++
+			continue
+		}
+		book.ISBN = isbn
+
+		err = s.importBook(userId, source, book)
+		if err != nil {
+			slog.Error("Error while importing book", "row", book.Row, "err", err)
+			summary.fail(book.Row, fmt.Sprintf("%q could not be saved", book.Title))
+			continue
+		}
+
+		existing[key] = true
+		if isbn != "" {
+			existing[isbn] = true
+		}
+		summary.Imported++
+	}
+
+	return nil
+}
+
+func (s *ConsumerService) getBookKeys(userId int) (map[string]bool, error) {
+	keys := map[string]bool{}
+
+	// Create a query
+	query := "SELECT title, author, isbn FROM books WHERE user_id = ?"
+
+	rows, err := s.DB.QueryContext(context.Background(), query, userId)
+	if err != nil {
+		return nil, err
+	}
+	// close the rows of course
+	defer rows.Close()
+
+	for rows.Next() {
+		var title, author, isbn sql.NullString
+		if err := rows.Scan(&title, &author, &isbn); err != nil {
+			return nil, err
+		}
+		keys[importKey(title.String, author.String)] = true
+		if isbn.Valid {
+			keys[isbn.String] = true
+		}
+	}
+
+	return keys, rows.Err()
+}
+
+// importKey is how a book is recognised, case and extra spaces don't make a different book
+func importKey(title string, author string) string {
+	normalize := func(value string) string {
+		return strings.Join(strings.Fields(strings.ToLower(value)), " ")
+	}
+
+	// Only the first author counts, exports differ in which co-authors they list
+	first := ""
+	if authors := shared.SplitAuthors(author); len(authors) > 0 {
+		first = shared.AuthorKey(authors[0])
+	}
+
+	return normalize(title) + "\x00" + first
+}
+
+// importBook saves one book with its first read.
+// A finished book gets a single progress over the whole book, dated the day it was read, so it counts in the stats.
+func (s *ConsumerService) importBook(userId int, source string, book *shared.ImportedBook) (err error) {
+	// Without a page count the book is followed in percent, that works for every format
+	unit, total := "percent", 100
+	if book.Format == "paper" && book.Pages > 0 {
+		unit, total = "pages", book.Pages
+	}
+
+	now := time.Now()
+	startedAt := firstDate(book.StartedAt, book.AddedAt, now)
+	var finishedAt *time.Time
+	if book.Status == "completed" {
+		finished := firstDate(book.FinishedAt, book.AddedAt, now)
+		// Goodreads only knows when the book got shelved, which can be long after it was read
+		if startedAt.After(finished) {
+			startedAt = finished
+		}
+		finishedAt = &finished
+	}
+
+	// tx stuffs
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = shared.CommitOrRollback(tx, err)
+	}()
+
+	bookId, runId, err := shared.InsertBook(context.Background(), tx, userId, shared.NewBook{
+		Title:      book.Title,
+		Author:     book.Author,
+		Isbn:       book.ISBN,
+		TotalPages: total,
+		Format:     book.Format,
+		Unit:       unit,
+		Status:     book.Status,
+		StartedAt:  startedAt,
+		FinishedAt: finishedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = shared.SetBookAuthors(context.Background(), tx, userId, bookId, shared.SplitAuthors(book.Author))
+	if err != nil {
+		return err
+	}
+
+	if finishedAt == nil {
+		return nil
+	}
+
+	// Create a query
+	query := "INSERT INTO progresses (book_id, run_id, from_page, until_page, description, created_at) VALUES (?, ?, 0, ?, ?, ?)"
+
+	_, err = tx.ExecContext(context.Background(), query, bookId, runId, total, "Read before Hon, imported from "+shared.ImportSourceNames[source], *finishedAt)
+	return err
+}
+
+// firstDate is the first of the dates the export actually had
+func firstDate(dates ...time.Time) time.Time {
+	for _, date := range dates {
+		if !date.IsZero() {
+			return date
+		}
+	}
+
+	return time.Time{}
+}
+
+// service to fill in a book the metadata provider was too slow for when it got created.
+// Whatever the user changed meanwhile stays, only the placeholders are replaced.
+func (s *ConsumerService) FetchBookMetadata(msg *amqp091.Delivery) error {
+	// Create var to contain the message
+	metadataMsg := &shared.MetadataMsg{}
+
+	// Parse the json cihuy
+	err := json.Unmarshal(msg.Body, metadataMsg)
+	if err != nil {
+		return err
+	}
+
+	provider := shared.GetMetadataProvider()
+	if provider == nil {
+		return s.finishMetadata(metadataMsg.BookId, nil)
+	}
+
+	// The consumer has time to wait, unlike the request that created the book
+	ctx, cancel := context.WithTimeout(context.Background(), shared.GetDuration("METADATA_FETCH_TIMEOUT", 30*time.Second))
+	defer cancel()
+
+	meta, err := shared.LookupMetadata(ctx, s.DB, provider, metadataMsg.ISBN)
+	if err != nil {
+		// Messages aren't redelivered, the book is left for the user to fill in
+		slog.Error("Metadata lookup failed", "isbn", metadataMsg.ISBN, "err", err)
+		return s.finishMetadata(metadataMsg.BookId, nil)
+	}
+
+	return s.finishMetadata(metadataMsg.BookId, meta)
+}
+
+// finishMetadata replaces the placeholders with meta, nil only ends the wait.
+// The authors get credited here when the placeholder author was replaced, the producer leaves that to us.
+func (s *ConsumerService) finishMetadata(bookId int, meta *shared.BookMetadata) (err error) {
+	if meta == nil {
+		_, err := s.DB.ExecContext(context.Background(), "UPDATE books SET metadata_pending = FALSE WHERE id = ?", bookId)
+		return err
+	}
+
+	// tx stuffs
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = shared.CommitOrRollback(tx, err)
+	}()
+
+	// The user may have filled it in or deleted the book meanwhile
+	var userId int
+	var author string
+	err = tx.QueryRowContext(context.Background(), "SELECT user_id, COALESCE(author, '') FROM books WHERE id = ? AND metadata_pending FOR UPDATE", bookId).
+		Scan(&userId, &author)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Create a query
+	query := `UPDATE books SET
+		title = IF(title = ?, ?, title),
+		author = IF(author = ?, ?, author),
+		total_pages = IF(total_pages = 0 AND unit = 'pages', ?, total_pages),
+		metadata_pending = FALSE
+		WHERE id = ?`
+
+	_, err = tx.ExecContext(context.Background(), query,
+		shared.PlaceholderTitle(meta.ISBN), meta.Title,
+		shared.PlaceholderAuthor, meta.Author,
+		meta.Pages,
+		bookId,
+	)
+	if err != nil {
+		return err
+	}
+
+	if author == shared.PlaceholderAuthor {
+		err = shared.SetBookAuthors(context.Background(), tx, userId, bookId, shared.SplitAuthors(meta.Author))
+		if err != nil {
+			return err
+		}
+	}
+
+	slog.Info("Book metadata filled in", "book_id", bookId, "isbn", meta.ISBN)
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log/slog"
+)
+
+// accountArchive is what the account export writes into, every entry is one JSON document
+type accountArchive interface {
+	Entry(name string) (io.Writer, error)
+	Close() error
+}
+
+// zipArchive puts every entry in its own .json file
+type zipArchive struct {
+	zw *zip.Writer
+}
+
+func newZipArchive(w io.Writer) *zipArchive {
+	return &zipArchive{zw: zip.NewWriter(w)}
+}
+
+func (a *zipArchive) Entry(name string) (io.Writer, error) {
+	return a.zw.Create(name + ".json")
+}
+
+func (a *zipArchive) Close() error {
+	return a.zw.Close()
+}
+
+// jsonArchive writes a single JSON object, every entry becomes one of its keys
+type jsonArchive struct {
+	w       io.Writer
+	entries int
+}
+
+func newJSONArchive(w io.Writer) *jsonArchive {
+	return &jsonArchive{w: w}
+}
+
+func (a *jsonArchive) Entry(name string) (io.Writer, error) {
+	sep := ","
+	if a.entries == 0 {
+		sep = "{"
+	}
+	a.entries++
+
+	key, err := json.Marshal(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.WriteString(a.w, sep+string(key)+":"); err != nil {
+		return nil, err
+	}
+
+	return a.w, nil
+}
+
+func (a *jsonArchive) Close() error {
+	if a.entries == 0 {
+		_, err := io.WriteString(a.w, "{}")
+		return err
+	}
+
+	_, err := io.WriteString(a.w, "}")
+	return err
+}
+
+// exportRows streams the rows of the query into the archive entry as a JSON array
+func exportRows[T any](tx *sql.Tx, archive accountArchive, name string, query string, userId int, fields func(*T) []any) error {
+	w, err := archive.Entry(name)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.QueryContext(context.Background(), query, userId)
+	if err != nil {
+		slog.Error("Eror while query", "err", err)
+		return err
+	}
+
+	// close the rows of course
+	defer rows.Close()
+
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	for i := 0; rows.Next(); i++ {
+		var item T
+		if err := rows.Scan(fields(&item)...); err != nil {
+			slog.Error("Error querying", "err", err)
+			return err
+		}
+
+		if i > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		if err := enc.Encode(item); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "]")
+	return err
+}
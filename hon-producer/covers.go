@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"regexp"
+	"strings"
+
+	// Decoders for the cover types image.Decode has to understand
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/jirbthagoras/hon/shared"
+)
+
+// coverTypes are the sniffed content types a cover can be, with the extension it is stored under
+var coverTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+const (
+	// thumbnailWidth is how wide thumbnails are, book lists and emails don't need more
+	thumbnailWidth = 300
+	// coverMaxPixels keeps a tiny file claiming to be a huge image from eating the memory while decoding
+	coverMaxPixels = 40_000_000
+)
+
+// coverNamePattern is what a cover name in a url can look like, anything else never was a cover
+var coverNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{22}(-thumb)?\.(jpg|png|gif)$`)
+
+// coverURL is the public address of a stored cover, nil when the book has none
+func coverURL(key sql.NullString) *string {
+	if !key.Valid {
+		return nil
+	}
+
+	url := appURL("/api/covers/" + strings.TrimPrefix(key.String, "covers/"))
+	return &url
+}
+
+// newCoverKeys makes the keys of a new cover and its thumbnail, every upload gets new ones so they can be cached forever
+func newCoverKeys(extension string) (string, string, error) {
+	id, err := shared.GenerateOpaqueToken(16)
+	if err != nil {
+		return "", "", err
+	}
+
+	return "covers/" + id + extension, "covers/" + id + "-thumb.jpg", nil
+}
+
+// makeThumbnail scales img down to thumbnailWidth wide as a JPEG, every thumbnail pixel is the average of the pixels it covers.
+// Images already narrower are only re-encoded.
+func makeThumbnail(img image.Image) ([]byte, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	thumbWidth, thumbHeight := width, height
+	if width > thumbnailWidth {
+		thumbWidth = thumbnailWidth
+		thumbHeight = max(1, height*thumbnailWidth/width)
+	}
+
+	thumb := image.NewRGBA(image.Rect(0, 0, thumbWidth, thumbHeight))
+	for y := 0; y < thumbHeight; y++ {
+		y0 := bounds.Min.Y + y*height/thumbHeight
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*height/thumbHeight)
+
+		for x := 0; x < thumbWidth; x++ {
+			x0 := bounds.Min.X + x*width/thumbWidth
+			x1 := max(x0+1, bounds.Min.X+(x+1)*width/thumbWidth)
+
+			var r, g, b, a, count uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					count++
+				}
+			}
+
+			// JPEG has no transparency, transparent parts come out white
+			alpha := a / count
+			white := 0xffff - alpha
+			thumb.Set(x, y, color.RGBA64{
+				R: uint16(r/count + white),
+				G: uint16(g/count + white),
+				B: uint16(b/count + white),
+				A: 0xffff,
+			})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"testing"
+)
+
+// solid is a w by h image of a single color, with its top left corner at origin
+func solid(origin image.Point, w int, h int, c color.Color) image.Image {
+	img := image.NewNRGBA(image.Rectangle{Min: origin, Max: origin.Add(image.Pt(w, h))})
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: c}, image.Point{}, draw.Src)
+	return img
+}
+
+func TestMakeThumbnail(t *testing.T) {
+	red := color.NRGBA{R: 0xff, A: 0xff}
+
+	tests := []struct {
+		name       string
+		img        image.Image
+		wantWidth  int
+		wantHeight int
+		// wantColor is what the middle pixel should be, give or take what JPEG loses
+		wantColor color.NRGBA
+	}{
+		{name: "wide cover is scaled down", img: solid(image.Point{}, 1200, 1800, red), wantWidth: 300, wantHeight: 450, wantColor: red},
+		{name: "narrow cover keeps its size", img: solid(image.Point{}, 120, 180, red), wantWidth: 120, wantHeight: 180, wantColor: red},
+		{name: "exactly thumbnail wide", img: solid(image.Point{}, 300, 300, red), wantWidth: 300, wantHeight: 300, wantColor: red},
+		{name: "a strip keeps at least one row", img: solid(image.Point{}, 3000, 2, red), wantWidth: 300, wantHeight: 1, wantColor: red},
+		{name: "bounds not at the origin", img: solid(image.Pt(50, 70), 600, 900, red), wantWidth: 300, wantHeight: 450, wantColor: red},
+		{name: "transparent comes out white", img: solid(image.Point{}, 600, 600, color.NRGBA{}), wantWidth: 300, wantHeight: 300, wantColor: color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}},
+		{name: "half transparent is blended with white", img: solid(image.Point{}, 600, 600, color.NRGBA{A: 0x80}), wantWidth: 300, wantHeight: 300, wantColor: color.NRGBA{R: 0x7f, G: 0x7f, B: 0x7f, A: 0xff}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := makeThumbnail(tt.img)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			thumb, err := jpeg.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("thumbnail isn't a JPEG: %v", err)
+			}
+
+			bounds := thumb.Bounds()
+			if bounds.Dx() != tt.wantWidth || bounds.Dy() != tt.wantHeight {
+				t.Errorf("size = %dx%d, want %dx%d", bounds.Dx(), bounds.Dy(), tt.wantWidth, tt.wantHeight)
+			}
+
+			got := color.NRGBAModel.Convert(thumb.At(bounds.Dx()/2, bounds.Dy()/2)).(color.NRGBA)
+			if !near(got.R, tt.wantColor.R) || !near(got.G, tt.wantColor.G) || !near(got.B, tt.wantColor.B) {
+				t.Errorf("middle pixel = %v, want about %v", got, tt.wantColor)
+			}
+		})
+	}
+}
+
+func near(got uint8, want uint8) bool {
+	diff := int(got) - int(want)
+	return diff >= -8 && diff <= 8
+}
@@ -1,6 +1,10 @@
 package main
 
-import "time"
+import (
+	"time"
+
+	"github.com/jirbthagoras/hon/shared"
+)
 
 type RequestAuthUser struct {
 	Id       int
@@ -8,40 +12,434 @@ type RequestAuthUser struct {
 	Password string `json:"password" validate:"required,min=6,max=30"`
 }
 
+type RequestRefreshToken struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+type RequestVerifyEmail struct {
+	Token string `json:"token" query:"token" validate:"required"`
+}
+
+type RequestForgotPassword struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type RequestResetPassword struct {
+	Token                string `json:"token" validate:"required"`
+	Password             string `json:"password" validate:"required,min=6,max=30"`
+	PasswordConfirmation string `json:"password_confirmation" validate:"required,eqfield=Password"`
+}
+
+type RequestChangePassword struct {
+	CurrentPassword      string `json:"current_password" validate:"required"`
+	Password             string `json:"password" validate:"required,min=6,max=30"`
+	PasswordConfirmation string `json:"password_confirmation" validate:"required,eqfield=Password"`
+}
+
+type RequestTwoFactorLogin struct {
+	ChallengeToken string `json:"challenge_token" validate:"required"`
+	Code           string `json:"code" validate:"required_without=RecoveryCode"`
+	RecoveryCode   string `json:"recovery_code"`
+}
+
+type RequestTwoFactorCode struct {
+	Code string `json:"code" validate:"required,len=6,numeric"`
+}
+
+type RequestDisableTwoFactor struct {
+	Password string `json:"password" validate:"required"`
+	Code     string `json:"code" validate:"required,len=6,numeric"`
+}
+
+type ResponseTwoFactorEnrollment struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauth_uri"`
+}
+
+type ResponseTokens struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+type ResponseGetSession struct {
+	Id         int       `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IpAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+type RequestCreateAPIKey struct {
+	Name      string     `json:"name" validate:"required,max=100"`
+	ReadOnly  bool       `json:"read_only"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type ResponseCreateAPIKey struct {
+	Id        int        `json:"id"`
+	Name      string     `json:"name"`
+	Key       string     `json:"key"`
+	ReadOnly  bool       `json:"read_only"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type ResponseGetAPIKey struct {
+	Id         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	ReadOnly   bool       `json:"read_only"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	Expired    bool       `json:"expired"`
+}
+
+type RequestAdminGetUsers struct {
+	Search string `json:"search" query:"search" validate:"max=255"`
+	Role   string `json:"role" query:"role" validate:"omitempty,oneof=user admin"`
+	Limit  int    `json:"limit" query:"limit" validate:"min=0,max=200"`
+	Offset int    `json:"offset" query:"offset" validate:"min=0"`
+}
+
+type ResponseAdminUser struct {
+	Id                  int        `json:"id"`
+	Email               string     `json:"email"`
+	Role                string     `json:"role"`
+	VerifiedAt          *time.Time `json:"verified_at"`
+	TwoFactorEnabled    bool       `json:"two_factor_enabled"`
+	DisabledAt          *time.Time `json:"disabled_at"`
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at"`
+	CreatedAt           time.Time  `json:"created_at"`
+	Books               int        `json:"books"`
+	Goals               int        `json:"goals"`
+}
+
+type RequestAdminSetRole struct {
+	Role string `json:"role" validate:"required,oneof=user admin"`
+}
+
+type RequestDeleteAccount struct {
+	Password string `json:"password" validate:"required"`
+	Code     string `json:"code" validate:"omitempty,len=6,numeric"`
+}
+
+type RequestExportAccount struct {
+	Format string `json:"format" query:"format" validate:"omitempty,oneof=zip json"`
+}
+
+type ResponseProfile struct {
+	Id               int        `json:"id"`
+	Email            string     `json:"email"`
+	DisplayName      *string    `json:"display_name"`
+	Timezone         string     `json:"timezone"`
+	Locale           string     `json:"locale"`
+	DateFormat       string     `json:"date_format"`
+	VerifiedAt       *time.Time `json:"verified_at"`
+	TwoFactorEnabled bool       `json:"two_factor_enabled"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+type RequestUpdateProfile struct {
+	DisplayName *string `json:"display_name" validate:"omitempty,max=100"`
+	Timezone    *string `json:"timezone" validate:"omitempty,max=64"`
+	Locale      *string `json:"locale" validate:"omitempty,max=16"`
+	DateFormat  *string `json:"date_format" validate:"omitempty,max=16"`
+}
+
+type ExportAccount struct {
+	Id                  int        `json:"id"`
+	Email               string     `json:"email"`
+	Role                string     `json:"role"`
+	VerifiedAt          *time.Time `json:"verified_at"`
+	TwoFactorEnabled    bool       `json:"two_factor_enabled"`
+	CreatedAt           time.Time  `json:"created_at"`
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at"`
+}
+
+type ExportBook struct {
+	Id         int     `json:"id"`
+	Title      string  `json:"title"`
+	Author     string  `json:"author"`
+	Isbn       *string `json:"isbn"`
+	TotalPages int     `json:"total_pages"`
+	Format     string  `json:"format"`
+	Unit       string  `json:"unit"`
+	Status     string  `json:"status"`
+}
+
+type ExportProgress struct {
+	Id          int       `json:"id"`
+	BookId      int       `json:"book_id"`
+	RunId       int       `json:"run_id"`
+	FromPage    int       `json:"from_page"`
+	UntilPage   int       `json:"until_page"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type ExportGoal struct {
+	Id         int       `json:"id"`
+	BookId     int       `json:"book_id"`
+	RunId      int       `json:"run_id"`
+	Name       string    `json:"name"`
+	TargetPage int       `json:"target_page"`
+	Status     string    `json:"status"`
+	ExpiredAt  time.Time `json:"expired_at"`
+}
+
+type ExportReadingRun struct {
+	Id         int        `json:"id"`
+	BookId     int        `json:"book_id"`
+	Number     int        `json:"number"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+type ExportBookTag struct {
+	BookId int    `json:"book_id"`
+	Tag    string `json:"tag"`
+}
+
+type ExportSession struct {
+	Id         int        `json:"id"`
+	UserAgent  *string    `json:"user_agent"`
+	IpAddress  *string    `json:"ip_address"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt time.Time  `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+type ExportAPIKey struct {
+	Id         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	ReadOnly   bool       `json:"read_only"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
 type RequestCreateBook struct {
-	Title      string `json:"title" validate:"required,min=6,max=50"`
-	Author     string `json:"author" validate:"required"`
-	TotalPages int    `json:"total_pages" validate:"required"`
+	Title      string   `json:"title" validate:"required_without=Isbn,omitempty,min=6,max=50"`
+	Author     string   `json:"author" validate:"required_without_all=Isbn Authors,omitempty,max=255"`
+	TotalPages int      `json:"total_pages" validate:"min=0"`
+	Tags       []string `json:"tags" validate:"max=20,dive,max=50"`
+	Status     string   `json:"status" validate:"omitempty,oneof=want-to-read reading"`
+	Format     string   `json:"format" validate:"omitempty,oneof=paper ebook audiobook"`
+	Unit       string   `json:"unit" validate:"omitempty,oneof=pages percent location seconds"`
+	// Length is the total in the unit, e.g. "10:32:15" for an audiobook, it takes over total_pages when given
+	Length string `json:"length" validate:"max=20"`
+	// Isbn fills in whatever of title, author and total pages is left out, ISBN-10 or ISBN-13
+	Isbn string `json:"isbn" validate:"max=17"`
+	// Authors credits every author on their own, author is split on semicolons and ampersands otherwise
+	Authors        []string `json:"authors" validate:"max=10,dive,max=255"`
+	Series         string   `json:"series" validate:"max=255"`
+	SeriesPosition *float64 `json:"series_position" validate:"omitempty,min=0,max=9999"`
+}
+
+type RequestUpdateBook struct {
+	Title      *string `json:"title" validate:"omitempty,min=6,max=50"`
+	Author     *string `json:"author" validate:"omitempty,min=1,max=255"`
+	TotalPages *int    `json:"total_pages" validate:"omitempty,min=1"`
+	Length     *string `json:"length" validate:"omitempty,max=20"`
+	// Authors takes over author when both are given
+	Authors []string `json:"authors" validate:"omitempty,min=1,max=10,dive,max=255"`
+	// An empty series takes the book out of its series
+	Series         *string  `json:"series" validate:"omitempty,max=255"`
+	SeriesPosition *float64 `json:"series_position" validate:"omitempty,min=0,max=9999"`
+}
+
+type RequestSetBookStatus struct {
+	Status string `json:"status" validate:"required,oneof=want-to-read reading paused abandoned"`
+	// What happens to the in-progress goals when the book gets abandoned, cancel by default
+	Goals string `json:"goals" validate:"omitempty,oneof=cancel expire"`
+}
+
+type RequestListBooks struct {
+	Status string `json:"status" query:"status" validate:"omitempty,oneof=want-to-read reading paused completed abandoned"`
+	Author string `json:"author" query:"author" validate:"max=255"`
+	Format string `json:"format" query:"format" validate:"omitempty,oneof=paper ebook audiobook"`
+	Tags   string `json:"tags" query:"tags" validate:"max=500"`
+	Sort   string `json:"sort" query:"sort" validate:"omitempty,oneof=added title author total_pages series"`
+	Order  string `json:"order" query:"order" validate:"omitempty,oneof=asc desc"`
+	Limit  int    `json:"limit" query:"limit" validate:"min=0,max=100"`
+	Cursor string `json:"cursor" query:"cursor"`
+	// AuthorId and SeriesId are set by the author and series book lists too
+	AuthorId int `json:"author_id" query:"author_id" validate:"min=0"`
+	SeriesId int `json:"series_id" query:"series_id" validate:"min=0"`
 }
 
 type ResponseGetBooks struct {
-	Id         int    `json:"id"`
-	Title      string `json:"title"`
-	Author     string `json:"author"`
-	TotalPages int    `json:"total_pages"`
-	Status     string `json:"status"`
+	Id              int                   `json:"id"`
+	Title           string                `json:"title"`
+	Author          string                `json:"author"`
+	Isbn            *string               `json:"isbn"`
+	MetadataPending bool                  `json:"metadata_pending"`
+	CoverUrl        *string               `json:"cover_url"`
+	ThumbnailUrl    *string               `json:"thumbnail_url"`
+	FileUrl         *string               `json:"file_url"`
+	FileType        *string               `json:"file_type"`
+	TotalPages      int                   `json:"total_pages"`
+	Format          string                `json:"format"`
+	Unit            string                `json:"unit"`
+	Length          string                `json:"length"`
+	Status          string                `json:"status"`
+	Tags            []string              `json:"tags"`
+	Authors         []*ResponseBookAuthor `json:"authors"`
+	Series          *ResponseBookSeries   `json:"series"`
 }
 
 type ResponseGetBook struct {
-	Id         int                    `json:"id"`
-	Title      string                 `json:"title"`
-	Author     string                 `json:"author"`
-	TotalPages int                    `json:"total_pages"`
-	Status     string                 `json:"status"`
-	Progresses []*ResponseGetProgress `json:"progresses"`
+	Id                   int                    `json:"id"`
+	Title                string                 `json:"title"`
+	Author               string                 `json:"author"`
+	Isbn                 *string                `json:"isbn"`
+	MetadataPending      bool                   `json:"metadata_pending"`
+	CoverUrl             *string                `json:"cover_url"`
+	ThumbnailUrl         *string                `json:"thumbnail_url"`
+	FileUrl              *string                `json:"file_url"`
+	FileType             *string                `json:"file_type"`
+	TotalPages           int                    `json:"total_pages"`
+	Format               string                 `json:"format"`
+	Unit                 string                 `json:"unit"`
+	Length               string                 `json:"length"`
+	Status               string                 `json:"status"`
+	Tags                 []string               `json:"tags"`
+	Authors              []*ResponseBookAuthor  `json:"authors"`
+	Series               *ResponseBookSeries    `json:"series"`
+	Runs                 []*ResponseReadingRun  `json:"runs"`
+	Progresses           []*ResponseGetProgress `json:"progresses"`
+	ProgressesNextCursor *string                `json:"progresses_next_cursor"`
+}
+
+type ResponseBookAuthor struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// ResponseBookSeries is the series a book is in, Position is nil when its place in the series isn't known
+type ResponseBookSeries struct {
+	Id       int      `json:"id"`
+	Name     string   `json:"name"`
+	Position *float64 `json:"position"`
+}
+
+type ResponseReadingRun struct {
+	Id         int        `json:"id"`
+	Number     int        `json:"number"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	PagesRead  int        `json:"pages_read"`
+	Percent    float64    `json:"percent"`
+	Progresses int        `json:"progresses"`
+}
+
+type RequestTag struct {
+	Name string `json:"name" validate:"required,max=50"`
+}
+
+type ResponseTag struct {
+	Id        int       `json:"id"`
+	Name      string    `json:"name"`
+	Books     int       `json:"books"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type RequestSetBookTags struct {
+	Tags []string `json:"tags" validate:"max=20,dive,max=50"`
+}
+
+type RequestAuthor struct {
+	Name string `json:"name" validate:"required,max=255"`
+}
+
+type RequestMergeAuthor struct {
+	Into int `json:"into" validate:"required"`
+}
+
+type ResponseAuthor struct {
+	Id        int       `json:"id"`
+	Name      string    `json:"name"`
+	Books     int       `json:"books"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type RequestSeries struct {
+	Name string `json:"name" validate:"required,max=255"`
+}
+
+type ResponseSeries struct {
+	Id        int       `json:"id"`
+	Name      string    `json:"name"`
+	Books     int       `json:"books"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type RequestStats struct {
+	Tags string `json:"tags" query:"tags" validate:"max=500"`
+}
+
+// ResponseStats only counts pages for books counted in pages,
+// PercentRead is what compares across formats, 100 is one whole book.
+type ResponseStats struct {
+	Books         int                  `json:"books"`
+	BooksByStatus map[string]int       `json:"books_by_status"`
+	BooksByFormat map[string]int       `json:"books_by_format"`
+	PagesRead     int                  `json:"pages_read"`
+	PercentRead   float64              `json:"percent_read"`
+	Progresses    int                  `json:"progresses"`
+	Rereads       *ResponseRereadStats `json:"rereads"`
+	GoalsByStatus map[string]int       `json:"goals_by_status"`
+	Tags          []*ResponseTagStats  `json:"tags"`
+}
+
+// ResponseRereadStats counts the runs after a book's first read, the totals above include them too
+type ResponseRereadStats struct {
+	Started     int     `json:"started"`
+	Completed   int     `json:"completed"`
+	PagesRead   int     `json:"pages_read"`
+	PercentRead float64 `json:"percent_read"`
+}
+
+type ResponseTagStats struct {
+	Id            int            `json:"id"`
+	Name          string         `json:"name"`
+	Books         int            `json:"books"`
+	BooksByStatus map[string]int `json:"books_by_status"`
+	PagesRead     int            `json:"pages_read"`
+	PercentRead   float64        `json:"percent_read"`
 }
 
 type RequestCreateProgress struct {
 	UserId      int
 	BookId      int
-	UntilPage   int    `json:"until_page" validate:"required"`
+	UntilPage   int    `json:"until_page" validate:"required_without=Until"`
+	Until       string `json:"until" validate:"max=20"`
 	Description string `json:"description" validate:"required"`
 }
 
+type RequestListProgresses struct {
+	RunId  int    `json:"run_id" query:"run_id" validate:"min=0"`
+	From   string `json:"from" query:"from"`
+	To     string `json:"to" query:"to"`
+	Sort   string `json:"sort" query:"sort" validate:"omitempty,oneof=created until_page"`
+	Order  string `json:"order" query:"order" validate:"omitempty,oneof=asc desc"`
+	Limit  int    `json:"limit" query:"limit" validate:"min=0,max=100"`
+	Cursor string `json:"cursor" query:"cursor"`
+}
+
 type ResponseGetProgress struct {
 	Id          int       `json:"id"`
+	RunId       int       `json:"run_id"`
 	FromPage    int       `json:"from_page"`
 	UntilPage   int       `json:"until_page"`
+	From        string    `json:"from"`
+	Until       string    `json:"until"`
+	Percent     float64   `json:"percent"`
 	CreatedAt   time.Time `json:"created_at"`
 	Description string    `json:"Description"`
 }
@@ -49,16 +447,105 @@ type ResponseGetProgress struct {
 type RequestCreateGoal struct {
 	Id         int
 	UserId     int
-	BookId     int       `json:"book_id"`
-	Name       string    `json:"name" validate:"required,min=3"`
-	TargetPage int       `json:"target_page" validate:"required"`
-	ExpiredAt  time.Time `json:"expired_at" validate:"required"`
+	BookId     int             `json:"book_id"`
+	Name       string          `json:"name" validate:"required,min=3"`
+	TargetPage int             `json:"target_page" validate:"required_without=Target"`
+	Target     string          `json:"target" validate:"max=20"`
+	ExpiredAt  shared.UserTime `json:"expired_at"`
+}
+
+type RequestListGoals struct {
+	Status      string `json:"status" query:"status" validate:"omitempty,oneof=finished in-progress expired cancelled"`
+	BookId      int    `json:"book_id" query:"book_id" validate:"min=0"`
+	RunId       int    `json:"run_id" query:"run_id" validate:"min=0"`
+	ExpiredFrom string `json:"expired_from" query:"expired_from"`
+	ExpiredTo   string `json:"expired_to" query:"expired_to"`
+	Sort        string `json:"sort" query:"sort" validate:"omitempty,oneof=added expired_at target_page"`
+	Order       string `json:"order" query:"order" validate:"omitempty,oneof=asc desc"`
+	Limit       int    `json:"limit" query:"limit" validate:"min=0,max=100"`
+	Cursor      string `json:"cursor" query:"cursor"`
 }
 
 type ResponseGetGoal struct {
 	Id         int
+	BookId     int       `json:"book_id"`
+	RunId      int       `json:"run_id"`
 	Name       string    `json:"name"`
 	TargetPage int       `json:"target_page"`
+	Target     string    `json:"target"`
+	Percent    float64   `json:"percent"`
 	Status     string    `json:"status"`
 	ExpiredAt  time.Time `json:"expired_at"`
 }
+
+type RequestSearch struct {
+	Q      string `json:"q" query:"q" validate:"required,min=2,max=200"`
+	Type   string `json:"type" query:"type" validate:"omitempty,oneof=all books progresses"`
+	Limit  int    `json:"limit" query:"limit" validate:"min=0,max=50"`
+	Offset int    `json:"offset" query:"offset" validate:"min=0"`
+}
+
+type ResponseSearchHit struct {
+	Type       string     `json:"type"`
+	Score      float64    `json:"score"`
+	BookId     int        `json:"book_id"`
+	ProgressId *int       `json:"progress_id"`
+	Title      string     `json:"title"`
+	Author     string     `json:"author"`
+	Snippet    string     `json:"snippet"`
+	CreatedAt  *time.Time `json:"created_at"`
+}
+
+type ResponseImportJob struct {
+	Id         int        `json:"id"`
+	Source     string     `json:"source"`
+	Status     string     `json:"status"`
+	TotalRows  int        `json:"total_rows"`
+	Imported   int        `json:"imported"`
+	Skipped    int        `json:"skipped"`
+	Failed     int        `json:"failed"`
+	Failures   []string   `json:"failures"`
+	Error      *string    `json:"error"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+// RequestExportLibrary narrows the library export, From and To keep books read in the range and only their progresses in it
+type RequestExportLibrary struct {
+	Format string `json:"format" query:"format" validate:"omitempty,oneof=csv json markdown"`
+	Status string `json:"status" query:"status" validate:"omitempty,oneof=want-to-read reading paused completed abandoned"`
+	From   string `json:"from" query:"from"`
+	To     string `json:"to" query:"to"`
+}
+
+type ExportLibraryBook struct {
+	Id           int                      `json:"id"`
+	Title        string                   `json:"title"`
+	Author       string                   `json:"author"`
+	Isbn         *string                  `json:"isbn"`
+	CoverUrl     *string                  `json:"cover_url"`
+	ThumbnailUrl *string                  `json:"thumbnail_url"`
+	TotalPages   int                      `json:"total_pages"`
+	Format       string                   `json:"format"`
+	Unit         string                   `json:"unit"`
+	Length       string                   `json:"length"`
+	Status       string                   `json:"status"`
+	Tags         []string                 `json:"tags"`
+	ReadCount    int                      `json:"read_count"`
+	AddedAt      *time.Time               `json:"added_at"`
+	FinishedAt   *time.Time               `json:"finished_at"`
+	Progresses   []*ExportLibraryProgress `json:"progresses"`
+}
+
+type ExportLibraryProgress struct {
+	Id          int       `json:"id"`
+	RunId       int       `json:"run_id"`
+	FromPage    int       `json:"from_page"`
+	UntilPage   int       `json:"until_page"`
+	From        string    `json:"from"`
+	Until       string    `json:"until"`
+	Percent     float64   `json:"percent"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/jirbthagoras/hon/shared"
+)
+
+// bookFileTypes are the extensions of the book file types, the ones e-readers open
+var bookFileTypes = map[string]string{
+	"application/epub+zip": ".epub",
+	"application/pdf":      ".pdf",
+}
+
+// epubMimetype is how every EPUB starts: a zip whose first, uncompressed entry is the mimetype file
+var epubMimetype = []byte("mimetypeapplication/epub+zip")
+
+// sniffBookFile tells the type of a book file from its bytes, empty when it isn't one we take
+func sniffBookFile(data []byte) string {
+	contentType := http.DetectContentType(data)
+	if contentType == "application/zip" && len(data) >= 30+len(epubMimetype) && bytes.Equal(data[30:30+len(epubMimetype)], epubMimetype) {
+		return "application/epub+zip"
+	}
+	if _, ok := bookFileTypes[contentType]; ok {
+		return contentType
+	}
+
+	return ""
+}
+
+// getBookFileMaxBytes is the biggest book file accepted
+func getBookFileMaxBytes() int64 {
+	maxBytes := shared.NewConfig().GetInt64("BOOK_FILE_MAX_BYTES")
+	if maxBytes <= 0 {
+		return 20 * 1024 * 1024
+	}
+
+	return maxBytes
+}
+
+// serverBodyLimit fits the biggest upload, book file, cover or export, with room for the multipart around it.
+// It's never under Fiber's default 4MB.
+func serverBodyLimit() int {
+	biggest := max(getBookFileMaxBytes(), getCoverMaxBytes(), getImportMaxBytes())
+	return max(4*1024*1024, int(biggest)+64*1024)
+}
+
+// bookFileURL is where the book's file gets downloaded, nil when it has none
+func bookFileURL(bookId int, key sql.NullString) *string {
+	if !key.Valid {
+		return nil
+	}
+
+	url := appURL(fmt.Sprintf("/api/book/%d/file", bookId))
+	return &url
+}
+
+// bookFileName is the name a downloaded book file is saved under, the title with only ASCII letters and digits left
+func bookFileName(title string, contentType string) string {
+	name := strings.Join(strings.FieldsFunc(title, func(r rune) bool {
+		return r > unicode.MaxASCII || (!unicode.IsLetter(r) && !unicode.IsDigit(r))
+	}), "-")
+	if name == "" {
+		name = "book"
+	}
+
+	return name + bookFileTypes[contentType]
+}
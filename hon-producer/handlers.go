@@ -1,8 +1,12 @@
 package main
 
 import (
+	"bufio"
 	"errors"
+	"fmt"
+	"io"
 	"log/slog"
+	"net/url"
 	"strconv"
 	"time"
 
@@ -21,36 +25,1987 @@ func NewProducerHandler(v *validator.Validate, s *ProducerService) *ProducerHand
 }
 
 func (h *ProducerHandler) RegisterRoutes(router fiber.Router) {
+	// Anything that changes data needs the write scope, reading only needs a valid token
+	write := shared.RequireScopes(shared.ScopeWrite)
+	// Account management is off limits for API keys
+	session := shared.RequireSession
+
 	auth := router.Group("/auth")
 	auth.Post("/register", h.handleRegister)
 	auth.Post("/login", h.handleLogin)
+	auth.Post("/refresh", h.handleRefresh)
+	auth.Post("/logout", h.handleLogout)
+	auth.Get("/verify", h.handleVerifyEmail)
+	auth.Post("/verify", h.handleVerifyEmail)
+	auth.Post("/verify/resend", shared.AuthMiddleware, write, h.handleResendVerification)
+	auth.Post("/forgot-password", h.handleForgotPassword)
+	auth.Post("/reset-password", h.handleResetPassword)
+	auth.Put("/password", shared.AuthMiddleware, session, h.handleChangePassword)
+	auth.Get("/sessions", shared.AuthMiddleware, session, h.handleGetSessions)
+	auth.Delete("/sessions/:id", shared.AuthMiddleware, session, h.handleDeleteSession)
+
+	twoFactor := auth.Group("/2fa")
+	twoFactor.Post("/login", h.handleTwoFactorLogin)
+	twoFactor.Post("/enroll", shared.AuthMiddleware, session, h.handleTwoFactorEnroll)
+	twoFactor.Post("/confirm", shared.AuthMiddleware, session, h.handleTwoFactorConfirm)
+	twoFactor.Post("/disable", shared.AuthMiddleware, session, h.handleTwoFactorDisable)
+	twoFactor.Post("/recovery-codes", shared.AuthMiddleware, session, h.handleRegenerateRecoveryCodes)
+
+	keys := router.Group("/keys")
+	keys.Use(shared.AuthMiddleware, session)
+	keys.Post("/", h.handleCreateAPIKey)
+	keys.Get("/", h.handleGetAPIKeys)
+	keys.Delete("/:id", h.handleRevokeAPIKey)
+
+	// Operators only, the role is checked against the database on every request
+	admin := router.Group("/admin")
+	admin.Use(shared.AuthMiddleware, session, h.requireAdmin)
+	admin.Get("/users", h.handleAdminGetUsers)
+	admin.Get("/users/:id", h.handleAdminGetUser)
+	admin.Put("/users/:id/role", h.handleAdminSetRole)
+	admin.Post("/users/:id/disable", h.handleAdminDisableUser)
+	admin.Post("/users/:id/enable", h.handleAdminEnableUser)
+	admin.Get("/users/:id/books", h.handleAdminGetUserBooks)
+	admin.Get("/users/:id/books/:bookId", h.handleAdminGetUserBook)
+	admin.Get("/users/:id/goals", h.handleAdminGetUserGoals)
+	admin.Post("/goals/:id/expire", h.handleAdminExpireGoal)
+	admin.Post("/goals/:id/notify", h.handleAdminNotifyGoal)
+
+	account := router.Group("/account")
+	account.Get("/profile", shared.AuthMiddleware, h.handleGetProfile)
+	account.Patch("/profile", shared.AuthMiddleware, session, h.handleUpdateProfile)
+	account.Delete("/", shared.AuthMiddleware, session, h.handleDeleteAccount)
+	account.Post("/restore", h.handleRestoreAccount)
+	account.Get("/export", shared.AuthMiddleware, session, h.handleExportAccount)
 
 	book := router.Group("/book")
-	book.Use(shared.TokenMiddleware)
-	book.Post("/", h.handleAddBook)
+	book.Use(shared.AuthMiddleware)
+	book.Post("/", write, h.handleAddBook)
 	book.Get("/", h.handleGetBook)
 	book.Get("/:id", h.handleGetBookById)
-	book.Delete("/:id", h.handleDeleteBookById)
+	book.Get("/:id/progresses", h.handleGetBookProgresses)
+	book.Patch("/:id", write, h.handleUpdateBookById)
+	book.Post("/:id/status", write, h.handleSetBookStatus)
+	book.Post("/:id/reread", write, h.handleStartReread)
+	book.Delete("/:id", write, h.handleDeleteBookById)
+	book.Put("/:id/tags", write, h.handleSetBookTags)
+	book.Put("/:id/cover", write, h.handleSetBookCover)
+	book.Delete("/:id/cover", write, h.handleDeleteBookCover)
+	book.Put("/:id/file", write, h.handleSetBookFile)
+	book.Get("/:id/file", h.handleGetBookFile)
+	book.Delete("/:id/file", write, h.handleDeleteBookFile)
+
+	// Covers are public, the names can't be guessed and emails have to be able to show them
+	router.Get("/covers/:name", h.handleGetCover)
+
+	tag := router.Group("/tag")
+	tag.Use(shared.AuthMiddleware)
+	tag.Post("/", write, h.handleCreateTag)
+	tag.Get("/", h.handleGetTags)
+	tag.Patch("/:id", write, h.handleRenameTag)
+	tag.Delete("/:id", write, h.handleDeleteTag)
+
+	author := router.Group("/author")
+	author.Use(shared.AuthMiddleware)
+	author.Get("/", h.handleGetAuthors)
+	author.Get("/:id/books", h.handleGetAuthorBooks)
+	author.Patch("/:id", write, h.handleRenameAuthor)
+	author.Post("/:id/merge", write, h.handleMergeAuthor)
+
+	series := router.Group("/series")
+	series.Use(shared.AuthMiddleware)
+	series.Get("/", h.handleGetAllSeries)
+	series.Get("/:id/books", h.handleGetSeriesBooks)
+	series.Patch("/:id", write, h.handleRenameSeries)
+	series.Delete("/:id", write, h.handleDeleteSeries)
+
+	// An OPDS catalog for e-readers, which only speak HTTP basic, so the account password or an API key goes there
+	opds := router.Group("/opds")
+	opds.Use(shared.BasicAuthMiddleware)
+	opds.Get("/", h.handleOPDSRoot)
+	opds.Get("/shelves", h.handleOPDSShelves)
+	opds.Get("/books", h.handleOPDSBooks)
+	opds.Get("/books/:id/file", h.handleGetBookFile)
 
 	progress := router.Group("/progress")
-	progress.Use(shared.TokenMiddleware)
-	progress.Post("/:id", h.handleCreateProgress)
-	progress.Delete("/:id", h.handleCancelProgress)
+	progress.Use(shared.AuthMiddleware)
+	progress.Post("/:id", write, h.handleCreateProgress)
+	progress.Delete("/:id", write, h.handleCancelProgress)
+
+	router.Get("/search", shared.AuthMiddleware, h.handleSearch)
 
 	goals := router.Group("/goal")
-	goals.Use(shared.TokenMiddleware)
-	goals.Post("/", h.handleCreateGoal)
+	goals.Use(shared.AuthMiddleware)
+	goals.Post("/", write, h.handleCreateGoal)
 	goals.Get("/", h.handleGetAllGoal)
+
+	router.Get("/stats", shared.AuthMiddleware, h.handleGetStats)
+	router.Get("/export", shared.AuthMiddleware, h.handleExportLibrary)
+
+	imports := router.Group("/import")
+	imports.Use(shared.AuthMiddleware)
+	imports.Post("/", write, h.handleCreateImport)
+	imports.Get("/:id", h.handleGetImport)
+}
+
+func (h *ProducerHandler) handleRegister(c *fiber.Ctx) error {
+	// initializing
+	req := &RequestAuthUser{}
+	err := c.BodyParser(req)
+	if err != nil {
+		slog.Error("Error while parsing body", "err", err)
+		return err
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Calls the Producer Service
+	id, err := h.Service.CreateUser(*req)
+	if err != nil {
+		return err
+	}
+
+	// Sends the verification email, the account is already there so a failure here shouldn't fail the register.
+	// The user can always ask for another one.
+	if err := h.Service.SendVerificationEmail(id); err != nil {
+		slog.Error("Error while sending verification email", "err", err)
+	}
+
+	// Opens a session, which hands out the JWT and refresh token
+	tokens, err := h.Service.CreateSession(id, c.Get(fiber.HeaderUserAgent), c.IP())
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":       "Create user success, check your email to verify your account",
+		"token":         tokens.Token,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+	})
+}
+
+func (h *ProducerHandler) handleLogin(c *fiber.Ctx) error {
+	// initializing
+	req := &RequestAuthUser{}
+	err := c.BodyParser(req)
+	if err != nil {
+		slog.Error("Error while parsing body", "err", err)
+		return err
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Calls the Producer Service
+	userId, err := h.Service.UserLogin(*req, c.IP())
+	if err != nil {
+		return err
+	}
+
+	return h.startLogin(c, userId, "Login Success, here's your token")
+}
+
+// startLogin answers with the session tokens, or with a 2FA challenge for users who have it enabled
+func (h *ProducerHandler) startLogin(c *fiber.Ctx, userId int, message string) error {
+	// Users with 2FA get a challenge instead of a session
+	tokens, challenge, err := h.Service.StartLogin(userId, c.Get(fiber.HeaderUserAgent), c.IP())
+	if err != nil {
+		return err
+	}
+
+	if challenge != "" {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"message":             "Two-factor code required, send it with the challenge token to /api/auth/2fa/login",
+			"two_factor_required": true,
+			"challenge_token":     challenge,
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":       message,
+		"token":         tokens.Token,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+	})
+}
+
+func (h *ProducerHandler) handleRefresh(c *fiber.Ctx) error {
+	// initializing
+	req := &RequestRefreshToken{}
+	err := c.BodyParser(req)
+	if err != nil {
+		slog.Error("Error while parsing body", "err", err)
+		return err
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Calls the Producer Service, the old refresh token is burned here
+	tokens, err := h.Service.RefreshSession(req.RefreshToken, c.Get(fiber.HeaderUserAgent), c.IP())
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":       "Refresh success, here's your new token",
+		"token":         tokens.Token,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+	})
+}
+
+func (h *ProducerHandler) handleLogout(c *fiber.Ctx) error {
+	// initializing
+	req := &RequestRefreshToken{}
+	err := c.BodyParser(req)
+	if err != nil {
+		slog.Error("Error while parsing body", "err", err)
+		return err
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Calls the Producer Service
+	err = h.Service.Logout(req.RefreshToken)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Logout success",
+	})
+}
+
+func (h *ProducerHandler) handleVerifyEmail(c *fiber.Ctx) error {
+	// initializing, the token comes from the link query when clicked from the email, or from the body
+	req := &RequestVerifyEmail{}
+	err := c.QueryParser(req)
+	if err != nil {
+		slog.Error("Error while parsing query", "err", err)
+		return err
+	}
+	if req.Token == "" && c.Method() == fiber.MethodPost {
+		err = c.BodyParser(req)
+		if err != nil {
+			slog.Error("Error while parsing body", "err", err)
+			return err
+		}
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Calls the Producer Service
+	err = h.Service.VerifyEmail(req.Token)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Email verified",
+	})
+}
+
+func (h *ProducerHandler) handleResendVerification(c *fiber.Ctx) error {
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// Calls the Producer Service
+	err = h.Service.SendVerificationEmail(userId)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Verification email sent",
+	})
+}
+
+func (h *ProducerHandler) handleForgotPassword(c *fiber.Ctx) error {
+	// initializing
+	req := &RequestForgotPassword{}
+	err := c.BodyParser(req)
+	if err != nil {
+		slog.Error("Error while parsing body", "err", err)
+		return err
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Calls the Producer Service
+	err = h.Service.RequestPasswordReset(req.Email)
+	if err != nil {
+		return err
+	}
+
+	// Same answer whether the account exists or not
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "If an account with that email exists, a reset email is on its way",
+	})
+}
+
+func (h *ProducerHandler) handleResetPassword(c *fiber.Ctx) error {
+	// initializing
+	req := &RequestResetPassword{}
+	err := c.BodyParser(req)
+	if err != nil {
+		slog.Error("Error while parsing body", "err", err)
+		return err
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Calls the Producer Service
+	err = h.Service.ResetPassword(*req)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Password reset success, please login again",
+	})
+}
+
+func (h *ProducerHandler) handleChangePassword(c *fiber.Ctx) error {
+	// initializing
+	req := &RequestChangePassword{}
+	err := c.BodyParser(req)
+	if err != nil {
+		slog.Error("Error while parsing body", "err", err)
+		return err
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// Calls the Producer Service, every other session gets logged out
+	tokens, err := h.Service.ChangePassword(userId, *req, c.Get(fiber.HeaderUserAgent), c.IP())
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":       "Password changed, other sessions are logged out",
+		"token":         tokens.Token,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+	})
+}
+
+func (h *ProducerHandler) handleTwoFactorLogin(c *fiber.Ctx) error {
+	// initializing
+	req := &RequestTwoFactorLogin{}
+	err := c.BodyParser(req)
+	if err != nil {
+		slog.Error("Error while parsing body", "err", err)
+		return err
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Calls the Producer Service
+	tokens, err := h.Service.CompleteTwoFactorLogin(*req, c.Get(fiber.HeaderUserAgent), c.IP())
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":       "Login Success, here's your token",
+		"token":         tokens.Token,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+	})
 }
 
-func (h *ProducerHandler) handleRegister(c *fiber.Ctx) error {
-	// initializing
-	req := &RequestAuthUser{}
-	err := c.BodyParser(req)
+func (h *ProducerHandler) handleTwoFactorEnroll(c *fiber.Ctx) error {
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// Calls the Producer Service
+	enrollment, err := h.Service.EnrollTwoFactor(userId)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":     "Scan the otpauth uri with your authenticator app, then confirm with a code",
+		"secret":      enrollment.Secret,
+		"otpauth_uri": enrollment.OtpauthURI,
+	})
+}
+
+func (h *ProducerHandler) handleTwoFactorConfirm(c *fiber.Ctx) error {
+	// initializing
+	req := &RequestTwoFactorCode{}
+	err := c.BodyParser(req)
+	if err != nil {
+		slog.Error("Error while parsing body", "err", err)
+		return err
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// Calls the Producer Service
+	codes, err := h.Service.ConfirmTwoFactor(userId, req.Code)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":        "Two-factor authentication enabled, keep these recovery codes somewhere safe",
+		"recovery_codes": codes,
+	})
+}
+
+func (h *ProducerHandler) handleTwoFactorDisable(c *fiber.Ctx) error {
+	// initializing
+	req := &RequestDisableTwoFactor{}
+	err := c.BodyParser(req)
+	if err != nil {
+		slog.Error("Error while parsing body", "err", err)
+		return err
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// Calls the Producer Service
+	err = h.Service.DisableTwoFactor(userId, *req)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Two-factor authentication disabled",
+	})
+}
+
+func (h *ProducerHandler) handleRegenerateRecoveryCodes(c *fiber.Ctx) error {
+	// initializing
+	req := &RequestTwoFactorCode{}
+	err := c.BodyParser(req)
+	if err != nil {
+		slog.Error("Error while parsing body", "err", err)
+		return err
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// Calls the Producer Service
+	codes, err := h.Service.RotateRecoveryCodes(userId, req.Code)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":        "Recovery codes regenerated, the old ones no longer work",
+		"recovery_codes": codes,
+	})
+}
+
+func (h *ProducerHandler) handleGetSessions(c *fiber.Ctx) error {
+	// Getting the principal, the session id tells which one is the current device
+	principal, err := shared.GetPrincipal(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// Calling the service
+	sessions, err := h.Service.GetActiveSessions(principal.UserId, principal.SessionId)
+	if err != nil {
+		slog.Error("Error while calling service", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":  "Query Sessions Success",
+		"sessions": sessions,
+	})
+}
+
+func (h *ProducerHandler) handleDeleteSession(c *fiber.Ctx) error {
+	// Taking id from params
+	sessionId, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// calls service
+	err = h.Service.RevokeUserSession(sessionId, userId)
+	if err != nil {
+		slog.Error("Error while executing service", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Session revoked",
+	})
+}
+
+func (h *ProducerHandler) handleCreateAPIKey(c *fiber.Ctx) error {
+	// initializing
+	req := &RequestCreateAPIKey{}
+	err := c.BodyParser(req)
+	if err != nil {
+		slog.Error("Error while parsing body", "err", err)
+		return err
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// Calls the Producer Service
+	key, err := h.Service.CreateAPIKey(userId, *req)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "API key created, copy it now, it won't be shown again",
+		"key":     key,
+	})
+}
+
+func (h *ProducerHandler) handleGetAPIKeys(c *fiber.Ctx) error {
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// Calling the service
+	keys, err := h.Service.GetAPIKeys(userId)
+	if err != nil {
+		slog.Error("Error while calling service", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Query API Keys Success",
+		"keys":    keys,
+	})
+}
+
+func (h *ProducerHandler) handleRevokeAPIKey(c *fiber.Ctx) error {
+	// Taking id from params
+	keyId, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// calls service
+	err = h.Service.RevokeAPIKey(keyId, userId)
+	if err != nil {
+		slog.Error("Error while executing service", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "API key revoked",
+	})
+}
+
+// requireAdmin guards the admin group, it runs after the auth middleware so the principal is there already
+func (h *ProducerHandler) requireAdmin(c *fiber.Ctx) error {
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	isAdmin, err := h.Service.IsAdmin(userId)
+	if err != nil {
+		return err
+	}
+	if !isAdmin {
+		return fiber.NewError(fiber.StatusForbidden, "Admin only")
+	}
+
+	return c.Next()
+}
+
+func (h *ProducerHandler) handleAdminGetUsers(c *fiber.Ctx) error {
+	// initializing
+	req := &RequestAdminGetUsers{}
+	err := c.QueryParser(req)
+	if err != nil {
+		slog.Error("Error while parsing query", "err", err)
+		return err
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Calling the service
+	users, err := h.Service.AdminGetUsers(*req)
+	if err != nil {
+		slog.Error("Error while calling service", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Query Users Success",
+		"users":   users,
+	})
+}
+
+func (h *ProducerHandler) handleAdminGetUser(c *fiber.Ctx) error {
+	// Taking id from params
+	userId, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	// Calling the service
+	user, err := h.Service.AdminGetUser(userId)
+	if err != nil {
+		slog.Error("Error while calling service", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Query User Success",
+		"user":    user,
+	})
+}
+
+func (h *ProducerHandler) handleAdminSetRole(c *fiber.Ctx) error {
+	// Taking id from params
+	userId, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	// initializing
+	req := &RequestAdminSetRole{}
+	err = c.BodyParser(req)
+	if err != nil {
+		slog.Error("Error while parsing body", "err", err)
+		return err
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Getting the admin's own id, so they can't lock themselves out
+	adminId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// calls service
+	err = h.Service.SetUserRole(userId, adminId, req.Role)
+	if err != nil {
+		slog.Error("Error while executing service", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Role updated",
+	})
+}
+
+func (h *ProducerHandler) handleAdminDisableUser(c *fiber.Ctx) error {
+	return h.setUserDisabled(c, true, "User disabled")
+}
+
+func (h *ProducerHandler) handleAdminEnableUser(c *fiber.Ctx) error {
+	return h.setUserDisabled(c, false, "User enabled")
+}
+
+func (h *ProducerHandler) setUserDisabled(c *fiber.Ctx, disabled bool, message string) error {
+	// Taking id from params
+	userId, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	// Getting the admin's own id, so they can't lock themselves out
+	adminId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// calls service
+	err = h.Service.SetUserDisabled(userId, adminId, disabled)
+	if err != nil {
+		slog.Error("Error while executing service", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": message,
+	})
+}
+
+func (h *ProducerHandler) handleAdminGetUserBooks(c *fiber.Ctx) error {
+	// Taking id from params
+	userId, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	// initializing
+	req := &RequestListBooks{}
+	err = c.QueryParser(req)
+	if err != nil {
+		slog.Error("Error while parsing query", "err", err)
+		return err
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Calling the same service the user's own listing uses
+	books, next, err := h.Service.GetAllBooksByUserId(userId, *req)
+	if err != nil {
+		slog.Error("Error while calling service", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":     "Query Books Success",
+		"books":       books,
+		"next_cursor": next,
+	})
+}
+
+func (h *ProducerHandler) handleAdminGetUserBook(c *fiber.Ctx) error {
+	// Taking ids from params
+	userId, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return err
+	}
+	bookId, err := strconv.Atoi(c.Params("bookId"))
+	if err != nil {
+		return err
+	}
+
+	// Calling the service
+	book, err := h.Service.GetBookById(bookId, userId)
+	if err != nil {
+		slog.Error("Error while calling service", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Query Book Success",
+		"book":    book,
+	})
+}
+
+func (h *ProducerHandler) handleAdminGetUserGoals(c *fiber.Ctx) error {
+	// Taking id from params
+	userId, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	// initializing
+	req := &RequestListGoals{}
+	err = c.QueryParser(req)
+	if err != nil {
+		slog.Error("Error while parsing query", "err", err)
+		return err
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Calling the service
+	goals, next, err := h.Service.GetAllGoals(userId, *req)
+	if err != nil {
+		slog.Error("Error while calling service", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":     "Query Goals Success",
+		"goals":       goals,
+		"next_cursor": next,
+	})
+}
+
+func (h *ProducerHandler) handleAdminExpireGoal(c *fiber.Ctx) error {
+	// Taking id from params
+	goalId, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	// calls service
+	err = h.Service.ExpireGoal(goalId)
+	if err != nil {
+		slog.Error("Error while executing service", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Goal is being expired, the owner will be notified",
+	})
+}
+
+func (h *ProducerHandler) handleAdminNotifyGoal(c *fiber.Ctx) error {
+	// Taking id from params
+	goalId, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	// calls service
+	err = h.Service.RetriggerGoalNotification(goalId)
+	if err != nil {
+		slog.Error("Error while executing service", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Goal notification re-triggered",
+	})
+}
+
+func (h *ProducerHandler) handleGetProfile(c *fiber.Ctx) error {
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// Calling the service
+	profile, err := h.Service.GetProfile(userId)
+	if err != nil {
+		slog.Error("Error while calling service", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Query Profile Success",
+		"profile": profile,
+	})
+}
+
+func (h *ProducerHandler) handleUpdateProfile(c *fiber.Ctx) error {
+	// initializing
+	req := &RequestUpdateProfile{}
+	err := c.BodyParser(req)
+	if err != nil {
+		slog.Error("Error while parsing body", "err", err)
+		return err
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// calls service
+	profile, err := h.Service.UpdateProfile(userId, *req)
+	if err != nil {
+		slog.Error("Error while executing service", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Profile updated",
+		"profile": profile,
+	})
+}
+
+func (h *ProducerHandler) handleDeleteAccount(c *fiber.Ctx) error {
+	// initializing
+	req := &RequestDeleteAccount{}
+	err := c.BodyParser(req)
+	if err != nil {
+		slog.Error("Error while parsing body", "err", err)
+		return err
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// calls service
+	deleteAt, err := h.Service.ScheduleAccountDeletion(userId, *req)
+	if err != nil {
+		slog.Error("Error while executing service", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":               "Account scheduled for deletion, log in through /api/account/restore before then to keep it",
+		"deletion_scheduled_at": deleteAt,
+	})
+}
+
+func (h *ProducerHandler) handleRestoreAccount(c *fiber.Ctx) error {
+	// initializing
+	req := &RequestAuthUser{}
+	err := c.BodyParser(req)
+	if err != nil {
+		slog.Error("Error while parsing body", "err", err)
+		return err
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Calls the Producer Service
+	userId, err := h.Service.RestoreAccount(*req, c.IP())
+	if err != nil {
+		return err
+	}
+
+	return h.startLogin(c, userId, "Account restored, here's your token")
+}
+
+func (h *ProducerHandler) handleExportAccount(c *fiber.Ctx) error {
+	// initializing
+	req := &RequestExportAccount{}
+	err := c.QueryParser(req)
+	if err != nil {
+		slog.Error("Error while parsing query", "err", err)
+		return err
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	format := req.Format
+	if format == "" {
+		format = "zip"
+	}
+
+	filename := fmt.Sprintf("hon-export-%d-%s.%s", userId, time.Now().Format("20060102"), format)
+	// Sets the content type from the extension too
+	c.Attachment(filename)
+
+	// Streamed straight to the client, the status is already sent by then so failures can only be logged
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		var archive accountArchive = newJSONArchive(w)
+		if format == "zip" {
+			archive = newZipArchive(w)
+		}
+
+		if err := h.Service.ExportAccount(userId, archive); err != nil {
+			slog.Error("Error while exporting account", "user_id", userId, "err", err)
+		}
+		if err := archive.Close(); err != nil {
+			slog.Error("Error while closing export archive", "err", err)
+		}
+		if err := w.Flush(); err != nil {
+			slog.Error("Error while flushing export", "err", err)
+		}
+	})
+
+	return nil
+}
+
+func (h *ProducerHandler) handleAddBook(c *fiber.Ctx) error {
+	// initializing
+	req := &RequestCreateBook{}
+
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	id, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// parse the body
+	err = c.BodyParser(req)
+	if err != nil {
+		slog.Error("Error while parsing body", "err", err)
+		return err
+	}
+
+	// validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// calling the service, look inside service for more detailed code
+	bookId, err := h.Service.CreateBook(id, *req)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Create book success",
+		"id":      bookId,
+	})
+}
+
+func (h *ProducerHandler) handleGetBook(c *fiber.Ctx) error {
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	id, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// initializing
+	req := &RequestListBooks{}
+	err = c.QueryParser(req)
+	if err != nil {
+		slog.Error("Error while parsing query", "err", err)
+		return err
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Calling the service
+	books, next, err := h.Service.GetAllBooksByUserId(id, *req)
+	if err != nil {
+		slog.Error("Error while calling service", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":     "Query Books Success",
+		"books":       books,
+		"next_cursor": next,
+	})
+}
+
+func (h *ProducerHandler) handleGetBookById(c *fiber.Ctx) error {
+	// Taking id from params
+	bookId, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// calls service
+	book, err := h.Service.GetBookById(bookId, userId)
+	if err != nil {
+		slog.Error("Error while executing service: GetBook", "err", err)
+		return err
+	}
+
+	// calls service for progresses, only the first page, the rest is under /api/book/:id/progresses
+	progresses, next, err := h.Service.GetAllProgressByBookId(bookId, userId, RequestListProgresses{})
+	if err != nil {
+		slog.Error("Error while executing service: GetProgress", "err", err)
+		return err
+	}
+
+	// calls service for the tags
+	err = h.Service.FillBookTags(book)
+	if err != nil {
+		slog.Error("Error while executing service: GetBookTags", "err", err)
+		return err
+	}
+
+	// calls service for the history of reads
+	runs, err := h.Service.GetReadingRuns(bookId, userId)
+	if err != nil {
+		slog.Error("Error while executing service: GetReadingRuns", "err", err)
+		return err
+	}
+
+	book.Runs = runs
+	book.Progresses = progresses
+	book.ProgressesNextCursor = next
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Query Book Success",
+		"books":   book,
+	})
+}
+
+func (h *ProducerHandler) handleGetBookProgresses(c *fiber.Ctx) error {
+	// Taking id from params
+	bookId, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	// initializing
+	req := &RequestListProgresses{}
+	err = c.QueryParser(req)
+	if err != nil {
+		slog.Error("Error while parsing query", "err", err)
+		return err
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// Checks if the user hold the book, an empty list would otherwise hide a wrong id
+	_, err = h.Service.GetBookById(bookId, userId)
+	if err != nil {
+		slog.Error("Error while executing service: GetBook", "err", err)
+		return err
+	}
+
+	// calls service
+	progresses, next, err := h.Service.GetAllProgressByBookId(bookId, userId, *req)
+	if err != nil {
+		slog.Error("Error while executing service: GetProgress", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":     "Query Progresses Success",
+		"progresses":  progresses,
+		"next_cursor": next,
+	})
+}
+
+func (h *ProducerHandler) handleUpdateBookById(c *fiber.Ctx) error {
+	// Taking id from params
+	bookId, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	// initializing
+	req := &RequestUpdateBook{}
+	err = c.BodyParser(req)
+	if err != nil {
+		slog.Error("Error while parsing body", "err", err)
+		return err
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// calls service
+	book, err := h.Service.UpdateBook(bookId, userId, *req)
+	if err != nil {
+		slog.Error("Error while executing service", "err", err)
+		return err
+	}
+
+	// calls service for the tags
+	err = h.Service.FillBookTags(book)
+	if err != nil {
+		slog.Error("Error while executing service: GetBookTags", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Book updated",
+		"book":    book,
+	})
+}
+
+func (h *ProducerHandler) handleSetBookStatus(c *fiber.Ctx) error {
+	// Taking id from params
+	bookId, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	// initializing
+	req := &RequestSetBookStatus{}
+	err = c.BodyParser(req)
+	if err != nil {
+		slog.Error("Error while parsing body", "err", err)
+		return err
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// calls service
+	book, err := h.Service.TransitionBook(bookId, userId, *req)
+	if err != nil {
+		slog.Error("Error while executing service", "err", err)
+		return err
+	}
+
+	// calls service for the tags
+	err = h.Service.FillBookTags(book)
+	if err != nil {
+		slog.Error("Error while executing service: GetBookTags", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Book status updated",
+		"book":    book,
+	})
+}
+
+func (h *ProducerHandler) handleStartReread(c *fiber.Ctx) error {
+	// Taking id from params
+	bookId, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// calls service
+	book, err := h.Service.StartReread(bookId, userId)
+	if err != nil {
+		slog.Error("Error while executing service", "err", err)
+		return err
+	}
+
+	// calls service for the tags
+	err = h.Service.FillBookTags(book)
+	if err != nil {
+		slog.Error("Error while executing service: GetBookTags", "err", err)
+		return err
+	}
+
+	// calls service for the history of reads
+	book.Runs, err = h.Service.GetReadingRuns(bookId, userId)
+	if err != nil {
+		slog.Error("Error while executing service: GetReadingRuns", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Re-read started",
+		"book":    book,
+	})
+}
+
+func (h *ProducerHandler) handleDeleteBookById(c *fiber.Ctx) error {
+	// Taking id from params
+	bookId, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// calls service
+	err = h.Service.DeleteBookById(bookId, userId)
+	if err != nil {
+		slog.Error("Error while executing service", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Book deleted",
+	})
+}
+
+func (h *ProducerHandler) handleSetBookCover(c *fiber.Ctx) error {
+	// Taking id from params
+	bookId, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	// The image comes as a multipart upload in the cover field, or as the raw body
+	maxBytes := getCoverMaxBytes()
+	tooBig := fiber.NewError(fiber.StatusRequestEntityTooLarge, fmt.Sprintf("The cover can't be bigger than %d bytes", maxBytes))
+
+	var data []byte
+	if file, err := c.FormFile("cover"); err == nil {
+		if file.Size > maxBytes {
+			return tooBig
+		}
+
+		f, err := file.Open()
+		if err != nil {
+			slog.Error("Error while opening upload", "err", err)
+			return err
+		}
+		defer f.Close()
+
+		data, err = io.ReadAll(f)
+		if err != nil {
+			slog.Error("Error while reading upload", "err", err)
+			return err
+		}
+	} else {
+		data = c.Body()
+		if int64(len(data)) > maxBytes {
+			return tooBig
+		}
+	}
+	if len(data) == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "Upload the cover image as the cover field or as the body")
+	}
+
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// calls service
+	book, err := h.Service.SetBookCover(bookId, userId, data)
+	if err != nil {
+		slog.Error("Error while executing service", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Cover updated",
+		"book":    book,
+	})
+}
+
+func (h *ProducerHandler) handleDeleteBookCover(c *fiber.Ctx) error {
+	// Taking id from params
+	bookId, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// calls service
+	err = h.Service.DeleteBookCover(bookId, userId)
+	if err != nil {
+		slog.Error("Error while executing service", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Cover deleted",
+	})
+}
+
+func (h *ProducerHandler) handleSetBookFile(c *fiber.Ctx) error {
+	// Taking id from params
+	bookId, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	// The ebook comes as a multipart upload in the file field, or as the raw body
+	maxBytes := getBookFileMaxBytes()
+	tooBig := fiber.NewError(fiber.StatusRequestEntityTooLarge, fmt.Sprintf("The book file can't be bigger than %d bytes", maxBytes))
+
+	var data []byte
+	if file, err := c.FormFile("file"); err == nil {
+		if file.Size > maxBytes {
+			return tooBig
+		}
+
+		f, err := file.Open()
+		if err != nil {
+			slog.Error("Error while opening upload", "err", err)
+			return err
+		}
+		defer f.Close()
+
+		data, err = io.ReadAll(f)
+		if err != nil {
+			slog.Error("Error while reading upload", "err", err)
+			return err
+		}
+	} else {
+		data = c.Body()
+		if int64(len(data)) > maxBytes {
+			return tooBig
+		}
+	}
+	if len(data) == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "Upload the book file as the file field or as the body")
+	}
+
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// calls service
+	book, err := h.Service.SetBookFile(bookId, userId, data)
+	if err != nil {
+		slog.Error("Error while executing service", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Book file updated",
+		"book":    book,
+	})
+}
+
+func (h *ProducerHandler) handleGetBookFile(c *fiber.Ctx) error {
+	// Taking id from params
+	bookId, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// calls service
+	blob, contentType, name, err := h.Service.GetBookFile(bookId, userId)
+	if err != nil {
+		return err
+	}
+
+	c.Attachment(name)
+	c.Set(fiber.HeaderContentType, contentType)
+
+	return c.SendStream(blob)
+}
+
+func (h *ProducerHandler) handleDeleteBookFile(c *fiber.Ctx) error {
+	// Taking id from params
+	bookId, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// calls service
+	err = h.Service.DeleteBookFile(bookId, userId)
+	if err != nil {
+		slog.Error("Error while executing service", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Book file deleted",
+	})
+}
+
+// sendOPDS answers with the feed, kind is its OPDS feed type
+func sendOPDS(c *fiber.Ctx, feed *opdsFeed, kind string) error {
+	c.Set(fiber.HeaderContentType, kind+";charset=utf-8")
+
+	return writeOPDS(c.Response().BodyWriter(), feed)
+}
+
+func (h *ProducerHandler) handleOPDSRoot(c *fiber.Ctx) error {
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// The root only leads to the other feeds
+	feed := newOPDSFeed(userId, "", "Hon", opdsURL("", nil), opdsNavigationType)
+	feed.addNavigation("all", "All books", "Every book in your library", opdsURL("/books", nil), opdsAcquisitionType)
+	for _, status := range opdsStatuses {
+		feed.addNavigation(status.status, status.title, "Books you marked "+status.title,
+			opdsURL("/books", url.Values{"status": {status.status}}), opdsAcquisitionType)
+	}
+	feed.addNavigation("shelves", "Shelves", "Your books by tag", opdsURL("/shelves", nil), opdsNavigationType)
+
+	return sendOPDS(c, feed, opdsNavigationType)
+}
+
+func (h *ProducerHandler) handleOPDSShelves(c *fiber.Ctx) error {
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// calls service
+	tags, err := h.Service.GetTags(userId)
+	if err != nil {
+		slog.Error("Error while executing service", "err", err)
+		return err
+	}
+
+	feed := newOPDSFeed(userId, "/shelves", "Shelves", opdsURL("/shelves", nil), opdsNavigationType)
+	feed.Links = append(feed.Links, opdsLink{Rel: "up", Href: opdsURL("", nil), Type: opdsNavigationType})
+	for _, tag := range tags {
+		feed.addNavigation("tag:"+strconv.Itoa(tag.Id), tag.Name, fmt.Sprintf("%d books", tag.Books),
+			opdsURL("/books", url.Values{"tags": {tag.Name}}), opdsAcquisitionType)
+	}
+
+	return sendOPDS(c, feed, opdsNavigationType)
+}
+
+func (h *ProducerHandler) handleOPDSBooks(c *fiber.Ctx) error {
+	// initializing, it's the book list with its filters and paging
+	req := &RequestListBooks{}
+	err := c.QueryParser(req)
+	if err != nil {
+		slog.Error("Error while parsing query", "err", err)
+		return err
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// calls service
+	books, next, err := h.Service.GetAllBooksByUserId(userId, *req)
+	if err != nil {
+		slog.Error("Error while executing service", "err", err)
+		return err
+	}
+
+	// The feed is the same list whatever page of it is shown, the cursor only picks the page
+	query, err := url.ParseQuery(string(c.Request().URI().QueryString()))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid query string")
+	}
+	self := opdsURL("/books", query)
+	query.Del("cursor")
+
+	path := "/books"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	feed := newOPDSFeed(userId, path, opdsBooksTitle(*req), self, opdsAcquisitionType)
+	feed.Links = append(feed.Links, opdsLink{Rel: "up", Href: opdsURL("", nil), Type: opdsNavigationType})
+	if next != nil {
+		query.Set("cursor", *next)
+		feed.Links = append(feed.Links, opdsLink{Rel: "next", Href: opdsURL("/books", query), Type: opdsAcquisitionType})
+	}
+	for _, book := range books {
+		feed.addBook(book)
+	}
+
+	return sendOPDS(c, feed, opdsAcquisitionType)
+}
+
+func (h *ProducerHandler) handleGetCover(c *fiber.Ctx) error {
+	// calls service
+	blob, contentType, err := h.Service.GetCover(c.Params("name"))
+	if err != nil {
+		return err
+	}
+
+	// A new upload gets a new name, so a cover never changes under its url
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderCacheControl, "public, max-age=31536000, immutable")
+
+	return c.SendStream(blob)
+}
+
+func (h *ProducerHandler) handleSetBookTags(c *fiber.Ctx) error {
+	// Taking id from params
+	bookId, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	// initializing
+	req := &RequestSetBookTags{}
+	err = c.BodyParser(req)
+	if err != nil {
+		slog.Error("Error while parsing body", "err", err)
+		return err
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// calls service
+	tags, err := h.Service.SetBookTags(bookId, userId, req.Tags)
+	if err != nil {
+		slog.Error("Error while executing service", "err", err)
+		return err
+	}
+	if tags == nil {
+		tags = []string{}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Book tags updated",
+		"tags":    tags,
+	})
+}
+
+func (h *ProducerHandler) handleCreateTag(c *fiber.Ctx) error {
+	// initializing
+	req := &RequestTag{}
+	err := c.BodyParser(req)
+	if err != nil {
+		slog.Error("Error while parsing body", "err", err)
+		return err
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// calls service
+	tagId, err := h.Service.CreateTag(userId, *req)
+	if err != nil {
+		slog.Error("Error while executing service", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Create tag success",
+		"id":      tagId,
+	})
+}
+
+func (h *ProducerHandler) handleGetTags(c *fiber.Ctx) error {
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// calls service
+	tags, err := h.Service.GetTags(userId)
+	if err != nil {
+		slog.Error("Error while executing service", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Query Tags Success",
+		"tags":    tags,
+	})
+}
+
+func (h *ProducerHandler) handleRenameTag(c *fiber.Ctx) error {
+	// Taking id from params
+	tagId, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	// initializing
+	req := &RequestTag{}
+	err = c.BodyParser(req)
+	if err != nil {
+		slog.Error("Error while parsing body", "err", err)
+		return err
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// calls service
+	err = h.Service.RenameTag(tagId, userId, *req)
+	if err != nil {
+		slog.Error("Error while executing service", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Tag renamed",
+	})
+}
+
+func (h *ProducerHandler) handleDeleteTag(c *fiber.Ctx) error {
+	// Taking id from params
+	tagId, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// calls service
+	err = h.Service.DeleteTag(tagId, userId)
+	if err != nil {
+		slog.Error("Error while executing service", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Tag deleted",
+	})
+}
+
+func (h *ProducerHandler) handleGetAuthors(c *fiber.Ctx) error {
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// calls service
+	authors, err := h.Service.GetAuthors(userId)
+	if err != nil {
+		slog.Error("Error while executing service", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Query Authors Success",
+		"authors": authors,
+	})
+}
+
+func (h *ProducerHandler) handleGetAuthorBooks(c *fiber.Ctx) error {
+	// Taking id from params
+	authorId, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	// initializing, the book list filters and paging work here too
+	req := &RequestListBooks{}
+	err = c.QueryParser(req)
 	if err != nil {
-		slog.Error("Error while parsing body", "err", err)
+		slog.Error("Error while parsing query", "err", err)
 		return err
 	}
+	req.AuthorId = authorId
 
 	// Validate
 	err = h.Validator.Struct(req)
@@ -58,30 +2013,44 @@ func (h *ProducerHandler) handleRegister(c *fiber.Ctx) error {
 		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
 	}
 
-	// Calls the Producer Service
-	id, err := h.Service.CreateUser(*req)
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
 	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// calls service
+	author, err := h.Service.GetAuthor(authorId, userId)
+	if err != nil {
+		slog.Error("Error while executing service", "err", err)
 		return err
 	}
 
-	// Creates a JWT token
-	expiry := time.Now().Add(24 * time.Hour)
-	token, err := shared.GenerateToken(id, expiry)
+	books, next, err := h.Service.GetAllBooksByUserId(userId, *req)
 	if err != nil {
-		slog.Error("Error while generating token", "err", err)
+		slog.Error("Error while executing service", "err", err)
 		return err
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"message": "Create user success",
-		"token":   token,
+		"message":     "Query Author Books Success",
+		"author":      author,
+		"books":       books,
+		"next_cursor": next,
 	})
 }
 
-func (h *ProducerHandler) handleLogin(c *fiber.Ctx) error {
+func (h *ProducerHandler) handleRenameAuthor(c *fiber.Ctx) error {
+	// Taking id from params
+	authorId, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return err
+	}
+
 	// initializing
-	req := &RequestAuthUser{}
-	err := c.BodyParser(req)
+	req := &RequestAuthor{}
+	err = c.BodyParser(req)
 	if err != nil {
 		slog.Error("Error while parsing body", "err", err)
 		return err
@@ -93,140 +2062,202 @@ func (h *ProducerHandler) handleLogin(c *fiber.Ctx) error {
 		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
 	}
 
-	// Calls the Producer Service
-	userId, err := h.Service.UserLogin(*req)
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
 	if err != nil {
+		slog.Error("Error while getting token", "err", err)
 		return err
 	}
 
-	expiry := time.Now().Add(24 * time.Hour)
-	token, err := shared.GenerateToken(userId, expiry)
+	// calls service
+	err = h.Service.RenameAuthor(authorId, userId, *req)
 	if err != nil {
-		slog.Error("Error while generating token", "err", err)
+		slog.Error("Error while executing service", "err", err)
 		return err
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"message": "Login Success, here's your token",
-		"token":   token,
+		"message": "Author renamed",
 	})
 }
 
-func (h *ProducerHandler) handleAddBook(c *fiber.Ctx) error {
-	// initializing
-	req := &RequestCreateBook{}
-
-	// Getting subject (which is user_id) from token to inject it into service.
-	id, err := shared.GetSubjectFromToken(c)
+func (h *ProducerHandler) handleMergeAuthor(c *fiber.Ctx) error {
+	// Taking id from params
+	authorId, err := strconv.Atoi(c.Params("id"))
 	if err != nil {
-		slog.Error("Error while getting token", "err", err)
 		return err
 	}
 
-	// parse the body
+	// initializing
+	req := &RequestMergeAuthor{}
 	err = c.BodyParser(req)
 	if err != nil {
 		slog.Error("Error while parsing body", "err", err)
 		return err
 	}
 
-	// validate
+	// Validate
 	err = h.Validator.Struct(req)
 	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
 		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
 	}
 
-	// calling the service, look inside service for more detailed code
-	err = h.Service.CreateBook(id, *req)
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
 	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// calls service
+	err = h.Service.MergeAuthor(authorId, userId, *req)
+	if err != nil {
+		slog.Error("Error while executing service", "err", err)
 		return err
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"message": "Create book success",
+		"message": "Authors merged",
 	})
 }
 
-func (h *ProducerHandler) handleGetBook(c *fiber.Ctx) error {
-	// Getting subject (which is user_id) from token to inject it into service.
-	id, err := shared.GetSubjectFromToken(c)
+func (h *ProducerHandler) handleGetAllSeries(c *fiber.Ctx) error {
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
 	if err != nil {
 		slog.Error("Error while getting token", "err", err)
 		return err
 	}
 
-	// Calling the service
-	books, err := h.Service.GetAllBooksByUserId(id)
+	// calls service
+	series, err := h.Service.GetAllSeries(userId)
 	if err != nil {
-		slog.Error("Error while calling service", "err", err)
+		slog.Error("Error while executing service", "err", err)
 		return err
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"message": "Query Books Success",
-		"books":   books,
+		"message": "Query Series Success",
+		"series":  series,
 	})
 }
 
-func (h *ProducerHandler) handleGetBookById(c *fiber.Ctx) error {
+func (h *ProducerHandler) handleGetSeriesBooks(c *fiber.Ctx) error {
 	// Taking id from params
-	bookId, err := strconv.Atoi(c.Params("id"))
+	seriesId, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	// initializing, the book list filters and paging work here too, in reading order unless asked otherwise
+	req := &RequestListBooks{}
+	err = c.QueryParser(req)
 	if err != nil {
+		slog.Error("Error while parsing query", "err", err)
 		return err
 	}
+	req.SeriesId = seriesId
+	if req.Sort == "" {
+		req.Sort = "series"
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
 
-	// Getting subject (which is user_id) from token to inject it into service.
-	userId, err := shared.GetSubjectFromToken(c)
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
 	if err != nil {
 		slog.Error("Error while getting token", "err", err)
 		return err
 	}
 
 	// calls service
-	book, err := h.Service.GetBookById(bookId, userId)
+	series, err := h.Service.GetSeries(seriesId, userId)
 	if err != nil {
-		slog.Error("Error while executing service: GetBook", "err", err)
+		slog.Error("Error while executing service", "err", err)
 		return err
 	}
 
-	// calls service for progresses
-	progresses, err := h.Service.GetAllProgressByBookId(bookId)
+	books, next, err := h.Service.GetAllBooksByUserId(userId, *req)
 	if err != nil {
-		slog.Error("Error while executing service: GetProgress", "err", err)
+		slog.Error("Error while executing service", "err", err)
 		return err
 	}
 
-	book.Progresses = progresses
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":     "Query Series Books Success",
+		"series":      series,
+		"books":       books,
+		"next_cursor": next,
+	})
+}
+
+func (h *ProducerHandler) handleRenameSeries(c *fiber.Ctx) error {
+	// Taking id from params
+	seriesId, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	// initializing
+	req := &RequestSeries{}
+	err = c.BodyParser(req)
+	if err != nil {
+		slog.Error("Error while parsing body", "err", err)
+		return err
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// calls service
+	err = h.Service.RenameSeries(seriesId, userId, *req)
+	if err != nil {
+		slog.Error("Error while executing service", "err", err)
+		return err
+	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"message": "Query Book Success",
-		"books":   book,
+		"message": "Series renamed",
 	})
 }
 
-func (h *ProducerHandler) handleDeleteBookById(c *fiber.Ctx) error {
+func (h *ProducerHandler) handleDeleteSeries(c *fiber.Ctx) error {
 	// Taking id from params
-	bookId, err := strconv.Atoi(c.Params("id"))
+	seriesId, err := strconv.Atoi(c.Params("id"))
 	if err != nil {
 		return err
 	}
 
-	// Getting subject (which is user_id) from token to inject it into service.
-	userId, err := shared.GetSubjectFromToken(c)
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
 	if err != nil {
 		slog.Error("Error while getting token", "err", err)
 		return err
 	}
 
 	// calls service
-	err = h.Service.DeleteBookById(bookId, userId)
+	err = h.Service.DeleteSeries(seriesId, userId)
 	if err != nil {
 		slog.Error("Error while executing service", "err", err)
 		return err
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"message": "Book deleted",
+		"message": "Series deleted",
 	})
 }
 
@@ -241,8 +2272,8 @@ func (h *ProducerHandler) handleCreateProgress(c *fiber.Ctx) error {
 	}
 	req.BookId = bookId
 
-	// Getting subject (which is user_id) from token to inject it into service.
-	userId, err := shared.GetSubjectFromToken(c)
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
 	if err != nil {
 		slog.Error("Error while getting token", "err", err)
 		return err
@@ -281,8 +2312,8 @@ func (h *ProducerHandler) handleCancelProgress(c *fiber.Ctx) error {
 		return err
 	}
 
-	// Getting subject (which is user_id) from token to inject it into service.
-	userId, err := shared.GetSubjectFromToken(c)
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
 	if err != nil {
 		slog.Error("Error while getting token", "err", err)
 		return err
@@ -310,8 +2341,14 @@ func (h *ProducerHandler) handleCreateGoal(c *fiber.Ctx) error {
 		return err
 	}
 
-	// Getting subject (which is user_id) from token to inject it into service.
-	userId, err := shared.GetSubjectFromToken(c)
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
 	if err != nil {
 		slog.Error("Error while getting token", "err", err)
 		return err
@@ -332,22 +2369,227 @@ func (h *ProducerHandler) handleCreateGoal(c *fiber.Ctx) error {
 }
 
 func (h *ProducerHandler) handleGetAllGoal(c *fiber.Ctx) error {
-	// Getting subject (which is user_id) from token to inject it into service.
-	id, err := shared.GetSubjectFromToken(c)
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	id, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// initializing
+	req := &RequestListGoals{}
+	err = c.QueryParser(req)
+	if err != nil {
+		slog.Error("Error while parsing query", "err", err)
+		return err
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Calling the service
+	books, next, err := h.Service.GetAllGoals(id, *req)
+	if err != nil {
+		slog.Error("Error while calling service", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":     "Query Goals Success",
+		"books":       books,
+		"next_cursor": next,
+	})
+}
+
+func (h *ProducerHandler) handleSearch(c *fiber.Ctx) error {
+	// initializing
+	req := &RequestSearch{}
+	err := c.QueryParser(req)
+	if err != nil {
+		slog.Error("Error while parsing query", "err", err)
+		return err
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// Calling the service
+	hits, err := h.Service.Search(userId, *req)
+	if err != nil {
+		slog.Error("Error while calling service", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Search Success",
+		"hits":    hits,
+	})
+}
+
+func (h *ProducerHandler) handleGetStats(c *fiber.Ctx) error {
+	// initializing
+	req := &RequestStats{}
+	err := c.QueryParser(req)
+	if err != nil {
+		slog.Error("Error while parsing query", "err", err)
+		return err
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
 	if err != nil {
 		slog.Error("Error while getting token", "err", err)
 		return err
 	}
 
 	// Calling the service
-	books, err := h.Service.GetAllGoals(id)
+	stats, err := h.Service.GetStats(userId, *req)
 	if err != nil {
 		slog.Error("Error while calling service", "err", err)
 		return err
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"message": "Query Goals Success",
-		"books":   books,
+		"message": "Query Stats Success",
+		"stats":   stats,
+	})
+}
+
+func (h *ProducerHandler) handleCreateImport(c *fiber.Ctx) error {
+	// The export comes as a multipart upload in the file field
+	file, err := c.FormFile("file")
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Upload the Goodreads or StoryGraph export as the file field")
+	}
+
+	maxBytes := getImportMaxBytes()
+	if file.Size > maxBytes {
+		return fiber.NewError(fiber.StatusRequestEntityTooLarge, fmt.Sprintf("The export can't be bigger than %d bytes", maxBytes))
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		slog.Error("Error while opening upload", "err", err)
+		return err
+	}
+	defer f.Close()
+
+	payload, err := io.ReadAll(f)
+	if err != nil {
+		slog.Error("Error while reading upload", "err", err)
+		return err
+	}
+
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// calls service
+	job, err := h.Service.CreateImportJob(userId, payload)
+	if err != nil {
+		slog.Error("Error while executing service", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message": "Import queued, an email will be sent when it's done",
+		"import":  job,
+	})
+}
+
+func (h *ProducerHandler) handleGetImport(c *fiber.Ctx) error {
+	// Taking id from params
+	jobId, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// calls service
+	job, err := h.Service.GetImportJob(jobId, userId)
+	if err != nil {
+		slog.Error("Error while executing service", "err", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Query Import Success",
+		"import":  job,
 	})
 }
+
+func (h *ProducerHandler) handleExportLibrary(c *fiber.Ctx) error {
+	// initializing
+	req := &RequestExportLibrary{}
+	err := c.QueryParser(req)
+	if err != nil {
+		slog.Error("Error while parsing query", "err", err)
+		return err
+	}
+
+	// Validate
+	err = h.Validator.Struct(req)
+	if err != nil && errors.As(err, &validator.ValidationErrors{}) {
+		return shared.NewFailedValidationError(*req, err.(validator.ValidationErrors))
+	}
+
+	// Getting user_id from the principal the auth middleware resolved, to inject it into service.
+	userId, err := shared.GetUserId(c)
+	if err != nil {
+		slog.Error("Error while getting token", "err", err)
+		return err
+	}
+
+	// calls service
+	books, err := h.Service.GetLibrary(userId, *req)
+	if err != nil {
+		slog.Error("Error while executing service", "err", err)
+		return err
+	}
+
+	format := req.Format
+	if format == "" {
+		format = "json"
+	}
+
+	extension := format
+	if format == "markdown" {
+		extension = "md"
+	}
+
+	// Sets the content type from the extension too
+	c.Attachment(fmt.Sprintf("hon-library-%d-%s.%s", userId, time.Now().Format("20060102"), extension))
+	if format == "markdown" {
+		c.Set(fiber.HeaderContentType, "text/markdown; charset=utf-8")
+	}
+
+	return writeLibrary(c.Response().BodyWriter(), format, books, *req)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jirbthagoras/hon/shared"
+)
+
+// libraryCSVHeader are the Goodreads export columns, the file imports into Goodreads, StoryGraph or Hon again
+var libraryCSVHeader = []string{
+	"Book Id", "Title", "Author", "ISBN", "ISBN13", "My Rating", "Binding", "Number of Pages",
+	"Date Read", "Date Added", "Bookshelves", "Exclusive Shelf", "My Review", "Read Count",
+}
+
+// libraryBindings are the Goodreads bindings the formats are written as
+var libraryBindings = map[string]string{
+	"paper":     "Paperback",
+	"ebook":     "Kindle Edition",
+	"audiobook": "Audiobook",
+}
+
+// writeLibrary writes the export in one of the formats RequestExportLibrary takes
+func writeLibrary(w io.Writer, format string, books []*ExportLibraryBook, req RequestExportLibrary) error {
+	switch format {
+	case "csv":
+		return writeLibraryCSV(w, books)
+	case "markdown":
+		return writeLibraryMarkdown(w, books, req)
+	}
+
+	return json.NewEncoder(w).Encode(books)
+}
+
+func writeLibraryCSV(w io.Writer, books []*ExportLibraryBook) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(libraryCSVHeader); err != nil {
+		return err
+	}
+
+	for _, book := range books {
+		// Goodreads has no idea what a percent or a second of a book is
+		pages := ""
+		if book.Unit == "pages" && book.TotalPages > 0 {
+			pages = strconv.Itoa(book.TotalPages)
+		}
+
+		dateRead := ""
+		if book.Status == "completed" {
+			dateRead = libraryDate(book.FinishedAt, "2006/01/02")
+		}
+
+		isbn := ""
+		if book.Isbn != nil {
+			isbn = *book.Isbn
+		}
+
+		err := cw.Write([]string{
+			strconv.Itoa(book.Id),
+			book.Title,
+			book.Author,
+			"",
+			isbn,
+			"0",
+			libraryBindings[book.Format],
+			pages,
+			dateRead,
+			libraryDate(book.AddedAt, "2006/01/02"),
+			strings.Join(book.Tags, ", "),
+			shared.ExportShelf(book.Status),
+			"",
+			strconv.Itoa(book.ReadCount),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeLibraryMarkdown writes a reading journal, every book with its progresses in the order they were written
+func writeLibraryMarkdown(w io.Writer, books []*ExportLibraryBook, req RequestExportLibrary) error {
+	var b strings.Builder
+
+	b.WriteString("# Reading journal\n")
+	if req.From != "" || req.To != "" {
+		from, to := req.From, req.To
+		if from == "" {
+			from = "the beginning"
+		}
+		if to == "" {
+			to = "today"
+		}
+		fmt.Fprintf(&b, "\n_From %s to %s_\n", markdownEscape(from), markdownEscape(to))
+	}
+	if len(books) == 0 {
+		b.WriteString("\nNothing read yet.\n")
+	}
+
+	for _, book := range books {
+		fmt.Fprintf(&b, "\n## %s\n\n", markdownEscape(book.Title))
+		if book.ThumbnailUrl != nil {
+			fmt.Fprintf(&b, "![Cover of %s](%s)\n\n", markdownEscape(book.Title), *book.ThumbnailUrl)
+		}
+
+		details := []string{"by " + markdownEscape(book.Author), book.Format, book.Status}
+		if book.Unit != "percent" && book.TotalPages > 0 {
+			details = append(details, book.Length+" "+book.Unit)
+		}
+		if len(book.Tags) > 0 {
+			details = append(details, "tagged "+markdownEscape(strings.Join(book.Tags, ", ")))
+		}
+		b.WriteString(strings.Join(details, " · ") + "\n")
+
+		if book.Status == "completed" && book.FinishedAt != nil {
+			fmt.Fprintf(&b, "\nFinished on %s", libraryDate(book.FinishedAt, "2006-01-02"))
+			if book.ReadCount > 1 {
+				fmt.Fprintf(&b, ", read %d times", book.ReadCount)
+			}
+			b.WriteString(".\n")
+		}
+
+		if len(book.Progresses) > 0 {
+			b.WriteString("\n")
+		}
+		for _, progress := range book.Progresses {
+			fmt.Fprintf(&b, "- **%s** %s → %s (%s%%)", progress.CreatedAt.Format("2006-01-02"), progress.From, progress.Until,
+				strconv.FormatFloat(progress.Percent, 'f', -1, 64))
+			if description := strings.TrimSpace(progress.Description); description != "" {
+				// Continuation lines stay inside the list item
+				b.WriteString(": " + strings.ReplaceAll(markdownEscape(description), "\n", "\n  "))
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func libraryDate(t *time.Time, layout string) string {
+	if t == nil {
+		return ""
+	}
+
+	return t.Format(layout)
+}
+
+// markdownEscape keeps titles and notes from being read as markdown
+var markdownEscape = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`, "<", `\<`, ">", `\>`, "#", `\#`, "\r\n", "\n",
+).Replace
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"log/slog"
 	"os"
+	"strings"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
@@ -16,11 +18,45 @@ func main() {
 	amqp := shared.NewAMQPConnection()
 	producerService := NewProducerService(sql, amqp)
 
+	// Make sure no raw password stays in the database
+	if err := producerService.MigrateLegacyPasswords(); err != nil {
+		slog.Error("Failed to migrate legacy passwords", "err", err)
+		os.Exit(1)
+	}
+
+	// Books from before authors had their own table get credited to them
+	if err := producerService.MigrateAuthors(); err != nil {
+		slog.Error("Failed to migrate authors", "err", err)
+		os.Exit(1)
+	}
+
+	// Accounts listed in ADMIN_EMAILS get the admin role
+	if err := producerService.PromoteAdmins(strings.Split(shared.NewConfig().GetString("ADMIN_EMAILS"), ",")); err != nil {
+		slog.Error("Failed to promote admins", "err", err)
+		os.Exit(1)
+	}
+
 	// creates a server
 	server := fiber.New(fiber.Config{
 		ErrorHandler: shared.ErrorHandler,
+		// Book files, covers and exports can be configured past the default 4MB
+		BodyLimit: serverBodyLimit(),
 	})
 
+	// API keys live in our database, let the shared auth middleware look them up through the service
+	shared.RegisterAPIKeyResolver(producerService.ResolveAPIKey)
+	shared.RegisterPasswordResolver(producerService.ResolvePassword)
+	shared.RegisterSessionChecker(producerService.CheckSession)
+
+	// Keeps the JWT signing keys rotated, only does something when JWT_KEY_DIR is set
+	shared.StartKeyRotation(context.Background())
+
+	// Hard deletes the accounts whose deletion grace period is over
+	producerService.StartAccountPurge(context.Background())
+
+	// Public keys for anyone who wants to verify Hon tokens
+	server.Get("/.well-known/jwks.json", shared.JWKSHandler)
+
 	producerHandlers := NewProducerHandler(validate, producerService)
 	app := server.Group("/api")
 	producerHandlers.RegisterRoutes(app)
@@ -1,11 +1,42 @@
 package main
 
-import "time"
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jirbthagoras/hon/shared"
+)
 
 type User struct {
-	Id       int    `json:"id"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Id                  int            `json:"id"`
+	Email               string         `json:"email"`
+	Password            string         `json:"password"`
+	VerifiedAt          sql.NullTime   `json:"verified_at"`
+	TotpSecret          sql.NullString `json:"-"`
+	TotpEnabledAt       sql.NullTime   `json:"totp_enabled_at"`
+	TotpLastStep        sql.NullInt64  `json:"-"`
+	Role                string         `json:"role"`
+	DisabledAt          sql.NullTime   `json:"disabled_at"`
+	DeletionScheduledAt sql.NullTime   `json:"deletion_scheduled_at"`
+	DisplayName         sql.NullString `json:"display_name"`
+	Timezone            string         `json:"timezone"`
+	Locale              string         `json:"locale"`
+	DateFormat          string         `json:"date_format"`
+}
+
+// Recipient is what the consumer needs to address the user and show dates the way they like
+func (u *User) Recipient() shared.Recipient {
+	return shared.Recipient{
+		DisplayName: u.DisplayName.String,
+		Timezone:    u.Timezone,
+		Locale:      u.Locale,
+		DateFormat:  u.DateFormat,
+	}
+}
+
+// Location is the user's timezone
+func (u *User) Location() *time.Location {
+	return shared.LoadLocation(u.Timezone)
 }
 
 type Book struct {
@@ -20,8 +51,18 @@ type Book struct {
 type Progress struct {
 	Id          int       `json:"id"`
 	BookId      int       `json:"book_id"`
+	RunId       int       `json:"run_id"`
 	FromPage    int       `json:"from_page" validate:"required"`
 	UntilPage   int       `json:"until_page" validate:"required"`
 	Description string    `json:"description" validate:"required"`
 	CreatedAt   time.Time `json:"created_at"`
 }
+
+// ReadingRun is one read of a book from the first page to the last, re-reading a book starts a new one
+type ReadingRun struct {
+	Id         int          `json:"id"`
+	BookId     int          `json:"book_id"`
+	Number     int          `json:"number"`
+	StartedAt  time.Time    `json:"started_at"`
+	FinishedAt sql.NullTime `json:"finished_at"`
+}
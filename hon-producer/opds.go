@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The OPDS 1.2 catalog is Atom feeds: navigation feeds list other feeds, acquisition feeds list books
+const (
+	opdsNavigationType  = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	opdsAcquisitionType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+)
+
+// opdsStatuses are the statuses the catalog root links to, in the order readers care about them
+var opdsStatuses = []struct {
+	status string
+	title  string
+}{
+	{"reading", "Reading"},
+	{"want-to-read", "Want to read"},
+	{"paused", "Paused"},
+	{"completed", "Completed"},
+	{"abandoned", "Abandoned"},
+}
+
+type opdsFeed struct {
+	XMLName   xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	XmlnsDC   string      `xml:"xmlns:dc,attr"`
+	XmlnsOPDS string      `xml:"xmlns:opds,attr"`
+	Id        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Updated   string      `xml:"updated"`
+	Author    opdsAuthor  `xml:"author"`
+	Links     []opdsLink  `xml:"link"`
+	Entries   []opdsEntry `xml:"entry"`
+}
+
+type opdsEntry struct {
+	Title      string         `xml:"title"`
+	Id         string         `xml:"id"`
+	Updated    string         `xml:"updated"`
+	Authors    []opdsAuthor   `xml:"author"`
+	Identifier string         `xml:"dc:identifier,omitempty"`
+	Categories []opdsCategory `xml:"category"`
+	Content    *opdsContent   `xml:"content"`
+	Links      []opdsLink     `xml:"link"`
+}
+
+type opdsAuthor struct {
+	Name string `xml:"name"`
+}
+
+type opdsLink struct {
+	Rel   string `xml:"rel,attr,omitempty"`
+	Href  string `xml:"href,attr"`
+	Type  string `xml:"type,attr,omitempty"`
+	Title string `xml:"title,attr,omitempty"`
+}
+
+type opdsCategory struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr"`
+}
+
+type opdsContent struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+// opdsURL is the address of a catalog page
+func opdsURL(path string, query url.Values) string {
+	address := appURL("/api/opds" + path)
+	if len(query) > 0 {
+		address += "?" + query.Encode()
+	}
+
+	return address
+}
+
+// newOPDSFeed starts a feed of the user's catalog, self is its own address and kind its feed type
+func newOPDSFeed(userId int, path string, title string, self string, kind string) *opdsFeed {
+	return &opdsFeed{
+		XmlnsDC:   "http://purl.org/dc/terms/",
+		XmlnsOPDS: "http://opds-spec.org/2010/catalog",
+		Id:        fmt.Sprintf("urn:hon:user:%d:opds%s", userId, path),
+		Title:     title,
+		Updated:   time.Now().UTC().Format(time.RFC3339),
+		Author:    opdsAuthor{Name: "Hon"},
+		Links: []opdsLink{
+			{Rel: "self", Href: self, Type: kind},
+			{Rel: "start", Href: opdsURL("", nil), Type: opdsNavigationType, Title: "Hon"},
+		},
+	}
+}
+
+// opdsBooksTitle names a book feed after its filters
+func opdsBooksTitle(req RequestListBooks) string {
+	title := "All books"
+	for _, status := range opdsStatuses {
+		if status.status == req.Status {
+			title = status.title
+		}
+	}
+	if tags := parseTagList(req.Tags); len(tags) > 0 {
+		title += " on " + strings.Join(tags, ", ")
+	}
+
+	return title
+}
+
+// addNavigation adds an entry leading to another feed of the catalog
+func (f *opdsFeed) addNavigation(id string, title string, content string, href string, kind string) {
+	f.Entries = append(f.Entries, opdsEntry{
+		Title:   title,
+		Id:      f.Id + ":" + id,
+		Updated: f.Updated,
+		Content: &opdsContent{Type: "text", Text: content},
+		Links:   []opdsLink{{Rel: "subsection", Href: href, Type: kind}},
+	})
+}
+
+// addBook adds a book with its cover and, when a file is attached, the link to download it
+func (f *opdsFeed) addBook(book *ResponseGetBooks) {
+	entry := opdsEntry{
+		Title:   book.Title,
+		Id:      "urn:hon:book:" + strconv.Itoa(book.Id),
+		Updated: f.Updated,
+	}
+
+	for _, author := range book.Authors {
+		entry.Authors = append(entry.Authors, opdsAuthor{Name: author.Name})
+	}
+	if len(entry.Authors) == 0 && book.Author != "" {
+		entry.Authors = append(entry.Authors, opdsAuthor{Name: book.Author})
+	}
+
+	if book.Isbn != nil {
+		entry.Identifier = "urn:isbn:" + *book.Isbn
+	}
+
+	for _, tag := range book.Tags {
+		entry.Categories = append(entry.Categories, opdsCategory{Term: tag, Label: tag})
+	}
+
+	details := []string{book.Format, book.Status}
+	if book.Series != nil {
+		if book.Series.Position != nil {
+			details = append(details, fmt.Sprintf("#%s in %s", strconv.FormatFloat(*book.Series.Position, 'f', -1, 64), book.Series.Name))
+		} else {
+			details = append(details, "in "+book.Series.Name)
+		}
+	}
+	entry.Content = &opdsContent{Type: "text", Text: strings.Join(details, ", ")}
+
+	if book.CoverUrl != nil {
+		entry.Links = append(entry.Links, opdsLink{Rel: "http://opds-spec.org/image", Href: *book.CoverUrl, Type: coverContentType(*book.CoverUrl)})
+	}
+	if book.ThumbnailUrl != nil {
+		entry.Links = append(entry.Links, opdsLink{Rel: "http://opds-spec.org/image/thumbnail", Href: *book.ThumbnailUrl, Type: "image/jpeg"})
+	}
+	if book.FileUrl != nil && book.FileType != nil {
+		entry.Links = append(entry.Links, opdsLink{
+			Rel:  "http://opds-spec.org/acquisition",
+			Href: opdsURL(fmt.Sprintf("/books/%d/file", book.Id), nil),
+			Type: *book.FileType,
+		})
+	}
+
+	f.Entries = append(f.Entries, entry)
+}
+
+// coverContentType is the type of a cover from the extension in its url
+func coverContentType(address string) string {
+	for contentType, extension := range coverTypes {
+		if strings.HasSuffix(address, extension) {
+			return contentType
+		}
+	}
+
+	return ""
+}
+
+// writeOPDS writes the feed as an XML document
+func writeOPDS(w io.Writer, feed *opdsFeed) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(feed); err != nil {
+		return err
+	}
+
+	return encoder.Close()
+}
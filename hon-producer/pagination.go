@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+var errInvalidCursor = fiber.NewError(fiber.StatusBadRequest, "Invalid cursor, start again from the first page")
+
+// pageCursor points at the last row of a page: its sort value and its id, the id breaks ties.
+// It remembers the ordering it was made for, using it with another one would skip or repeat rows.
+type pageCursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d"`
+	Value string `json:"v"`
+	Id    int    `json:"i"`
+}
+
+func encodeCursor(cursor pageCursor) string {
+	raw, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(value string, sort string, desc bool) (*pageCursor, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	var cursor pageCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		return nil, errInvalidCursor
+	}
+	if cursor.Sort != sort || cursor.Desc != desc {
+		return nil, errInvalidCursor
+	}
+
+	return &cursor, nil
+}
+
+func pageSize(limit int) int {
+	if limit <= 0 {
+		return defaultPageSize
+	}
+	if limit > maxPageSize {
+		return maxPageSize
+	}
+	return limit
+}
+
+// keyset paginates a query ordered by column, then by idColumn for rows with the same value.
+// Unlike OFFSET it stays fast deep into a list, and rows added meanwhile don't shift the pages.
+type keyset struct {
+	column   string
+	idColumn string
+	desc     bool
+}
+
+// after is the condition that skips everything up to and including the cursor row
+func (k keyset) after(cursor *pageCursor) (string, []any) {
+	op := ">"
+	if k.desc {
+		op = "<"
+	}
+
+	condition := fmt.Sprintf("(%s %s ? OR (%s = ? AND %s %s ?))", k.column, op, k.column, k.idColumn, op)
+	return condition, []any{cursor.Value, cursor.Value, cursor.Id}
+}
+
+func (k keyset) orderBy() string {
+	dir := "ASC"
+	if k.desc {
+		dir = "DESC"
+	}
+
+	return fmt.Sprintf(" ORDER BY %s %s, %s %s", k.column, dir, k.idColumn, dir)
+}
+
+// listQuery collects the WHERE conditions of a list endpoint together with their arguments
+type listQuery struct {
+	conditions []string
+	args       []any
+}
+
+func (q *listQuery) where(condition string, args ...any) {
+	q.conditions = append(q.conditions, condition)
+	q.args = append(q.args, args...)
+}
+
+// build appends the conditions, the keyset condition and ordering, and the page limit to base.
+// One extra row is fetched, it only tells whether there is a next page.
+func (q *listQuery) build(base string, k keyset, cursor *pageCursor, limit int) (string, []any) {
+	if cursor != nil {
+		condition, args := k.after(cursor)
+		q.where(condition, args...)
+	}
+
+	query := base
+	if len(q.conditions) > 0 {
+		query += " WHERE " + strings.Join(q.conditions, " AND ")
+	}
+	query += k.orderBy() + " LIMIT ?"
+
+	return query, append(q.args, limit+1)
+}
+
+// nextCursor trims the extra row build asked for and returns the cursor of the page's last row, nil on the last page
+func nextCursor[T any](items []T, limit int, sort string, desc bool, position func(T) (string, int)) ([]T, *string) {
+	if len(items) <= limit {
+		return items, nil
+	}
+
+	items = items[:limit]
+	value, id := position(items[limit-1])
+	cursor := encodeCursor(pageCursor{Sort: sort, Desc: desc, Value: value, Id: id})
+
+	return items, &cursor
+}
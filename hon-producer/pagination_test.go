@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeCursor(t *testing.T) {
+	titleCursor := pageCursor{Sort: "title", Desc: false, Value: "Dune", Id: 42}
+
+	tests := []struct {
+		name    string
+		value   string
+		sort    string
+		desc    bool
+		want    *pageCursor
+		wantErr error
+	}{
+		{name: "first page", value: "", sort: "title", want: nil},
+		{name: "next page", value: encodeCursor(titleCursor), sort: "title", want: &titleCursor},
+		{
+			name:  "descending with an empty sort value",
+			value: encodeCursor(pageCursor{Sort: "created", Desc: true, Id: 7}),
+			sort:  "created", desc: true,
+			want: &pageCursor{Sort: "created", Desc: true, Id: 7},
+		},
+		{name: "made for another sort", value: encodeCursor(titleCursor), sort: "author", wantErr: errInvalidCursor},
+		{name: "made for the other direction", value: encodeCursor(titleCursor), sort: "title", desc: true, wantErr: errInvalidCursor},
+		{name: "not base64", value: "not a cursor!", sort: "title", wantErr: errInvalidCursor},
+		{name: "padded base64", value: base64.URLEncoding.EncodeToString([]byte(`{"s":"title"}`)), sort: "title", wantErr: errInvalidCursor},
+		{name: "base64 but not json", value: base64.RawURLEncoding.EncodeToString([]byte("title:42")), sort: "title", wantErr: errInvalidCursor},
+		{name: "json of the wrong shape", value: base64.RawURLEncoding.EncodeToString([]byte(`{"i":"42"}`)), sort: "title", wantErr: errInvalidCursor},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeCursor(tt.value, tt.sort, tt.desc)
+			if err != tt.wantErr {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("cursor = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// snippetRunes is roughly how much of a progress description a search hit shows
+const snippetRunes = 160
+
+// searchTerms splits the user's query into words, without anything MySQL's boolean mode would read as an operator
+func searchTerms(q string) []string {
+	words := strings.FieldsFunc(q, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	var terms []string
+	seen := map[string]bool{}
+	for _, word := range words {
+		word = strings.ToLower(word)
+		if seen[word] {
+			continue
+		}
+		seen[word] = true
+		terms = append(terms, word)
+	}
+
+	return terms
+}
+
+// booleanQuery turns the terms into an AGAINST ... IN BOOLEAN MODE query, every term also matches as a prefix
+func booleanQuery(terms []string) string {
+	parts := make([]string, len(terms))
+	for i, term := range terms {
+		parts[i] = term + "*"
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// highlight HTML-escapes text and wraps every word starting with one of the terms in <mark>.
+// With maxRunes above zero the text is cut down to a window around the first match.
+func highlight(text string, terms []string, maxRunes int) string {
+	runes := []rune(text)
+
+	// Find the words that match
+	type span struct{ start, end int }
+	var matches []span
+	for i := 0; i < len(runes); {
+		if !isWordRune(runes[i]) {
+			i++
+			continue
+		}
+
+		start := i
+		for i < len(runes) && isWordRune(runes[i]) {
+			i++
+		}
+
+		word := strings.ToLower(string(runes[start:i]))
+		for _, term := range terms {
+			if strings.HasPrefix(word, term) {
+				matches = append(matches, span{start, i})
+				break
+			}
+		}
+	}
+
+	// Cut a window around the first match, not in the middle of a word.
+	// Near the end the window slides back, so it still shows maxRunes of text.
+	from, to := 0, len(runes)
+	if maxRunes > 0 && len(runes) > maxRunes {
+		if len(matches) > 0 {
+			from = matches[0].start - maxRunes/4
+		}
+		from = max(0, min(from, len(runes)-maxRunes))
+		to = from + maxRunes
+
+		for from > 0 && isWordRune(runes[from-1]) {
+			from--
+		}
+		for to < len(runes) && isWordRune(runes[to]) {
+			to++
+		}
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+
+	pos := from
+	for _, m := range matches {
+		if m.end <= from || m.start >= to {
+			continue
+		}
+		b.WriteString(html.EscapeString(string(runes[pos:m.start])))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(string(runes[m.start:m.end])))
+		b.WriteString("</mark>")
+		pos = m.end
+	}
+	b.WriteString(html.EscapeString(string(runes[pos:to])))
+
+	if to < len(runes) {
+		b.WriteString("…")
+	}
+
+	return b.String()
+}
+
+func isWordRune(r rune) bool {
+	return r != utf8.RuneError && (unicode.IsLetter(r) || unicode.IsDigit(r))
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+func TestSearchTerms(t *testing.T) {
+	tests := []struct {
+		name string
+		q    string
+		want []string
+	}{
+		{name: "words", q: "Left Hand of Darkness", want: []string{"left", "hand", "of", "darkness"}},
+		{name: "repeated words once", q: "Dune dune DUNE messiah", want: []string{"dune", "messiah"}},
+		{name: "boolean operators dropped", q: `+dune -messiah "children" god* (emperor) ~arrakis <spice >worm @2`, want: []string{"dune", "messiah", "children", "god", "emperor", "arrakis", "spice", "worm", "2"}},
+		{name: "punctuation splits words", q: "Café-crème, brûlée", want: []string{"café", "crème", "brûlée"}},
+		{name: "text without word breaks", q: "今日は本を読んだ", want: []string{"今日は本を読んだ"}},
+		{name: "nothing searchable", q: ` "+-*~<>()@ `, want: nil},
+		{name: "empty", q: "", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := searchTerms(tt.q); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("searchTerms(%q) = %q, want %q", tt.q, got, tt.want)
+			}
+		})
+	}
+}
+
+// Whatever the user types, AGAINST only ever gets words each followed by the prefix *
+func TestBooleanQuery(t *testing.T) {
+	tests := []struct {
+		q    string
+		want string
+	}{
+		{q: "left hand", want: "left* hand*"},
+		{q: `+dune -messiah`, want: "dune* messiah*"},
+		{q: `"exact phrase"`, want: "exact* phrase*"},
+		{q: `a*b`, want: "a* b*"},
+		{q: `(x) <y> ~z @3`, want: "x* y* z* 3*"},
+		{q: `it's \ O'Brien`, want: "it* s* o* brien*"},
+		{q: `*`, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.q, func(t *testing.T) {
+			got := booleanQuery(searchTerms(tt.q))
+			if got != tt.want {
+				t.Errorf("booleanQuery of %q = %q, want %q", tt.q, got, tt.want)
+			}
+			for _, term := range strings.Fields(got) {
+				word := strings.TrimSuffix(term, "*")
+				if word == "" || strings.IndexFunc(word, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) >= 0 {
+					t.Errorf("booleanQuery of %q let %q through", tt.q, term)
+				}
+			}
+		})
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	// Four letter words five runes apart, target starts at rune 40
+	long := "aaaa bbbb cccc dddd eeee ffff gggg hhhh target iiii jjjj kkkk llll"
+
+	tests := []struct {
+		name     string
+		text     string
+		terms    []string
+		maxRunes int
+		want     string
+	}{
+		{name: "whole word", text: "I loved Dune", terms: []string{"dune"}, want: "I loved <mark>Dune</mark>"},
+		{name: "prefix marks the whole word", text: "Reading it again, rereading", terms: []string{"read"}, want: "<mark>Reading</mark> it again, rereading"},
+		{name: "several terms", text: "Paul and Chani", terms: []string{"paul", "chani"}, want: "<mark>Paul</mark> and <mark>Chani</mark>"},
+		{name: "no match", text: "Nothing here", terms: []string{"dune"}, want: "Nothing here"},
+		{name: "html around and inside marks", text: `<b>Tom & Jerry</b>`, terms: []string{"tom", "b"}, want: "&lt;<mark>b</mark>&gt;<mark>Tom</mark> &amp; Jerry&lt;/<mark>b</mark>&gt;"},
+		{name: "quotes escaped", text: `"Dune" isn't short`, terms: []string{"dune"}, want: "&#34;<mark>Dune</mark>&#34; isn&#39;t short"},
+		{name: "multibyte words", text: "Café crème brûlée", terms: []string{"crè", "brûlée"}, want: "Café <mark>crème</mark> <mark>brûlée</mark>"},
+		{name: "text without word breaks", text: "今日は本を読んだ。", terms: []string{"今日"}, want: "<mark>今日は本を読んだ</mark>。"},
+		{name: "short text isn't cut", text: "I loved Dune", terms: []string{"dune"}, maxRunes: 160, want: "I loved <mark>Dune</mark>"},
+		{name: "window around the match", text: long, terms: []string{"target"}, maxRunes: 20, want: "…hhhh <mark>target</mark> iiii jjjj…"},
+		{name: "window grows to whole words", text: long, terms: []string{"target"}, maxRunes: 24, want: "…gggg hhhh <mark>target</mark> iiii jjjj kkkk…"},
+		{name: "match at the start", text: "target aaaa bbbb cccc", terms: []string{"target"}, maxRunes: 10, want: "<mark>target</mark> aaaa…"},
+		{name: "match at the end still fills the window", text: long, terms: []string{"llll"}, maxRunes: 20, want: "…target iiii jjjj kkkk <mark>llll</mark>"},
+		{name: "no match keeps the start", text: long, terms: []string{"zzz"}, maxRunes: 12, want: "aaaa bbbb cccc…"},
+		{name: "window counted in runes", text: "ééé ààà target ùùù", terms: []string{"target"}, maxRunes: 8, want: "…ààà <mark>target</mark>…"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := highlight(tt.text, tt.terms, tt.maxRunes); got != tt.want {
+				t.Errorf("highlight(%q) =\n%q\nwant\n%q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,13 +1,26 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"image"
+	"io"
 	"log/slog"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -38,7 +51,7 @@ func (s *ProducerService) GetUser(identifier string) (*User, error) {
 	var user User
 
 	// Create a query
-	query := fmt.Sprintf("SELECT * FROM users WHERE %s = ?", field)
+	query := fmt.Sprintf("SELECT id, email, password, verified_at, totp_secret, totp_enabled_at, totp_last_step, role, disabled_at, deletion_scheduled_at, display_name, timezone, locale, date_format FROM users WHERE %s = ?", field)
 
 	// tx stuffs
 	tx, err := s.DB.Begin()
@@ -49,7 +62,22 @@ func (s *ProducerService) GetUser(identifier string) (*User, error) {
 	}
 
 	// Query and checks if the user with such email exists or nah
-	err = tx.QueryRowContext(context.Background(), query, identifier).Scan(&user.Id, &user.Email, &user.Password)
+	err = tx.QueryRowContext(context.Background(), query, identifier).Scan(
+		&user.Id,
+		&user.Email,
+		&user.Password,
+		&user.VerifiedAt,
+		&user.TotpSecret,
+		&user.TotpEnabledAt,
+		&user.TotpLastStep,
+		&user.Role,
+		&user.DisabledAt,
+		&user.DeletionScheduledAt,
+		&user.DisplayName,
+		&user.Timezone,
+		&user.Locale,
+		&user.DateFormat,
+	)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			slog.Error("User not found", "err", err)
@@ -61,6 +89,13 @@ func (s *ProducerService) GetUser(identifier string) (*User, error) {
 }
 
 func (s *ProducerService) CreateUser(req RequestAuthUser) (int, error) {
+	// Never store the raw password
+	hash, err := shared.HashPassword(req.Password)
+	if err != nil {
+		slog.Error("Error while hashing password", "err", err)
+		return 0, err
+	}
+
 	// Create a query
 	query := "INSERT INTO users (email, password) VALUES (?, ?)"
 
@@ -73,7 +108,7 @@ func (s *ProducerService) CreateUser(req RequestAuthUser) (int, error) {
 	}
 
 	// Execute the query with ExecContext
-	result, err := tx.ExecContext(context.Background(), query, req.Email, req.Password)
+	result, err := tx.ExecContext(context.Background(), query, req.Email, hash)
 	if err != nil {
 		slog.Error("Error while inserting data", "err", err)
 		return 0, err
@@ -89,26 +124,71 @@ func (s *ProducerService) CreateUser(req RequestAuthUser) (int, error) {
 	return int(id), nil
 }
 
-func (s *ProducerService) UserLogin(req RequestAuthUser) (int, error) {
-	// init some vars
-	user, err := s.GetUser(req.Email)
+func (s *ProducerService) UserLogin(req RequestAuthUser, ip string) (int, error) {
+	user, err := s.authenticate(req, ip)
 	if err != nil {
 		return 0, err
 	}
 
-	if user.Password != req.Password {
-		slog.Error("Wrong password", "err", err)
-		return 0, fiber.NewError(fiber.StatusBadRequest, "Wrong password")
+	// Only tell it's disabled after the password checked out, a guesser learns nothing from it
+	if user.DisabledAt.Valid {
+		return 0, errAccountDisabled
+	}
+	if user.DeletionScheduledAt.Valid {
+		return 0, errAccountPendingDeletion
 	}
 
 	return user.Id, nil
 }
 
-// BOOKS
+// authenticate checks the email and password, with the lockout and timing protection every login needs
+func (s *ProducerService) authenticate(req RequestAuthUser, ip string) (*User, error) {
+	// Locked accounts and ips don't even get their password checked
+	err := s.checkLoginLock(req.Email, ip)
+	if err != nil {
+		return nil, err
+	}
 
-func (s *ProducerService) CreateBook(userId int, req RequestCreateBook) error {
-	// Crate the query
-	query := "INSERT INTO books(user_id, title, author, total_pages) values(?, ?, ?, ?)"
+	// init some vars
+	user, err := s.GetUser(req.Email)
+	if err != nil {
+		var fiberErr *fiber.Error
+		if !errors.As(err, &fiberErr) {
+			return nil, err
+		}
+		// Unknown email, still burn the time a real check takes and count the failure
+		shared.SimulatePasswordCheck(req.Password)
+		s.recordLoginFailure(req.Email, ip)
+		return nil, errInvalidCredentials
+	}
+
+	ok, needsRehash := shared.VerifyPassword(user.Password, req.Password)
+	if !ok {
+		slog.Error("Wrong password")
+		s.recordLoginFailure(req.Email, ip)
+		return nil, errInvalidCredentials
+	}
+
+	s.clearLoginFailures(req.Email)
+
+	// Upgrade plaintext or weaker hashes while we still hold the raw password.
+	// Failing here shouldn't block the login, the next one will try again.
+	if needsRehash {
+		hash, err := shared.HashPassword(req.Password)
+		if err == nil {
+			err = s.setUserPassword(user.Id, hash)
+		}
+		if err != nil {
+			slog.Error("Error while rehashing password", "user_id", user.Id, "err", err)
+		}
+	}
+
+	return user, nil
+}
+
+func (s *ProducerService) setUserPassword(userId int, hash string) error {
+	// Create a query
+	query := "UPDATE users SET password = ? WHERE id = ?"
 
 	// tx stuffs
 	tx, err := s.DB.Begin()
@@ -118,85 +198,226 @@ func (s *ProducerService) CreateBook(userId int, req RequestCreateBook) error {
 		return err
 	}
 
-	// Execute the query
-	_, err = tx.ExecContext(context.Background(), query, userId, req.Title, req.Author, req.TotalPages)
+	// Execute the query with ExecContext
+	_, err = tx.ExecContext(context.Background(), query, hash, userId)
 	if err != nil {
-		slog.Error("Error while inserting data", "err", err)
+		slog.Error("Error while updating password", "err", err)
 		return err
 	}
 
 	return nil
 }
 
-func (s *ProducerService) GetAllBooksByUserId(userId int) ([]*ResponseGetBooks, error) {
-	// Initialize var to place the book
-	var books []*ResponseGetBooks
+// MigrateLegacyPasswords hashes every password that is still stored in plaintext.
+// Rows already holding a bcrypt hash are left alone, weaker costs get upgraded on the next login.
+func (s *ProducerService) MigrateLegacyPasswords() error {
+	// Only pick rows that don't look like a bcrypt hash
+	query := "SELECT id, password FROM users WHERE password NOT LIKE '$2_$%'"
 
-	// Query
-	query := "SELECT id, title, author, total_pages, status FROM books WHERE user_id = ?"
+	rows, err := s.DB.QueryContext(context.Background(), query)
+	if err != nil {
+		slog.Error("Eror while query", "err", err)
+		return err
+	}
+
+	// Collect first, we don't want to update while still reading the rows
+	legacy := map[int]string{}
+	for rows.Next() {
+		var id int
+		var password string
+		if err := rows.Scan(&id, &password); err != nil {
+			rows.Close()
+			return err
+		}
+		legacy[id] = password
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, password := range legacy {
+		// Someone could have registered a plaintext that happens to look like a hash prefix, skip those
+		if shared.IsPasswordHash(password) {
+			continue
+		}
+
+		hash, err := shared.HashPassword(password)
+		if err != nil {
+			slog.Error("Error while hashing legacy password", "user_id", id, "err", err)
+			return err
+		}
+
+		if err := s.setUserPassword(id, hash); err != nil {
+			return err
+		}
+	}
+
+	if len(legacy) > 0 {
+		slog.Info("Legacy plaintext passwords migrated", "count", len(legacy))
+	}
+
+	return nil
+}
+
+// SESSIONS
+
+var errRefreshTokenReused = errors.New("refresh token reused")
+
+func getAccessTokenTTL() time.Duration {
+	return shared.GetDuration("ACCESS_TOKEN_TTL", 15*time.Minute)
+}
+
+func getRefreshTokenTTL() time.Duration {
+	return shared.GetDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+}
+
+// CreateSession opens a new session for the user and hands out the first access and refresh token pair
+func (s *ProducerService) CreateSession(userId int, userAgent string, ip string) (tokens *ResponseTokens, err error) {
+	// Generate the refresh token first, only its hash goes to the database
+	refreshToken, err := shared.GenerateOpaqueToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a query
+	query := "INSERT INTO sessions (user_id, user_agent, ip_address, expires_at) VALUES (?, ?, ?, NOW() + INTERVAL ? SECOND)"
 
 	// tx stuffs
 	tx, err := s.DB.Begin()
-	defer shared.CommitOrRollback(tx, err)
 	if err != nil {
 		slog.Error("Commit Rollback error", "err", err)
 		return nil, err
 	}
+	defer func() {
+		err = shared.CommitOrRollback(tx, err)
+	}()
 
-	// Query
-	rows, err := tx.QueryContext(context.Background(), query, userId)
+	// Execute the query with ExecContext
+	result, err := tx.ExecContext(context.Background(), query, userId, shared.ClipRunes(userAgent, 255), ip, int(getRefreshTokenTTL().Seconds()))
 	if err != nil {
-		slog.Error("Eror while query", "err", err)
+		slog.Error("Error while inserting data", "err", err)
 		return nil, err
 	}
 
-	// close the rows of course
-	defer rows.Close()
+	sessionId, err := result.LastInsertId()
+	if err != nil {
+		slog.Error("Failed to get last insert ID", "err", err)
+		return nil, err
+	}
 
-	// Foreach-ing queried rows
-	for rows.Next() {
-		var book ResponseGetBooks
-		err := rows.Scan(&book.Id, &book.Title, &book.Author, &book.TotalPages, &book.Status)
-		if err != nil {
-			slog.Error("Error querying", "err", err)
-			return nil, err
-		}
-		books = append(books, &book)
+	// Store the refresh token of this session
+	_, err = tx.ExecContext(context.Background(), "INSERT INTO refresh_tokens (session_id, token_hash) VALUES (?, ?)", sessionId, shared.HashOpaqueToken(refreshToken))
+	if err != nil {
+		slog.Error("Error while inserting data", "err", err)
+		return nil, err
 	}
 
-	return books, err
+	return s.issueTokens(userId, int(sessionId), refreshToken)
 }
 
-func (s *ProducerService) GetBookById(bookId int, userId int) (*ResponseGetBook, error) {
-	// init some vars
-	var book ResponseGetBook
+func (s *ProducerService) issueTokens(userId int, sessionId int, refreshToken string) (*ResponseTokens, error) {
+	ttl := getAccessTokenTTL()
+	token, err := shared.GenerateToken(userId, sessionId, time.Now().Add(ttl))
+	if err != nil {
+		slog.Error("Error while generating token", "err", err)
+		return nil, err
+	}
 
-	// Create a query
-	query := "SELECT id, title, author, total_pages, status FROM books WHERE id = ? && user_id = ?"
+	return &ResponseTokens{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(ttl.Seconds()),
+	}, nil
+}
 
-	// tx stuffs
+// RefreshSession trades a refresh token for a new pair. Every refresh token works exactly once,
+// presenting one that was already used means it leaked somewhere, so the whole session gets killed.
+func (s *ProducerService) RefreshSession(refreshToken string, userAgent string, ip string) (*ResponseTokens, error) {
+	newRefreshToken, err := shared.GenerateOpaqueToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	userId, sessionId, err := s.rotateRefreshToken(shared.HashOpaqueToken(refreshToken), shared.HashOpaqueToken(newRefreshToken), userAgent, ip)
+	if errors.Is(err, errRefreshTokenReused) {
+		slog.Warn("Refresh token reuse detected, revoking session", "session_id", sessionId)
+		if err := s.revokeSession(sessionId); err != nil {
+			return nil, err
+		}
+		return nil, fiber.NewError(fiber.StatusUnauthorized, "Refresh token already used, session revoked")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.issueTokens(userId, sessionId, newRefreshToken)
+}
+
+// rotateRefreshToken marks the old token as used and stores the new one in a single transaction,
+// the row lock keeps two concurrent refreshes from both succeeding with the same token.
+func (s *ProducerService) rotateRefreshToken(oldHash string, newHash string, userAgent string, ip string) (userId int, sessionId int, err error) {
+	// tx stuffs, this one really has to roll back on failure
 	tx, err := s.DB.Begin()
-	defer shared.CommitOrRollback(tx, err)
 	if err != nil {
 		slog.Error("Commit Rollback error", "err", err)
-		return &book, err
+		return 0, 0, err
 	}
-
-	// Query and checks if the book exist
-	err = tx.QueryRowContext(context.Background(), query, bookId, userId).Scan(&book.Id, &book.Title, &book.Author, &book.TotalPages, &book.Status)
+	defer func() {
+		err = shared.CommitOrRollback(tx, err)
+	}()
+
+	// Find the token together with its session
+	query := `SELECT rt.id, rt.used_at IS NOT NULL, s.id, s.user_id, s.revoked_at IS NULL AND s.expires_at > NOW()
+		FROM refresh_tokens rt JOIN sessions s ON s.id = rt.session_id
+		WHERE rt.token_hash = ? FOR UPDATE`
+
+	var tokenId int
+	var used, active bool
+	err = tx.QueryRowContext(context.Background(), query, oldHash).Scan(&tokenId, &used, &sessionId, &userId, &active)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			slog.Error("Book not found", "err", err)
-			return &book, fiber.NewError(fiber.StatusBadRequest, "Book with such credentials does not exist")
+			return 0, 0, fiber.NewError(fiber.StatusUnauthorized, "Refresh token invalid")
 		}
+		slog.Error("Eror while query", "err", err)
+		return 0, 0, err
 	}
 
-	return &book, nil
+	if !active {
+		return 0, 0, fiber.NewError(fiber.StatusUnauthorized, "Session expired or revoked")
+	}
+	if used {
+		return 0, sessionId, errRefreshTokenReused
+	}
+
+	// Burn the old token
+	_, err = tx.ExecContext(context.Background(), "UPDATE refresh_tokens SET used_at = NOW() WHERE id = ?", tokenId)
+	if err != nil {
+		slog.Error("Error while updating data", "err", err)
+		return 0, 0, err
+	}
+
+	// Store the new one
+	_, err = tx.ExecContext(context.Background(), "INSERT INTO refresh_tokens (session_id, token_hash) VALUES (?, ?)", sessionId, newHash)
+	if err != nil {
+		slog.Error("Error while inserting data", "err", err)
+		return 0, 0, err
+	}
+
+	// Slide the session expiry and remember where it was used last
+	query = "UPDATE sessions SET last_used_at = NOW(), expires_at = NOW() + INTERVAL ? SECOND, user_agent = ?, ip_address = ? WHERE id = ?"
+	_, err = tx.ExecContext(context.Background(), query, int(getRefreshTokenTTL().Seconds()), shared.ClipRunes(userAgent, 255), ip, sessionId)
+	if err != nil {
+		slog.Error("Error while updating data", "err", err)
+		return 0, 0, err
+	}
+
+	return userId, sessionId, nil
 }
 
-func (s *ProducerService) DeleteBookById(bookId int, userId int) error {
+func (s *ProducerService) revokeSession(sessionId int) error {
 	// Create a query
-	query := "DELETE FROM books where id = ? && user_id = ?"
+	query := "UPDATE sessions SET revoked_at = NOW() WHERE id = ? AND revoked_at IS NULL"
 
 	// tx stuffs
 	tx, err := s.DB.Begin()
@@ -207,33 +428,80 @@ func (s *ProducerService) DeleteBookById(bookId int, userId int) error {
 	}
 
 	// Execute the query with ExecContext
-	result, err := tx.ExecContext(context.Background(), query, bookId, userId)
+	_, err = tx.ExecContext(context.Background(), query, sessionId)
 	if err != nil {
-		slog.Error("Error while inserting data", "err", err)
+		slog.Error("Error while updating data", "err", err)
 		return err
 	}
 
-	// checks the affected row to make sure if there is in fact deleted book
-	rowsAffected, err := result.RowsAffected()
+	return nil
+}
+
+// Logout revokes the session that owns the given refresh token
+func (s *ProducerService) Logout(refreshToken string) error {
+	var sessionId int
+
+	// Create a query
+	query := "SELECT session_id FROM refresh_tokens WHERE token_hash = ?"
+
+	err := s.DB.QueryRowContext(context.Background(), query, shared.HashOpaqueToken(refreshToken)).Scan(&sessionId)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return fiber.NewError(fiber.StatusUnauthorized, "Refresh token invalid")
+		}
+		slog.Error("Eror while query", "err", err)
 		return err
 	}
-	if rowsAffected == 0 {
-		slog.Error("Failed, no rows affected")
-		return fiber.NewError(fiber.StatusBadRequest, "Delete failed, book probably does not exist")
-	}
 
-	return nil
+	return s.revokeSession(sessionId)
 }
 
-func (s *ProducerService) SetBookStatus(bookId int, status string) error {
-	if status != "reading" && status != "completed" {
-		slog.Error("Status invalid")
-		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+func (s *ProducerService) GetActiveSessions(userId int, currentSessionId int) ([]*ResponseGetSession, error) {
+	// Initialize var to place the sessions
+	var sessions []*ResponseGetSession
+
+	// Query
+	query := `SELECT id, user_agent, ip_address, created_at, last_used_at, expires_at FROM sessions
+		WHERE user_id = ? AND revoked_at IS NULL AND expires_at > NOW() ORDER BY last_used_at DESC`
+
+	// Query
+	rows, err := s.DB.QueryContext(context.Background(), query, userId)
+	if err != nil {
+		slog.Error("Eror while query", "err", err)
+		return nil, err
+	}
+
+	// close the rows of course
+	defer rows.Close()
+
+	// Times are shown in the user's timezone
+	loc := s.getUserLocation(userId)
+
+	// Foreach-ing queried rows
+	for rows.Next() {
+		var session ResponseGetSession
+		var userAgent, ip sql.NullString
+		err := rows.Scan(&session.Id, &userAgent, &ip, &session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt)
+		if err != nil {
+			slog.Error("Error querying", "err", err)
+			return nil, err
+		}
+		session.CreatedAt = session.CreatedAt.In(loc)
+		session.LastUsedAt = session.LastUsedAt.In(loc)
+		session.ExpiresAt = session.ExpiresAt.In(loc)
+		session.UserAgent = userAgent.String
+		session.IpAddress = ip.String
+		session.Current = session.Id == currentSessionId
+		sessions = append(sessions, &session)
 	}
 
+	return sessions, rows.Err()
+}
+
+// RevokeUserSession kills one of the user's own sessions, e.g. a forgotten login on another device
+func (s *ProducerService) RevokeUserSession(sessionId int, userId int) error {
 	// Create a query
-	query := "UPDATE books SET status = ? WHERE id = ?"
+	query := "UPDATE sessions SET revoked_at = NOW() WHERE id = ? AND user_id = ? AND revoked_at IS NULL"
 
 	// tx stuffs
 	tx, err := s.DB.Begin()
@@ -244,98 +512,4101 @@ func (s *ProducerService) SetBookStatus(bookId int, status string) error {
 	}
 
 	// Execute the query with ExecContext
-	result, err := tx.ExecContext(context.Background(), query, status, bookId)
+	result, err := tx.ExecContext(context.Background(), query, sessionId, userId)
 	if err != nil {
-		slog.Error("Error while inserting data", "err", err)
+		slog.Error("Error while updating data", "err", err)
 		return err
 	}
 
-	// checks the affected row to make sure if there is in fact updated book
+	// checks the affected row to make sure if there is in fact revoked session
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
 	if rowsAffected == 0 {
 		slog.Error("Failed, no rows affected")
-		return fiber.NewError(fiber.StatusBadRequest, "Update failed, no rows affected")
+		return fiber.NewError(fiber.StatusBadRequest, "Revoke failed, session probably does not exist")
 	}
 
 	return nil
 }
 
-// PROGRESSES
-func (s *ProducerService) CreateProgress(req RequestCreateProgress) error {
+// VERIFICATION
 
-	// Acquire latest progress for validation purpose (make sure if the FROM_PAGE and UNTIl_PAGE is right)
-	previousProgress, err := s.getLatestProgress(req.BookId)
+const purposeVerifyEmail = "verify-email"
+
+func getVerificationTokenTTL() time.Duration {
+	return shared.GetDuration("VERIFICATION_TOKEN_TTL", 24*time.Hour)
+}
+
+// SendVerificationEmail signs a fresh verification token and hands it to the consumer to be mailed.
+// Resends are throttled so the endpoint can't be used to spam someone's inbox.
+func (s *ProducerService) SendVerificationEmail(userId int) error {
+	user, err := s.GetUser(strconv.Itoa(userId))
 	if err != nil {
 		return err
 	}
 
-	// Acquire the book to check if the page is maxed out or not
-	book, err := s.GetBookById(req.BookId, req.UserId)
+	if user.VerifiedAt.Valid {
+		return fiber.NewError(fiber.StatusBadRequest, "Email already verified")
+	}
+
+	// Checks when the last verification email went out
+	var recent bool
+	query := "SELECT COUNT(*) > 0 FROM email_verifications WHERE user_id = ? AND created_at > NOW() - INTERVAL ? SECOND"
+	throttle := shared.GetDuration("VERIFICATION_RESEND_INTERVAL", time.Minute)
+	err = s.DB.QueryRowContext(context.Background(), query, userId, int(throttle.Seconds())).Scan(&recent)
 	if err != nil {
-		slog.Error("GetBookById")
+		slog.Error("Eror while query", "err", err)
 		return err
 	}
+	if recent {
+		return fiber.NewError(fiber.StatusTooManyRequests, "Verification email was just sent, please wait before asking again")
+	}
 
-	// checks if the book already finished?
-	if book.Status == "completed" {
-		return fiber.NewError(fiber.StatusBadRequest, "Sorry but you're already finished your book!")
+	// The jti is what makes the token single use, only its hash is stored
+	tokenId, err := shared.GenerateOpaqueToken(16)
+	if err != nil {
+		return err
 	}
 
-	// checks if it's exceeds book page
-	if req.UntilPage > book.TotalPages {
-		return fiber.NewError(fiber.StatusBadRequest, "Until Page exceeds book's page")
+	ttl := getVerificationTokenTTL()
+	expiry := time.Now().Add(ttl)
+	token, err := shared.GeneratePurposeToken(userId, purposeVerifyEmail, tokenId, expiry)
+	if err != nil {
+		slog.Error("Error while generating token", "err", err)
+		return err
 	}
 
-	// checks if it's maxed out or nah
-	if book.TotalPages == req.UntilPage {
-		// Set the book status to completed
-		err = s.SetBookStatus(book.Id, "completed")
-		if err != nil {
-			slog.Error("Calls setbookstatus")
-			return err
-		}
+	// Create a query
+	query = "INSERT INTO email_verifications (user_id, token_hash, expires_at) VALUES (?, ?, NOW() + INTERVAL ? SECOND)"
+
+	// tx stuffs
+	tx, err := s.DB.Begin()
+	defer shared.CommitOrRollback(tx, err)
+	if err != nil {
+		slog.Error("Commit Rollback error", "err", err)
+		return err
 	}
 
-	// Checks if the progress fulfilled a goal
-	goals, err := s.GetAllGoalsWithBookId(req.BookId, req.UserId)
+	// Execute the query with ExecContext
+	_, err = tx.ExecContext(context.Background(), query, userId, shared.HashOpaqueToken(tokenId), int(ttl.Seconds()))
 	if err != nil {
-		slog.Error("Calls GetAllGoals")
+		slog.Error("Error while inserting data", "err", err)
 		return err
 	}
 
-	// Checks all goals
-	for _, goal := range goals {
-		// Skip if goal's status finished
-		if goal.Status != "in-progress" {
-			continue
-		}
-		if req.UntilPage >= goal.TargetPage {
-			// Set the goal status
-			err = s.SetGoalStatus("finished", goal.Id)
-			if err != nil {
-				return err
-			}
+	msg := &shared.VerificationMsg{
+		Recipient: user.Recipient(),
+		Email:     user.Email,
+		Link:      appURL("/api/auth/verify?token=" + url.QueryEscape(token)),
+		ExpiredAt: expiry,
+	}
 
-			user, err := s.GetUser(strconv.Itoa(req.UserId))
-			if err != nil {
-				return err
-			}
+	return s.publishAccountMessage("verification", msg)
+}
 
-			msg := &shared.Msg{
-				Id:         goal.Id,
-				Email:      user.Email,
-				Name:       goal.Name,
-				BookTitle:  book.Title,
-				TargetPage: goal.TargetPage,
-				ExpiredAt:  goal.ExpiredAt,
-			}
+// VerifyEmail burns the verification token and marks the owner's email as verified
+func (s *ProducerService) VerifyEmail(token string) (err error) {
+	claims, err := shared.ValidatePurposeToken(token, purposeVerifyEmail)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Verification token invalid or expired")
+	}
 
-			body, err := json.Marshal(msg)
-			if err != nil {
-				return err
+	// tx stuffs, both updates go together or not at all
+	tx, err := s.DB.Begin()
+	if err != nil {
+		slog.Error("Commit Rollback error", "err", err)
+		return err
+	}
+	defer func() {
+		err = shared.CommitOrRollback(tx, err)
+	}()
+
+	// Lock the row so the same token can't be used twice at the same time
+	var id, userId int
+	var used bool
+	query := "SELECT id, user_id, used_at IS NOT NULL FROM email_verifications WHERE token_hash = ? FOR UPDATE"
+	err = tx.QueryRowContext(context.Background(), query, shared.HashOpaqueToken(claims.ID)).Scan(&id, &userId, &used)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fiber.NewError(fiber.StatusBadRequest, "Verification token invalid or expired")
+		}
+		slog.Error("Eror while query", "err", err)
+		return err
+	}
+
+	if used || strconv.Itoa(userId) != claims.Subject {
+		return fiber.NewError(fiber.StatusBadRequest, "Verification token already used")
+	}
+
+	_, err = tx.ExecContext(context.Background(), "UPDATE email_verifications SET used_at = NOW() WHERE id = ?", id)
+	if err != nil {
+		slog.Error("Error while updating data", "err", err)
+		return err
+	}
+
+	// Keep the first verification date if the user somehow verifies twice
+	_, err = tx.ExecContext(context.Background(), "UPDATE users SET verified_at = COALESCE(verified_at, NOW()) WHERE id = ?", userId)
+	if err != nil {
+		slog.Error("Error while updating data", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+// publishAccountMessage sends account related mails (verification and friends) to the consumer
+func (s *ProducerService) publishAccountMessage(routingKey string, msg any) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	// Make agent
+	agent, err := shared.NewAgent(s.AMQP, context.Background())
+	if err != nil {
+		return err
+	}
+	defer agent.Channel.Close()
+
+	// Make agent work! Publish a message
+	return agent.Publish(amqp091.Publishing{
+		DeliveryMode: amqp091.Persistent,
+		ContentType:  "application/json",
+		Body:         body,
+	}, "account_exchange", routingKey)
+}
+
+// appURL builds an absolute link to Hon for emails, APP_URL is the public address the producer is reachable at
+func appURL(path string) string {
+	base := strings.TrimRight(shared.NewConfig().GetString("APP_URL"), "/")
+	if base == "" {
+		base = "http://localhost:3000"
+	}
+
+	return base + path
+}
+
+// PASSWORDS
+
+// RequestPasswordReset mails a reset token when the email belongs to someone.
+// It never tells the caller whether the account exists, and quietly drops requests that come too often.
+func (s *ProducerService) RequestPasswordReset(email string) error {
+	user, err := s.GetUser(email)
+	if err != nil {
+		var fiberErr *fiber.Error
+		if errors.As(err, &fiberErr) && fiberErr.Code == fiber.StatusBadRequest {
+			return nil
+		}
+		return err
+	}
+
+	// Checks when the last reset email went out
+	var recent bool
+	query := "SELECT COUNT(*) > 0 FROM password_resets WHERE user_id = ? AND created_at > NOW() - INTERVAL ? SECOND"
+	throttle := shared.GetDuration("PASSWORD_RESET_RESEND_INTERVAL", time.Minute)
+	err = s.DB.QueryRowContext(context.Background(), query, user.Id, int(throttle.Seconds())).Scan(&recent)
+	if err != nil {
+		slog.Error("Eror while query", "err", err)
+		return err
+	}
+	if recent {
+		slog.Info("Password reset throttled", "user_id", user.Id)
+		return nil
+	}
+
+	token, err := shared.GenerateOpaqueToken(32)
+	if err != nil {
+		return err
+	}
+
+	// Create a query
+	ttl := shared.GetDuration("PASSWORD_RESET_TOKEN_TTL", time.Hour)
+	query = "INSERT INTO password_resets (user_id, token_hash, expires_at) VALUES (?, ?, NOW() + INTERVAL ? SECOND)"
+
+	// tx stuffs
+	tx, err := s.DB.Begin()
+	defer shared.CommitOrRollback(tx, err)
+	if err != nil {
+		slog.Error("Commit Rollback error", "err", err)
+		return err
+	}
+
+	// Execute the query with ExecContext
+	_, err = tx.ExecContext(context.Background(), query, user.Id, shared.HashOpaqueToken(token), int(ttl.Seconds()))
+	if err != nil {
+		slog.Error("Error while inserting data", "err", err)
+		return err
+	}
+
+	// The link is optional, without a frontend the user just copies the token
+	link := ""
+	if resetURL := shared.NewConfig().GetString("PASSWORD_RESET_URL"); resetURL != "" {
+		link = resetURL + "?token=" + url.QueryEscape(token)
+	}
+
+	msg := &shared.PasswordResetMsg{
+		Recipient: user.Recipient(),
+		Email:     user.Email,
+		Token:     token,
+		Link:      link,
+		ExpiredAt: time.Now().Add(ttl),
+	}
+
+	return s.publishAccountMessage("password_reset", msg)
+}
+
+// ResetPassword sets a new password using a reset token, then logs the user out everywhere
+func (s *ProducerService) ResetPassword(req RequestResetPassword) (err error) {
+	hash, err := shared.HashPassword(req.Password)
+	if err != nil {
+		slog.Error("Error while hashing password", "err", err)
+		return err
+	}
+
+	// tx stuffs, the password, the token and the sessions change together
+	tx, err := s.DB.Begin()
+	if err != nil {
+		slog.Error("Commit Rollback error", "err", err)
+		return err
+	}
+	defer func() {
+		err = shared.CommitOrRollback(tx, err)
+	}()
+
+	// Lock the token row so it can't be used twice at the same time
+	var userId int
+	query := "SELECT user_id FROM password_resets WHERE token_hash = ? AND used_at IS NULL AND expires_at > NOW() FOR UPDATE"
+	err = tx.QueryRowContext(context.Background(), query, shared.HashOpaqueToken(req.Token)).Scan(&userId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fiber.NewError(fiber.StatusBadRequest, "Reset token invalid or expired")
+		}
+		slog.Error("Eror while query", "err", err)
+		return err
+	}
+
+	// Receiving the mail proves the email is theirs, so it counts as verified too
+	_, err = tx.ExecContext(context.Background(), "UPDATE users SET password = ?, verified_at = COALESCE(verified_at, NOW()) WHERE id = ?", hash, userId)
+	if err != nil {
+		slog.Error("Error while updating data", "err", err)
+		return err
+	}
+	basicLogins.forget(userId)
+
+	// Burn this token and every other pending one of the user
+	_, err = tx.ExecContext(context.Background(), "UPDATE password_resets SET used_at = NOW() WHERE user_id = ? AND used_at IS NULL", userId)
+	if err != nil {
+		slog.Error("Error while updating data", "err", err)
+		return err
+	}
+
+	err = revokeAllSessions(tx, userId)
+	return err
+}
+
+// ChangePassword replaces the password of a logged in user after checking the current one.
+// Every session is revoked, the caller gets a fresh one so it doesn't log itself out.
+func (s *ProducerService) ChangePassword(userId int, req RequestChangePassword, userAgent string, ip string) (*ResponseTokens, error) {
+	user, err := s.GetUser(strconv.Itoa(userId))
+	if err != nil {
+		return nil, err
+	}
+
+	ok, _ := shared.VerifyPassword(user.Password, req.CurrentPassword)
+	if !ok {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Current password is wrong")
+	}
+
+	hash, err := shared.HashPassword(req.Password)
+	if err != nil {
+		slog.Error("Error while hashing password", "err", err)
+		return nil, err
+	}
+
+	err = s.replacePassword(userId, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.CreateSession(userId, userAgent, ip)
+}
+
+func (s *ProducerService) replacePassword(userId int, hash string) (err error) {
+	// tx stuffs
+	tx, err := s.DB.Begin()
+	if err != nil {
+		slog.Error("Commit Rollback error", "err", err)
+		return err
+	}
+	defer func() {
+		err = shared.CommitOrRollback(tx, err)
+	}()
+
+	_, err = tx.ExecContext(context.Background(), "UPDATE users SET password = ? WHERE id = ?", hash, userId)
+	if err != nil {
+		slog.Error("Error while updating data", "err", err)
+		return err
+	}
+	basicLogins.forget(userId)
+
+	err = revokeAllSessions(tx, userId)
+	return err
+}
+
+// revokeAllSessions logs the user out of every device, it runs inside the caller's transaction
+func revokeAllSessions(tx *sql.Tx, userId int) error {
+	_, err := tx.ExecContext(context.Background(), "UPDATE sessions SET revoked_at = NOW() WHERE user_id = ? AND revoked_at IS NULL", userId)
+	if err != nil {
+		slog.Error("Error while revoking sessions", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+// TWO FACTOR
+
+const (
+	purposeTwoFactorChallenge = "2fa-challenge"
+	recoveryCodeCount         = 10
+)
+
+// StartLogin is what the login handler calls after the password checked out.
+// Users without 2FA get their session right away, the others get a short-lived challenge token instead.
+func (s *ProducerService) StartLogin(userId int, userAgent string, ip string) (*ResponseTokens, string, error) {
+	user, err := s.GetUser(strconv.Itoa(userId))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !user.TotpEnabledAt.Valid {
+		tokens, err := s.CreateSession(userId, userAgent, ip)
+		return tokens, "", err
+	}
+
+	challengeId, err := shared.GenerateOpaqueToken(16)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ttl := shared.GetDuration("TWO_FACTOR_CHALLENGE_TTL", 5*time.Minute)
+	challenge, err := shared.GeneratePurposeToken(userId, purposeTwoFactorChallenge, challengeId, time.Now().Add(ttl))
+	if err != nil {
+		slog.Error("Error while generating token", "err", err)
+		return nil, "", err
+	}
+
+	return nil, challenge, nil
+}
+
+// CompleteTwoFactorLogin exchanges the challenge token plus a TOTP or recovery code for the real session
+func (s *ProducerService) CompleteTwoFactorLogin(req RequestTwoFactorLogin, userAgent string, ip string) (*ResponseTokens, error) {
+	claims, err := shared.ValidatePurposeToken(req.ChallengeToken, purposeTwoFactorChallenge)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusUnauthorized, "Challenge token invalid or expired")
+	}
+
+	userId, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusUnauthorized, "Challenge token invalid or expired")
+	}
+
+	user, err := s.GetUser(claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	// Guessing codes counts against the same limits as guessing passwords
+	err = s.checkLoginLock(user.Email, ip)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.RecoveryCode != "" {
+		err = s.useRecoveryCode(userId, req.RecoveryCode)
+	} else {
+		err = s.checkTotpCode(user, req.Code)
+	}
+	if err != nil {
+		var fiberErr *fiber.Error
+		if errors.As(err, &fiberErr) && fiberErr.Code == fiber.StatusUnauthorized {
+			s.recordLoginFailure(user.Email, ip)
+		}
+		return nil, err
+	}
+
+	s.clearLoginFailures(user.Email)
+
+	return s.CreateSession(userId, userAgent, ip)
+}
+
+// EnrollTwoFactor generates a secret and keeps it pending until the user proves their app has it
+func (s *ProducerService) EnrollTwoFactor(userId int) (*ResponseTwoFactorEnrollment, error) {
+	user, err := s.GetUser(strconv.Itoa(userId))
+	if err != nil {
+		return nil, err
+	}
+
+	if user.TotpEnabledAt.Valid {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Two-factor authentication is already enabled")
+	}
+
+	secret, err := shared.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a query, enrolling again simply replaces the pending secret
+	query := "UPDATE users SET totp_secret = ?, totp_last_step = NULL WHERE id = ? AND totp_enabled_at IS NULL"
+
+	// tx stuffs
+	tx, err := s.DB.Begin()
+	defer shared.CommitOrRollback(tx, err)
+	if err != nil {
+		slog.Error("Commit Rollback error", "err", err)
+		return nil, err
+	}
+
+	// Execute the query with ExecContext
+	_, err = tx.ExecContext(context.Background(), query, secret, userId)
+	if err != nil {
+		slog.Error("Error while updating data", "err", err)
+		return nil, err
+	}
+
+	return &ResponseTwoFactorEnrollment{
+		Secret:     secret,
+		OtpauthURI: shared.TOTPURI(secret, user.Email, "Hon"),
+	}, nil
+}
+
+// ConfirmTwoFactor turns 2FA on once the first code matches, and hands out the recovery codes
+func (s *ProducerService) ConfirmTwoFactor(userId int, code string) ([]string, error) {
+	user, err := s.GetUser(strconv.Itoa(userId))
+	if err != nil {
+		return nil, err
+	}
+
+	if user.TotpEnabledAt.Valid {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Two-factor authentication is already enabled")
+	}
+	if !user.TotpSecret.Valid {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Start the enrollment first")
+	}
+
+	err = s.checkTotpCode(user, code)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a query
+	query := "UPDATE users SET totp_enabled_at = NOW() WHERE id = ?"
+
+	// tx stuffs
+	tx, err := s.DB.Begin()
+	defer shared.CommitOrRollback(tx, err)
+	if err != nil {
+		slog.Error("Commit Rollback error", "err", err)
+		return nil, err
+	}
+
+	// Execute the query with ExecContext
+	_, err = tx.ExecContext(context.Background(), query, userId)
+	if err != nil {
+		slog.Error("Error while updating data", "err", err)
+		return nil, err
+	}
+	basicLogins.forget(userId)
+
+	return s.RegenerateRecoveryCodes(userId)
+}
+
+// DisableTwoFactor needs both the password and a code, a stolen session alone shouldn't be able to turn 2FA off
+func (s *ProducerService) DisableTwoFactor(userId int, req RequestDisableTwoFactor) (err error) {
+	user, err := s.GetUser(strconv.Itoa(userId))
+	if err != nil {
+		return err
+	}
+
+	if !user.TotpEnabledAt.Valid {
+		return fiber.NewError(fiber.StatusBadRequest, "Two-factor authentication is not enabled")
+	}
+
+	ok, _ := shared.VerifyPassword(user.Password, req.Password)
+	if !ok {
+		return fiber.NewError(fiber.StatusBadRequest, "Wrong password")
+	}
+
+	err = s.checkTotpCode(user, req.Code)
+	if err != nil {
+		return err
+	}
+
+	// tx stuffs, the secret and the recovery codes go away together
+	tx, err := s.DB.Begin()
+	if err != nil {
+		slog.Error("Commit Rollback error", "err", err)
+		return err
+	}
+	defer func() {
+		err = shared.CommitOrRollback(tx, err)
+	}()
+
+	_, err = tx.ExecContext(context.Background(), "UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL WHERE id = ?", userId)
+	if err != nil {
+		slog.Error("Error while updating data", "err", err)
+		return err
+	}
+
+	_, err = tx.ExecContext(context.Background(), "DELETE FROM recovery_codes WHERE user_id = ?", userId)
+	if err != nil {
+		slog.Error("Error while deleting data", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+// RotateRecoveryCodes asks for a fresh code before replacing the recovery codes
+func (s *ProducerService) RotateRecoveryCodes(userId int, code string) ([]string, error) {
+	user, err := s.GetUser(strconv.Itoa(userId))
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.TotpEnabledAt.Valid {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Two-factor authentication is not enabled")
+	}
+
+	err = s.checkTotpCode(user, code)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.RegenerateRecoveryCodes(userId)
+}
+
+// RegenerateRecoveryCodes throws the old codes away and returns a new set, only the hashes are stored
+func (s *ProducerService) RegenerateRecoveryCodes(userId int) (codes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+
+	// tx stuffs
+	tx, err := s.DB.Begin()
+	if err != nil {
+		slog.Error("Commit Rollback error", "err", err)
+		return nil, err
+	}
+	defer func() {
+		err = shared.CommitOrRollback(tx, err)
+	}()
+
+	_, err = tx.ExecContext(context.Background(), "DELETE FROM recovery_codes WHERE user_id = ?", userId)
+	if err != nil {
+		slog.Error("Error while deleting data", "err", err)
+		return nil, err
+	}
+
+	for _, code := range codes {
+		_, err = tx.ExecContext(context.Background(), "INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)", userId, shared.HashOpaqueToken(normalizeRecoveryCode(code)))
+		if err != nil {
+			slog.Error("Error while inserting data", "err", err)
+			return nil, err
+		}
+	}
+
+	return codes, nil
+}
+
+// checkTotpCode validates the code and remembers its step so the same code can't be used twice
+func (s *ProducerService) checkTotpCode(user *User, code string) error {
+	if !user.TotpSecret.Valid {
+		return fiber.NewError(fiber.StatusBadRequest, "Two-factor authentication is not enabled")
+	}
+
+	step, ok := shared.ValidateTOTP(user.TotpSecret.String, code, time.Now())
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid two-factor code")
+	}
+
+	// Only move forward, the condition makes two requests racing with the same code fail one of them
+	query := "UPDATE users SET totp_last_step = ? WHERE id = ? AND (totp_last_step IS NULL OR totp_last_step < ?)"
+	result, err := s.DB.ExecContext(context.Background(), query, step, user.Id, step)
+	if err != nil {
+		slog.Error("Error while updating data", "err", err)
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return fiber.NewError(fiber.StatusUnauthorized, "Two-factor code already used")
+	}
+
+	return nil
+}
+
+func (s *ProducerService) useRecoveryCode(userId int, code string) error {
+	// Create a query, burning the code is the check itself
+	query := "UPDATE recovery_codes SET used_at = NOW() WHERE user_id = ? AND code_hash = ? AND used_at IS NULL"
+
+	result, err := s.DB.ExecContext(context.Background(), query, userId, shared.HashOpaqueToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		slog.Error("Error while updating data", "err", err)
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid recovery code")
+	}
+
+	return nil
+}
+
+// generateRecoveryCode makes codes like "k3j9x-q8w2m", easy enough to type from a piece of paper
+func generateRecoveryCode() (string, error) {
+	// 32 characters, so masking a random byte picks each one with the same odds
+	const alphabet = "abcdefghijklmnopqrstuvwxyz234567"
+
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	code := make([]byte, 0, 11)
+	for i, b := range buf {
+		if i == 5 {
+			code = append(code, '-')
+		}
+		code = append(code, alphabet[b&31])
+	}
+
+	return string(code), nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+// API KEYS
+
+// CreateAPIKey generates a key for the user, the plain key is only ever returned here
+func (s *ProducerService) CreateAPIKey(userId int, req RequestCreateAPIKey) (*ResponseCreateAPIKey, error) {
+	if req.ExpiresAt != nil && !time.Now().Before(*req.ExpiresAt) {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Expiry must be in the future")
+	}
+
+	secret, err := shared.GenerateOpaqueToken(32)
+	if err != nil {
+		return nil, err
+	}
+	key := shared.APIKeyPrefix + secret
+	// The prefix is stored so users can tell their keys apart in the list
+	prefix := key[:len(shared.APIKeyPrefix)+8]
+
+	// Create a query
+	query := "INSERT INTO api_keys (user_id, name, prefix, key_hash, read_only, expires_at) VALUES (?, ?, ?, ?, ?, ?)"
+
+	// tx stuffs
+	tx, err := s.DB.Begin()
+	defer shared.CommitOrRollback(tx, err)
+	if err != nil {
+		slog.Error("Commit Rollback error", "err", err)
+		return nil, err
+	}
+
+	// Execute the query with ExecContext
+	result, err := tx.ExecContext(context.Background(), query, userId, req.Name, prefix, shared.HashOpaqueToken(key), req.ReadOnly, req.ExpiresAt)
+	if err != nil {
+		slog.Error("Error while inserting data", "err", err)
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		slog.Error("Failed to get last insert ID", "err", err)
+		return nil, err
+	}
+
+	return &ResponseCreateAPIKey{
+		Id:        int(id),
+		Name:      req.Name,
+		Key:       key,
+		ReadOnly:  req.ReadOnly,
+		ExpiresAt: req.ExpiresAt,
+	}, nil
+}
+
+func (s *ProducerService) GetAPIKeys(userId int) ([]*ResponseGetAPIKey, error) {
+	// Initialize var to place the keys
+	var keys []*ResponseGetAPIKey
+
+	// Query, revoked keys are gone for the user
+	query := `SELECT id, name, prefix, read_only, created_at, last_used_at, expires_at FROM api_keys
+		WHERE user_id = ? AND revoked_at IS NULL ORDER BY created_at DESC`
+
+	rows, err := s.DB.QueryContext(context.Background(), query, userId)
+	if err != nil {
+		slog.Error("Eror while query", "err", err)
+		return nil, err
+	}
+
+	// close the rows of course
+	defer rows.Close()
+
+	// Times are shown in the user's timezone
+	loc := s.getUserLocation(userId)
+
+	// Foreach-ing queried rows
+	for rows.Next() {
+		var key ResponseGetAPIKey
+		var lastUsedAt, expiresAt sql.NullTime
+		err := rows.Scan(&key.Id, &key.Name, &key.Prefix, &key.ReadOnly, &key.CreatedAt, &lastUsedAt, &expiresAt)
+		if err != nil {
+			slog.Error("Error querying", "err", err)
+			return nil, err
+		}
+		key.CreatedAt = key.CreatedAt.In(loc)
+		if lastUsedAt.Valid {
+			lastUsedAt.Time = lastUsedAt.Time.In(loc)
+			key.LastUsedAt = &lastUsedAt.Time
+		}
+		if expiresAt.Valid {
+			expiresAt.Time = expiresAt.Time.In(loc)
+			key.ExpiresAt = &expiresAt.Time
+			key.Expired = !time.Now().Before(expiresAt.Time)
+		}
+		keys = append(keys, &key)
+	}
+
+	return keys, rows.Err()
+}
+
+func (s *ProducerService) RevokeAPIKey(keyId int, userId int) error {
+	// Create a query
+	query := "UPDATE api_keys SET revoked_at = NOW() WHERE id = ? AND user_id = ? AND revoked_at IS NULL"
+
+	// tx stuffs
+	tx, err := s.DB.Begin()
+	defer shared.CommitOrRollback(tx, err)
+	if err != nil {
+		slog.Error("Commit Rollback error", "err", err)
+		return err
+	}
+
+	// Execute the query with ExecContext
+	result, err := tx.ExecContext(context.Background(), query, keyId, userId)
+	if err != nil {
+		slog.Error("Error while updating data", "err", err)
+		return err
+	}
+
+	// checks the affected row to make sure if there is in fact revoked key
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		slog.Error("Failed, no rows affected")
+		return fiber.NewError(fiber.StatusBadRequest, "Revoke failed, API key probably does not exist")
+	}
+
+	return nil
+}
+
+// CheckSession is registered as the shared.SessionChecker, it runs on every request made with an access token
+func (s *ProducerService) CheckSession(sessionId int, userId int) error {
+	var alive bool
+
+	// Query
+	query := "SELECT revoked_at IS NULL FROM sessions WHERE id = ? AND user_id = ?"
+
+	err := s.DB.QueryRowContext(context.Background(), query, sessionId, userId).Scan(&alive)
+	if err != nil && err != sql.ErrNoRows {
+		slog.Error("Eror while query", "err", err)
+		return err
+	}
+	if !alive {
+		return fiber.NewError(fiber.StatusUnauthorized, "Session revoked")
+	}
+
+	return nil
+}
+
+// ResolveAPIKey is registered as the shared.APIKeyResolver, it runs on every request made with an API key
+func (s *ProducerService) ResolveAPIKey(key string) (*shared.Principal, error) {
+	var id, userId int
+	var readOnly bool
+
+	// Query
+	query := `SELECT k.id, k.user_id, k.read_only FROM api_keys k JOIN users u ON u.id = k.user_id
+		WHERE k.key_hash = ? AND k.revoked_at IS NULL AND (k.expires_at IS NULL OR k.expires_at > NOW()) AND u.disabled_at IS NULL`
+
+	err := s.DB.QueryRowContext(context.Background(), query, shared.HashOpaqueToken(key)).Scan(&id, &userId, &readOnly)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fiber.NewError(fiber.StatusUnauthorized, "API key invalid")
+		}
+		slog.Error("Eror while query", "err", err)
+		return nil, err
+	}
+
+	// A bot hitting the API every second doesn't need a write every second, once a minute is precise enough
+	query = "UPDATE api_keys SET last_used_at = NOW() WHERE id = ? AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL 1 MINUTE)"
+	if _, err := s.DB.ExecContext(context.Background(), query, id); err != nil {
+		slog.Error("Error while updating API key usage", "err", err)
+	}
+
+	scopes := shared.DefaultScopes
+	if readOnly {
+		scopes = []string{shared.ScopeRead}
+	}
+
+	return &shared.Principal{UserId: userId, APIKeyId: id, Scopes: scopes}, nil
+}
+
+// ResolvePassword lets HTTP basic clients like e-readers in with the account password, they only get to read.
+// The password alone isn't enough for accounts with 2FA, those use an API key as the password instead.
+// Those clients send the password with every request, so a checked one is remembered for BASIC_AUTH_CACHE_TTL,
+// and their failures count apart from the web login's, an e-reader stuck on an old password doesn't lock the account.
+func (s *ProducerService) ResolvePassword(email string, password string, ip string) (*shared.Principal, error) {
+	key := basicLoginKey(email, password)
+	if userId, ok := basicLogins.get(key); ok {
+		return &shared.Principal{UserId: userId, Scopes: []string{shared.ScopeRead}}, nil
+	}
+
+	err := s.checkThrottleLock(throttleBasicAccount, throttleBasicIp, email, ip)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.GetUser(email)
+	if err != nil {
+		var fiberErr *fiber.Error
+		if !errors.As(err, &fiberErr) {
+			return nil, err
+		}
+		shared.SimulatePasswordCheck(password)
+		s.recordBasicFailure(email, ip)
+		return nil, errInvalidCredentials
+	}
+
+	// Rehashing is left to the web login, it isn't worth a write from here
+	if ok, _ := shared.VerifyPassword(user.Password, password); !ok {
+		s.recordBasicFailure(email, ip)
+		return nil, errInvalidCredentials
+	}
+
+	_, err = s.DB.ExecContext(context.Background(), "DELETE FROM login_throttles WHERE scope = ? AND identifier = ?", throttleBasicAccount, strings.ToLower(email))
+	if err != nil {
+		slog.Error("Error while clearing failed logins", "err", err)
+	}
+
+	if user.DisabledAt.Valid {
+		return nil, errAccountDisabled
+	}
+	if user.DeletionScheduledAt.Valid {
+		return nil, errAccountPendingDeletion
+	}
+	if user.TotpEnabledAt.Valid {
+		return nil, fiber.NewError(fiber.StatusUnauthorized, "Your account has two-factor authentication, use an API key as the password")
+	}
+
+	basicLogins.put(key, user.Id, shared.GetDuration("BASIC_AUTH_CACHE_TTL", 5*time.Minute))
+
+	return &shared.Principal{UserId: user.Id, Scopes: []string{shared.ScopeRead}}, nil
+}
+
+// basicLogin is a password ResolvePassword checked lately
+type basicLogin struct {
+	userId    int
+	expiresAt time.Time
+}
+
+// basicLoginCache holds the checked passwords by basicLoginKey, never the passwords themselves
+type basicLoginCache struct {
+	mu      sync.Mutex
+	entries map[string]basicLogin
+}
+
+var basicLogins = &basicLoginCache{entries: map[string]basicLogin{}}
+
+// basicLoginKey is the sha256 of the email and password, the email isn't case sensitive
+func basicLoginKey(email string, password string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(email) + "\x00" + password))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *basicLoginCache) get(key string) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return 0, false
+	}
+
+	return entry.userId, true
+}
+
+// put remembers the login, the expired ones are dropped along the way so the map doesn't grow forever
+func (c *basicLoginCache) put(key string, userId int, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for k, entry := range c.entries {
+		if now.After(entry.expiresAt) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = basicLogin{userId: userId, expiresAt: now.Add(ttl)}
+}
+
+// forget drops the user's logins, the old password mustn't keep working once it's changed
+func (c *basicLoginCache) forget(userId int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k, entry := range c.entries {
+		if entry.userId == userId {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// LOGIN THROTTLING
+
+const (
+	throttleAccount = "account"
+	throttleIp      = "ip"
+	// HTTP basic clients like e-readers keep their own counters
+	throttleBasicAccount = "basic_account"
+	throttleBasicIp      = "basic_ip"
+)
+
+// The same answer for an unknown email and a wrong password, so logins can't be used to find accounts
+var errInvalidCredentials = fiber.NewError(fiber.StatusUnauthorized, "Invalid email or password")
+
+var errLoginLocked = fiber.NewError(fiber.StatusTooManyRequests, "Too many failed login attempts, please try again later")
+
+// checkLoginLock refuses the attempt while the account or the ip is locked
+func (s *ProducerService) checkLoginLock(email string, ip string) error {
+	return s.checkThrottleLock(throttleAccount, throttleIp, email, ip)
+}
+
+// checkThrottleLock refuses the attempt while the account or the ip is locked in the given scopes
+func (s *ProducerService) checkThrottleLock(accountScope string, ipScope string, email string, ip string) error {
+	var locked bool
+
+	// Query, a lock on either of them is enough
+	query := `SELECT COUNT(*) > 0 FROM login_throttles
+		WHERE ((scope = ? AND identifier = ?) OR (scope = ? AND identifier = ?)) AND locked_until > NOW()`
+
+	err := s.DB.QueryRowContext(context.Background(), query, accountScope, strings.ToLower(email), ipScope, ip).Scan(&locked)
+	if err != nil {
+		slog.Error("Eror while query", "err", err)
+		return err
+	}
+
+	if locked {
+		return errLoginLocked
+	}
+
+	return nil
+}
+
+// recordLoginFailure bumps both counters, and locks whichever went past its limit.
+// The account owner gets an email the first time their account gets locked.
+func (s *ProducerService) recordLoginFailure(email string, ip string) {
+	email = strings.ToLower(email)
+	maxAccount, maxIp := loginLimits()
+
+	failures, lockedUntil, err := s.bumpLoginThrottle(throttleAccount, email, maxAccount)
+	if err != nil {
+		slog.Error("Error while recording failed login", "scope", throttleAccount, "err", err)
+	}
+
+	// Only the first lock of a streak is worth an email, the next ones would just spam the owner
+	if err == nil && failures == maxAccount {
+		slog.Warn("Account locked after failed logins", "email", email, "ip", ip)
+		s.notifyLockout(email, ip, failures, lockedUntil)
+	}
+
+	_, _, err = s.bumpLoginThrottle(throttleIp, ip, maxIp)
+	if err != nil {
+		slog.Error("Error while recording failed login", "scope", throttleIp, "err", err)
+	}
+}
+
+// recordBasicFailure bumps the HTTP basic counters, with the web login's limits but no email,
+// a misconfigured e-reader would otherwise mail the owner every time it retries
+func (s *ProducerService) recordBasicFailure(email string, ip string) {
+	maxAccount, maxIp := loginLimits()
+
+	_, _, err := s.bumpLoginThrottle(throttleBasicAccount, strings.ToLower(email), maxAccount)
+	if err != nil {
+		slog.Error("Error while recording failed login", "scope", throttleBasicAccount, "err", err)
+	}
+
+	_, _, err = s.bumpLoginThrottle(throttleBasicIp, ip, maxIp)
+	if err != nil {
+		slog.Error("Error while recording failed login", "scope", throttleBasicIp, "err", err)
+	}
+}
+
+// loginLimits are the failures an account and an ip get before they are locked
+func loginLimits() (maxAccount int, maxIp int) {
+	config := shared.NewConfig()
+
+	maxAccount = config.GetInt("LOGIN_MAX_ATTEMPTS")
+	if maxAccount <= 0 {
+		maxAccount = 5
+	}
+	maxIp = config.GetInt("LOGIN_IP_MAX_ATTEMPTS")
+	if maxIp <= 0 {
+		maxIp = 20
+	}
+
+	return maxAccount, maxIp
+}
+
+// bumpLoginThrottle counts one more failure. Past the limit the lock doubles with every failure:
+// LOGIN_LOCKOUT_BASE, twice that, four times... up to LOGIN_LOCKOUT_MAX.
+// The streak starts over after LOGIN_FAILURE_WINDOW without failures.
+func (s *ProducerService) bumpLoginThrottle(scope string, identifier string, limit int) (failures int, lockedUntil time.Time, err error) {
+	window := shared.GetDuration("LOGIN_FAILURE_WINDOW", 24*time.Hour)
+	base := shared.GetDuration("LOGIN_LOCKOUT_BASE", time.Minute)
+	maxLock := shared.GetDuration("LOGIN_LOCKOUT_MAX", time.Hour)
+
+	// tx stuffs
+	tx, err := s.DB.Begin()
+	if err != nil {
+		slog.Error("Commit Rollback error", "err", err)
+		return 0, lockedUntil, err
+	}
+	defer func() {
+		err = shared.CommitOrRollback(tx, err)
+	}()
+
+	query := `INSERT INTO login_throttles (scope, identifier, failures, last_failure_at) VALUES (?, ?, 1, NOW())
+		ON DUPLICATE KEY UPDATE
+			failures = IF(last_failure_at < NOW() - INTERVAL ? SECOND, 1, failures + 1),
+			last_failure_at = NOW()`
+	_, err = tx.ExecContext(context.Background(), query, scope, identifier, int(window.Seconds()))
+	if err != nil {
+		slog.Error("Error while inserting data", "err", err)
+		return 0, lockedUntil, err
+	}
+
+	err = tx.QueryRowContext(context.Background(), "SELECT failures FROM login_throttles WHERE scope = ? AND identifier = ?", scope, identifier).Scan(&failures)
+	if err != nil {
+		slog.Error("Eror while query", "err", err)
+		return 0, lockedUntil, err
+	}
+
+	if failures < limit {
+		return failures, lockedUntil, nil
+	}
+
+	lock := base
+	for i := limit; i < failures && lock < maxLock; i++ {
+		lock *= 2
+	}
+	lock = min(lock, maxLock)
+
+	_, err = tx.ExecContext(context.Background(), "UPDATE login_throttles SET locked_until = NOW() + INTERVAL ? SECOND WHERE scope = ? AND identifier = ?", int(lock.Seconds()), scope, identifier)
+	if err != nil {
+		slog.Error("Error while updating data", "err", err)
+		return 0, lockedUntil, err
+	}
+
+	return failures, time.Now().Add(lock), nil
+}
+
+// clearLoginFailures forgets the account's streak after a successful login.
+// The ip counter is left alone, otherwise an attacker could reset it by logging into their own account in between.
+func (s *ProducerService) clearLoginFailures(email string) {
+	_, err := s.DB.ExecContext(context.Background(), "DELETE FROM login_throttles WHERE scope = ? AND identifier = ?", throttleAccount, strings.ToLower(email))
+	if err != nil {
+		slog.Error("Error while clearing failed logins", "err", err)
+	}
+}
+
+func (s *ProducerService) notifyLockout(email string, ip string, failures int, lockedUntil time.Time) {
+	// Somebody guessing random emails doesn't need to know whether they exist, and there's nobody to notify
+	user, err := s.GetUser(email)
+	if err != nil {
+		return
+	}
+
+	msg := &shared.LockoutMsg{
+		Recipient:   user.Recipient(),
+		Email:       user.Email,
+		IpAddress:   ip,
+		Failures:    failures,
+		LockedUntil: lockedUntil,
+	}
+
+	if err := s.publishAccountMessage("lockout", msg); err != nil {
+		slog.Error("Error while sending lockout email", "err", err)
+	}
+}
+
+// ADMIN
+
+const (
+	roleUser  = "user"
+	roleAdmin = "admin"
+)
+
+var errAccountDisabled = fiber.NewError(fiber.StatusForbidden, "This account has been disabled")
+
+// PromoteAdmins gives the admin role to the ADMIN_EMAILS accounts, so the very first admin doesn't need a manual query
+func (s *ProducerService) PromoteAdmins(emails []string) error {
+	for _, email := range emails {
+		email = strings.TrimSpace(email)
+		if email == "" {
+			continue
+		}
+
+		result, err := s.DB.ExecContext(context.Background(), "UPDATE users SET role = ? WHERE email = ?", roleAdmin, email)
+		if err != nil {
+			slog.Error("Error while updating data", "err", err)
+			return err
+		}
+
+		// The account may simply not be registered yet, it gets promoted on the next start
+		if rowsAffected, err := result.RowsAffected(); err == nil && rowsAffected > 0 {
+			slog.Info("Promoted user to admin", "email", email)
+		}
+	}
+
+	return nil
+}
+
+// IsAdmin reads the role from the database on every call, so a demotion takes effect right away
+func (s *ProducerService) IsAdmin(userId int) (bool, error) {
+	var role string
+	var disabled bool
+
+	query := "SELECT role, disabled_at IS NOT NULL FROM users WHERE id = ?"
+	err := s.DB.QueryRowContext(context.Background(), query, userId).Scan(&role, &disabled)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		slog.Error("Eror while query", "err", err)
+		return false, err
+	}
+
+	return role == roleAdmin && !disabled, nil
+}
+
+const adminUserColumns = `SELECT u.id, u.email, u.role, u.verified_at, u.totp_enabled_at IS NOT NULL, u.disabled_at, u.deletion_scheduled_at, u.created_at,
+	(SELECT COUNT(*) FROM books b WHERE b.user_id = u.id),
+	(SELECT COUNT(*) FROM goals g WHERE g.user_id = u.id)
+	FROM users u`
+
+func scanAdminUser(row interface{ Scan(...any) error }) (*ResponseAdminUser, error) {
+	var user ResponseAdminUser
+	var verifiedAt, disabledAt sql.NullTime
+
+	err := row.Scan(
+		&user.Id,
+		&user.Email,
+		&user.Role,
+		&verifiedAt,
+		&user.TwoFactorEnabled,
+		&disabledAt,
+		&user.DeletionScheduledAt,
+		&user.CreatedAt,
+		&user.Books,
+		&user.Goals,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if verifiedAt.Valid {
+		user.VerifiedAt = &verifiedAt.Time
+	}
+	if disabledAt.Valid {
+		user.DisabledAt = &disabledAt.Time
+	}
+
+	return &user, nil
+}
+
+// AdminGetUsers lists the users, optionally only those whose email contains search
+func (s *ProducerService) AdminGetUsers(req RequestAdminGetUsers) ([]*ResponseAdminUser, error) {
+	// Initialize var to place the users
+	users := []*ResponseAdminUser{}
+
+	// Build the query
+	query := adminUserColumns
+	args := []any{}
+	if req.Search != "" {
+		query += " WHERE u.email LIKE ?"
+		args = append(args, "%"+escapeLike(req.Search)+"%")
+	}
+	if req.Role != "" {
+		if len(args) == 0 {
+			query += " WHERE u.role = ?"
+		} else {
+			query += " AND u.role = ?"
+		}
+		args = append(args, req.Role)
+	}
+
+	limit := req.Limit
+	if limit == 0 {
+		limit = 50
+	}
+	query += " ORDER BY u.id LIMIT ? OFFSET ?"
+	args = append(args, limit, req.Offset)
+
+	rows, err := s.DB.QueryContext(context.Background(), query, args...)
+	if err != nil {
+		slog.Error("Eror while query", "err", err)
+		return nil, err
+	}
+
+	// close the rows of course
+	defer rows.Close()
+
+	// Foreach-ing queried rows
+	for rows.Next() {
+		user, err := scanAdminUser(rows)
+		if err != nil {
+			slog.Error("Error querying", "err", err)
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+func (s *ProducerService) AdminGetUser(userId int) (*ResponseAdminUser, error) {
+	user, err := scanAdminUser(s.DB.QueryRowContext(context.Background(), adminUserColumns+" WHERE u.id = ?", userId))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fiber.NewError(fiber.StatusNotFound, "User not found")
+		}
+		slog.Error("Eror while query", "err", err)
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// SetUserDisabled disables or re-enables an account.
+// Disabling also kills every session and API key, so the user is out within one access token lifetime.
+func (s *ProducerService) SetUserDisabled(userId int, adminId int, disabled bool) (err error) {
+	if userId == adminId {
+		return fiber.NewError(fiber.StatusBadRequest, "You can't disable your own account")
+	}
+
+	// tx stuffs
+	tx, err := s.DB.Begin()
+	if err != nil {
+		slog.Error("Commit Rollback error", "err", err)
+		return err
+	}
+	defer func() {
+		err = shared.CommitOrRollback(tx, err)
+	}()
+
+	query := "UPDATE users SET disabled_at = NOW() WHERE id = ? AND disabled_at IS NULL"
+	if !disabled {
+		query = "UPDATE users SET disabled_at = NULL WHERE id = ? AND disabled_at IS NOT NULL"
+	}
+
+	result, err := tx.ExecContext(context.Background(), query, userId)
+	if err != nil {
+		slog.Error("Error while updating data", "err", err)
+		return err
+	}
+
+	// checks the affected row to make sure the user exists and wasn't in that state already
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		if disabled {
+			return fiber.NewError(fiber.StatusBadRequest, "User does not exist or is already disabled")
+		}
+		return fiber.NewError(fiber.StatusBadRequest, "User does not exist or is not disabled")
+	}
+	basicLogins.forget(userId)
+
+	if !disabled {
+		return nil
+	}
+
+	err = revokeAllSessions(tx, userId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(context.Background(), "UPDATE api_keys SET revoked_at = NOW() WHERE user_id = ? AND revoked_at IS NULL", userId)
+	if err != nil {
+		slog.Error("Error while revoking API keys", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *ProducerService) SetUserRole(userId int, adminId int, role string) error {
+	if userId == adminId && role != roleAdmin {
+		return fiber.NewError(fiber.StatusBadRequest, "You can't take away your own admin role")
+	}
+
+	result, err := s.DB.ExecContext(context.Background(), "UPDATE users SET role = ? WHERE id = ?", role, userId)
+	if err != nil {
+		slog.Error("Error while updating data", "err", err)
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		// MySQL reports 0 rows when the value didn't change, so make sure the user is actually missing
+		if _, err := s.AdminGetUser(userId); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// recipientColumns are the shared.Recipient fields, for queries that join users as u
+const recipientColumns = "COALESCE(u.display_name, ''), u.timezone, u.locale, u.date_format"
+
+// getGoalMessage builds the same message CreateGoal publishes, straight from the database
+func (s *ProducerService) getGoalMessage(goalId int) (*shared.Msg, string, error) {
+	var msg shared.Msg
+	var status string
+	var unit string
+	var thumbnailKey sql.NullString
+
+	query := `SELECT g.id, u.email, g.name, b.title, g.target_page, g.expired_at, g.status, b.unit, b.thumbnail_key, ` + recipientColumns + `
+		FROM goals g JOIN books b ON b.id = g.book_id JOIN users u ON u.id = g.user_id
+		WHERE g.id = ?`
+
+	err := s.DB.QueryRowContext(context.Background(), query, goalId).Scan(
+		&msg.Id,
+		&msg.Email,
+		&msg.Name,
+		&msg.BookTitle,
+		&msg.TargetPage,
+		&msg.ExpiredAt,
+		&status,
+		&unit,
+		&thumbnailKey,
+		&msg.DisplayName,
+		&msg.Timezone,
+		&msg.Locale,
+		&msg.DateFormat,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, "", fiber.NewError(fiber.StatusNotFound, "Goal not found")
+		}
+		slog.Error("Eror while query", "err", err)
+		return nil, "", err
+	}
+
+	msg.Target = formatPosition(unit, msg.TargetPage)
+	msg.CoverUrl = coverURL(thumbnailKey)
+
+	return &msg, status, nil
+}
+
+// ExpireGoal expires an in-progress goal now instead of waiting for its deadline.
+// The consumer does the actual expiring and mails the owner, same as a deadline that passed.
+func (s *ProducerService) ExpireGoal(goalId int) error {
+	msg, status, err := s.getGoalMessage(goalId)
+	if err != nil {
+		return err
+	}
+
+	if status != "in-progress" {
+		return fiber.NewError(fiber.StatusBadRequest, "Only in-progress goals can be expired")
+	}
+
+	return s.sendDeadlineMessage(msg, 0, nil)
+}
+
+// RetriggerGoalNotification sends the goal's notification again, e.g. after the mail server was down.
+// Finished goals get their congratulation again, expired ones their deadline email,
+// cancelled ones their cancellation email, and in-progress goals get their deadline message rescheduled.
+func (s *ProducerService) RetriggerGoalNotification(goalId int) error {
+	msg, status, err := s.getGoalMessage(goalId)
+	if err != nil {
+		return err
+	}
+
+	switch status {
+	case "finished":
+		body, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		return s.sendGoalMessage(body)
+	case "expired":
+		return s.sendDeadlineMessage(msg, 0, amqp091.Table{shared.HeaderRetrigger: true})
+	case "cancelled":
+		return s.sendCancelledMessage(msg)
+	default:
+		// An extra deadline message is harmless, the consumer only acts on the first one
+		return s.sendDeadlineMessage(msg, time.Until(msg.ExpiredAt), nil)
+	}
+}
+
+// escapeLike keeps user input from acting as LIKE wildcards
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// ACCOUNT
+
+var errAccountPendingDeletion = fiber.NewError(fiber.StatusForbidden, "This account is scheduled for deletion, restore it through /api/account/restore to log in again")
+
+func getAccountDeletionGrace() time.Duration {
+	return shared.GetDuration("ACCOUNT_DELETION_GRACE", 30*24*time.Hour)
+}
+
+func (s *ProducerService) GetProfile(userId int) (*ResponseProfile, error) {
+	var profile ResponseProfile
+
+	query := `SELECT id, email, display_name, timezone, locale, date_format, verified_at, totp_enabled_at IS NOT NULL, created_at
+		FROM users WHERE id = ?`
+	err := s.DB.QueryRowContext(context.Background(), query, userId).Scan(
+		&profile.Id,
+		&profile.Email,
+		&profile.DisplayName,
+		&profile.Timezone,
+		&profile.Locale,
+		&profile.DateFormat,
+		&profile.VerifiedAt,
+		&profile.TwoFactorEnabled,
+		&profile.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fiber.NewError(fiber.StatusNotFound, "User not found")
+		}
+		slog.Error("Eror while query", "err", err)
+		return nil, err
+	}
+
+	// Shown in the user's own timezone like everything else
+	loc := shared.LoadLocation(profile.Timezone)
+	profile.CreatedAt = profile.CreatedAt.In(loc)
+	if profile.VerifiedAt != nil {
+		verifiedAt := profile.VerifiedAt.In(loc)
+		profile.VerifiedAt = &verifiedAt
+	}
+
+	return &profile, nil
+}
+
+// UpdateProfile only touches the fields present in the request
+func (s *ProducerService) UpdateProfile(userId int, req RequestUpdateProfile) (*ResponseProfile, error) {
+	var sets []string
+	var args []any
+
+	if req.DisplayName != nil {
+		// An empty name goes back to being addressed by email
+		var displayName any
+		if name := strings.TrimSpace(*req.DisplayName); name != "" {
+			displayName = name
+		}
+		sets = append(sets, "display_name = ?")
+		args = append(args, displayName)
+	}
+	if req.Timezone != nil {
+		if _, err := time.LoadLocation(*req.Timezone); err != nil || *req.Timezone == "" || *req.Timezone == "Local" {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "Unknown timezone, use an IANA name like Asia/Jakarta")
+		}
+		sets = append(sets, "timezone = ?")
+		args = append(args, *req.Timezone)
+	}
+	if req.Locale != nil {
+		if _, ok := shared.Locales[*req.Locale]; !ok {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "Unsupported locale")
+		}
+		sets = append(sets, "locale = ?")
+		args = append(args, *req.Locale)
+	}
+	if req.DateFormat != nil {
+		if _, ok := shared.DateFormats[*req.DateFormat]; !ok {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "Unknown date format, pick one of iso, us, eu or long")
+		}
+		sets = append(sets, "date_format = ?")
+		args = append(args, *req.DateFormat)
+	}
+
+	if len(sets) > 0 {
+		query := "UPDATE users SET " + strings.Join(sets, ", ") + " WHERE id = ?"
+		_, err := s.DB.ExecContext(context.Background(), query, append(args, userId)...)
+		if err != nil {
+			slog.Error("Error while updating data", "err", err)
+			return nil, err
+		}
+	}
+
+	return s.GetProfile(userId)
+}
+
+// getUserLocation is the timezone API responses are shown in
+func (s *ProducerService) getUserLocation(userId int) *time.Location {
+	var timezone string
+
+	err := s.DB.QueryRowContext(context.Background(), "SELECT timezone FROM users WHERE id = ?", userId).Scan(&timezone)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			slog.Error("Eror while query", "err", err)
+		}
+		return time.UTC
+	}
+
+	return shared.LoadLocation(timezone)
+}
+
+// ScheduleAccountDeletion marks the account for deletion after the grace period and logs the user out everywhere.
+// Nothing is deleted yet, the purge job removes the account once the grace period is over.
+func (s *ProducerService) ScheduleAccountDeletion(userId int, req RequestDeleteAccount) (deleteAt time.Time, err error) {
+	user, err := s.GetUser(strconv.Itoa(userId))
+	if err != nil {
+		return deleteAt, err
+	}
+
+	ok, _ := shared.VerifyPassword(user.Password, req.Password)
+	if !ok {
+		return deleteAt, fiber.NewError(fiber.StatusBadRequest, "Wrong password")
+	}
+
+	// With 2FA on, a password alone isn't enough to throw the whole account away
+	if user.TotpEnabledAt.Valid {
+		if req.Code == "" {
+			return deleteAt, fiber.NewError(fiber.StatusBadRequest, "Two-factor code required")
+		}
+		err = s.checkTotpCode(user, req.Code)
+		if err != nil {
+			return deleteAt, err
+		}
+	}
+
+	// tx stuffs
+	tx, err := s.DB.Begin()
+	if err != nil {
+		slog.Error("Commit Rollback error", "err", err)
+		return deleteAt, err
+	}
+	defer func() {
+		err = shared.CommitOrRollback(tx, err)
+	}()
+
+	grace := getAccountDeletionGrace()
+	deleteAt = time.Now().Add(grace)
+	query := "UPDATE users SET deletion_scheduled_at = NOW() + INTERVAL ? SECOND WHERE id = ? AND deletion_scheduled_at IS NULL"
+	result, err := tx.ExecContext(context.Background(), query, int(grace.Seconds()), userId)
+	if err != nil {
+		slog.Error("Error while updating data", "err", err)
+		return deleteAt, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return deleteAt, err
+	}
+	if rowsAffected == 0 {
+		return deleteAt, fiber.NewError(fiber.StatusBadRequest, "Account is already scheduled for deletion")
+	}
+	basicLogins.forget(userId)
+
+	err = revokeAllSessions(tx, userId)
+	if err != nil {
+		return deleteAt, err
+	}
+
+	_, err = tx.ExecContext(context.Background(), "UPDATE api_keys SET revoked_at = NOW() WHERE user_id = ? AND revoked_at IS NULL", userId)
+	if err != nil {
+		slog.Error("Error while revoking API keys", "err", err)
+		return deleteAt, err
+	}
+
+	return deleteAt, nil
+}
+
+// RestoreAccount cancels a scheduled deletion, it takes the same credentials a login does
+func (s *ProducerService) RestoreAccount(req RequestAuthUser, ip string) (int, error) {
+	user, err := s.authenticate(req, ip)
+	if err != nil {
+		return 0, err
+	}
+
+	if user.DisabledAt.Valid {
+		return 0, errAccountDisabled
+	}
+	if !user.DeletionScheduledAt.Valid {
+		return 0, fiber.NewError(fiber.StatusBadRequest, "This account is not scheduled for deletion")
+	}
+
+	_, err = s.DB.ExecContext(context.Background(), "UPDATE users SET deletion_scheduled_at = NULL WHERE id = ?", user.Id)
+	if err != nil {
+		slog.Error("Error while updating data", "err", err)
+		return 0, err
+	}
+
+	// The consumer dropped every deadline that came due during the grace period, send those again
+	err = s.rescheduleGoalDeadlines(user.Id)
+	if err != nil {
+		slog.Error("Error while rescheduling goal deadlines", "user_id", user.Id, "err", err)
+	}
+
+	return user.Id, nil
+}
+
+func (s *ProducerService) rescheduleGoalDeadlines(userId int) error {
+	query := `SELECT g.id, u.email, g.name, b.title, g.target_page, g.expired_at, ` + recipientColumns + `
+		FROM goals g JOIN books b ON b.id = g.book_id JOIN users u ON u.id = g.user_id
+		WHERE g.user_id = ? AND g.status = 'in-progress'`
+
+	rows, err := s.DB.QueryContext(context.Background(), query, userId)
+	if err != nil {
+		slog.Error("Eror while query", "err", err)
+		return err
+	}
+
+	// close the rows of course
+	defer rows.Close()
+
+	var msgs []*shared.Msg
+	for rows.Next() {
+		var msg shared.Msg
+		err := rows.Scan(&msg.Id, &msg.Email, &msg.Name, &msg.BookTitle, &msg.TargetPage, &msg.ExpiredAt,
+			&msg.DisplayName, &msg.Timezone, &msg.Locale, &msg.DateFormat)
+		if err != nil {
+			slog.Error("Error querying", "err", err)
+			return err
+		}
+		msgs = append(msgs, &msg)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Deadlines that are still ahead get a second message, the consumer drops whichever comes last
+	for _, msg := range msgs {
+		err = s.sendDeadlineMessage(msg, time.Until(msg.ExpiredAt), nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PurgeDeletedAccounts hard deletes the accounts whose grace period is over.
+// Everything the user owns goes with the users row through ON DELETE CASCADE.
+func (s *ProducerService) PurgeDeletedAccounts() (int, error) {
+	rows, err := s.DB.QueryContext(context.Background(), "SELECT id, email FROM users WHERE deletion_scheduled_at <= NOW()")
+	if err != nil {
+		slog.Error("Eror while query", "err", err)
+		return 0, err
+	}
+
+	type doomedUser struct {
+		id    int
+		email string
+	}
+	var users []doomedUser
+	for rows.Next() {
+		var user doomedUser
+		if err := rows.Scan(&user.id, &user.email); err != nil {
+			rows.Close()
+			slog.Error("Error querying", "err", err)
+			return 0, err
+		}
+		users = append(users, user)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, user := range users {
+		// The cascade takes the books rows but not the blobs they point at, so those are gathered first
+		blobKeys, err := s.userBlobKeys(user.id)
+		if err != nil {
+			return purged, err
+		}
+
+		// Checked again in case the user restored the account in the meantime
+		result, err := s.DB.ExecContext(context.Background(), "DELETE FROM users WHERE id = ? AND deletion_scheduled_at <= NOW()", user.id)
+		if err != nil {
+			slog.Error("Error while deleting data", "user_id", user.id, "err", err)
+			return purged, err
+		}
+		if rowsAffected, err := result.RowsAffected(); err != nil || rowsAffected == 0 {
+			continue
+		}
+		s.deleteBlobs(blobKeys...)
+
+		// The throttle rows are keyed by email, not by user, so the cascade doesn't reach them
+		query := "DELETE FROM login_throttles WHERE scope IN (?, ?) AND identifier = ?"
+		_, err = s.DB.ExecContext(context.Background(), query, throttleAccount, throttleBasicAccount, user.email)
+		if err != nil {
+			slog.Error("Error while deleting data", "err", err)
+		}
+
+		purged++
+	}
+
+	return purged, nil
+}
+
+// userBlobKeys are the keys of every cover, thumbnail and book file the user's books have in the blob store
+func (s *ProducerService) userBlobKeys(userId int) ([]string, error) {
+	query := "SELECT cover_key, thumbnail_key, file_key FROM books WHERE user_id = ?"
+	rows, err := s.DB.QueryContext(context.Background(), query, userId)
+	if err != nil {
+		slog.Error("Eror while query", "err", err)
+		return nil, err
+	}
+	// close the rows of course
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var coverKey, thumbnailKey, fileKey sql.NullString
+		if err := rows.Scan(&coverKey, &thumbnailKey, &fileKey); err != nil {
+			slog.Error("Error querying", "err", err)
+			return nil, err
+		}
+		keys = append(keys, coverKey.String, thumbnailKey.String, fileKey.String)
+	}
+
+	return keys, rows.Err()
+}
+
+// StartAccountPurge runs PurgeDeletedAccounts every ACCOUNT_PURGE_INTERVAL until ctx is done
+func (s *ProducerService) StartAccountPurge(ctx context.Context) {
+	interval := shared.GetDuration("ACCOUNT_PURGE_INTERVAL", time.Hour)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			purged, err := s.PurgeDeletedAccounts()
+			if err != nil {
+				slog.Error("Failed to purge deleted accounts", "err", err)
+			} else if purged > 0 {
+				slog.Info("Purged deleted accounts", "count", purged)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// ExportAccount writes everything Hon keeps about the user into the archive, one entry per kind of data.
+// Rows are written as they are read, heavy readers don't end up with their whole history in memory.
+func (s *ProducerService) ExportAccount(userId int, archive accountArchive) (err error) {
+	// A read only tx, so every entry sees the same snapshot
+	tx, err := s.DB.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		slog.Error("Commit Rollback error", "err", err)
+		return err
+	}
+	defer func() {
+		err = shared.CommitOrRollback(tx, err)
+	}()
+
+	var account ExportAccount
+	query := "SELECT id, email, role, verified_at, totp_enabled_at IS NOT NULL, created_at, deletion_scheduled_at FROM users WHERE id = ?"
+	err = tx.QueryRowContext(context.Background(), query, userId).Scan(
+		&account.Id,
+		&account.Email,
+		&account.Role,
+		&account.VerifiedAt,
+		&account.TwoFactorEnabled,
+		&account.CreatedAt,
+		&account.DeletionScheduledAt,
+	)
+	if err != nil {
+		slog.Error("Eror while query", "err", err)
+		return err
+	}
+
+	w, err := archive.Entry("account")
+	if err != nil {
+		return err
+	}
+	if err = json.NewEncoder(w).Encode(account); err != nil {
+		return err
+	}
+
+	query = "SELECT id, title, author, isbn, total_pages, format, unit, status FROM books WHERE user_id = ? ORDER BY id"
+	err = exportRows(tx, archive, "books", query, userId, func(book *ExportBook) []any {
+		return []any{&book.Id, &book.Title, &book.Author, &book.Isbn, &book.TotalPages, &book.Format, &book.Unit, &book.Status}
+	})
+	if err != nil {
+		return err
+	}
+
+	query = `SELECT r.id, r.book_id, r.number, r.started_at, r.finished_at
+		FROM reading_runs r JOIN books b ON b.id = r.book_id WHERE b.user_id = ? ORDER BY r.id`
+	err = exportRows(tx, archive, "reading_runs", query, userId, func(run *ExportReadingRun) []any {
+		return []any{&run.Id, &run.BookId, &run.Number, &run.StartedAt, &run.FinishedAt}
+	})
+	if err != nil {
+		return err
+	}
+
+	query = `SELECT p.id, p.book_id, p.run_id, p.from_page, p.until_page, p.description, p.created_at
+		FROM progresses p JOIN books b ON b.id = p.book_id WHERE b.user_id = ? ORDER BY p.id`
+	err = exportRows(tx, archive, "progresses", query, userId, func(progress *ExportProgress) []any {
+		return []any{&progress.Id, &progress.BookId, &progress.RunId, &progress.FromPage, &progress.UntilPage, &progress.Description, &progress.CreatedAt}
+	})
+	if err != nil {
+		return err
+	}
+
+	query = "SELECT id, book_id, run_id, name, target_page, status, expired_at FROM goals WHERE user_id = ? ORDER BY id"
+	err = exportRows(tx, archive, "goals", query, userId, func(goal *ExportGoal) []any {
+		return []any{&goal.Id, &goal.BookId, &goal.RunId, &goal.Name, &goal.TargetPage, &goal.Status, &goal.ExpiredAt}
+	})
+	if err != nil {
+		return err
+	}
+
+	query = `SELECT bt.book_id, t.name FROM book_tags bt JOIN tags t ON t.id = bt.tag_id
+		WHERE t.user_id = ? ORDER BY bt.book_id, t.name`
+	err = exportRows(tx, archive, "book_tags", query, userId, func(tag *ExportBookTag) []any {
+		return []any{&tag.BookId, &tag.Tag}
+	})
+	if err != nil {
+		return err
+	}
+
+	query = "SELECT id, user_agent, ip_address, created_at, last_used_at, revoked_at FROM sessions WHERE user_id = ? ORDER BY id"
+	err = exportRows(tx, archive, "sessions", query, userId, func(session *ExportSession) []any {
+		return []any{&session.Id, &session.UserAgent, &session.IpAddress, &session.CreatedAt, &session.LastUsedAt, &session.RevokedAt}
+	})
+	if err != nil {
+		return err
+	}
+
+	query = "SELECT id, name, prefix, read_only, created_at, last_used_at, expires_at, revoked_at FROM api_keys WHERE user_id = ? ORDER BY id"
+	err = exportRows(tx, archive, "api_keys", query, userId, func(key *ExportAPIKey) []any {
+		return []any{&key.Id, &key.Name, &key.Prefix, &key.ReadOnly, &key.CreatedAt, &key.LastUsedAt, &key.ExpiresAt, &key.RevokedAt}
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// BOOKS
+
+func (s *ProducerService) CreateBook(userId int, req RequestCreateBook) (int, error) {
+	// Authors given one by one win over the author text
+	if len(req.Authors) > 0 {
+		req.Authors = shared.CleanAuthors(req.Authors)
+		req.Author = shared.JoinAuthors(req.Authors)
+	}
+	if req.SeriesPosition != nil && strings.TrimSpace(req.Series) == "" {
+		return 0, errNoSeries
+	}
+
+	// An ISBN fills in what was left out, when the provider is slow the consumer does it later
+	pending, err := s.fillFromIsbn(&req)
+	if err != nil {
+		return 0, err
+	}
+
+	bookId, err := s.insertBook(userId, req, pending)
+	if err != nil {
+		return 0, err
+	}
+
+	if pending {
+		err = s.publishMetadataMessage(&shared.MetadataMsg{BookId: bookId, ISBN: req.Isbn})
+		if err != nil {
+			// The book is there already, only its details have to be filled in by hand now
+			slog.Error("Error while queueing metadata fetch", "book_id", bookId, "err", err)
+			_, err = s.DB.ExecContext(context.Background(), "UPDATE books SET metadata_pending = FALSE WHERE id = ?", bookId)
+			if err != nil {
+				slog.Error("Error while updating data", "err", err)
+			}
+		}
+	}
+
+	return bookId, nil
+}
+
+// insertBook saves the book with its first run, pending books may not know their length yet
+func (s *ProducerService) insertBook(userId int, req RequestCreateBook, pending bool) (id int, err error) {
+	// A book can go on the list before it gets started
+	status := req.Status
+	if status == "" {
+		status = "reading"
+	}
+
+	// The length is counted in the book's unit, percent books are always 100 long
+	format, unit, err := resolveUnit(req.Format, req.Unit)
+	if err != nil {
+		return 0, err
+	}
+	totalPages := percentTotal
+	if unit != "percent" {
+		totalPages, err = resolvePosition(unit, req.Length, req.TotalPages)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if totalPages <= 0 && !pending {
+		return 0, fiber.NewError(fiber.StatusBadRequest, "The total pages or length field is required")
+	}
+
+	// tx stuffs
+	tx, err := s.DB.Begin()
+	if err != nil {
+		slog.Error("Commit Rollback error", "err", err)
+		return 0, err
+	}
+	defer func() {
+		err = shared.CommitOrRollback(tx, err)
+	}()
+
+	bookId, _, err := shared.InsertBook(context.Background(), tx, userId, shared.NewBook{
+		Title:           req.Title,
+		Author:          req.Author,
+		Isbn:            req.Isbn,
+		MetadataPending: pending,
+		TotalPages:      totalPages,
+		Format:          format,
+		Unit:            unit,
+		Status:          status,
+	})
+	if err != nil {
+		slog.Error("Error while inserting data", "err", err)
+		return 0, err
+	}
+
+	// Tags given on creation go in the same transaction, so a book never ends up half-tagged
+	if len(req.Tags) > 0 {
+		err = setBookTags(tx, userId, bookId, req.Tags)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// The placeholder author isn't anybody, the consumer credits the real ones once it knows them
+	authors := req.Authors
+	if len(authors) == 0 {
+		authors = shared.SplitAuthors(req.Author)
+	}
+	if !(pending && len(req.Authors) == 0 && req.Author == shared.PlaceholderAuthor) {
+		err = shared.SetBookAuthors(context.Background(), tx, userId, bookId, authors)
+		if err != nil {
+			slog.Error("Error while crediting authors", "err", err)
+			return 0, err
+		}
+	}
+
+	if strings.TrimSpace(req.Series) != "" {
+		err = setBookSeries(tx, userId, bookId, req.Series, req.SeriesPosition)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return bookId, nil
+}
+
+// fillFromIsbn normalises the ISBN and fills in title, author and total pages from the metadata provider.
+// It returns true when the provider was too slow, the book then goes in with placeholders for the consumer to replace.
+func (s *ProducerService) fillFromIsbn(req *RequestCreateBook) (bool, error) {
+	if req.Isbn == "" {
+		return false, nil
+	}
+
+	isbn, err := shared.NormalizeISBN(req.Isbn)
+	if err != nil {
+		return false, fiber.NewError(fiber.StatusBadRequest, "The isbn is not a valid ISBN-10 or ISBN-13")
+	}
+	req.Isbn = isbn
+
+	// Page counts are the only length providers know
+	_, unit, _ := resolveUnit(req.Format, req.Unit)
+	needsPages := unit == "pages" && req.TotalPages == 0 && req.Length == ""
+	if req.Title != "" && req.Author != "" && !needsPages {
+		return false, nil
+	}
+
+	provider := shared.GetMetadataProvider()
+	if provider == nil {
+		return false, fiber.NewError(fiber.StatusBadRequest, "Looking books up by ISBN is turned off, fill in the title, author and total pages")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shared.GetDuration("METADATA_TIMEOUT", 2*time.Second))
+	defer cancel()
+
+	meta, err := shared.LookupMetadata(ctx, s.DB, provider, isbn)
+	if errors.Is(err, shared.ErrMetadataNotFound) {
+		return false, fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("No book found for ISBN %s, fill in the title, author and total pages", isbn))
+	}
+	if err != nil {
+		slog.Warn("Metadata lookup failed, fetching it in the background", "isbn", isbn, "err", err)
+		if req.Title == "" {
+			req.Title = shared.PlaceholderTitle(isbn)
+		}
+		if req.Author == "" {
+			req.Author = shared.PlaceholderAuthor
+		}
+		return true, nil
+	}
+
+	if needsPages && meta.Pages == 0 {
+		return false, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("The page count of ISBN %s is unknown, fill in the total pages", isbn))
+	}
+
+	if req.Title == "" {
+		req.Title = meta.Title
+	}
+	if req.Author == "" {
+		req.Author = meta.Author
+	}
+	if needsPages {
+		req.TotalPages = meta.Pages
+	}
+
+	return false, nil
+}
+
+// publishMetadataMessage hands a slow ISBN lookup over to the consumer
+func (s *ProducerService) publishMetadataMessage(msg *shared.MetadataMsg) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	// Make agent
+	agent, err := shared.NewAgent(s.AMQP, context.Background())
+	if err != nil {
+		return err
+	}
+	defer agent.Channel.Close()
+
+	// Make agent work! Publish a message
+	return agent.Publish(amqp091.Publishing{
+		DeliveryMode: amqp091.Persistent,
+		ContentType:  "application/json",
+		Body:         body,
+	}, "metadata_exchange", "metadata")
+}
+
+// bookSorts maps the sort parameter to the column books are ordered by
+var bookSorts = map[string]string{
+	"added":       "id",
+	"title":       "COALESCE(title, '')",
+	"author":      "COALESCE(author, '')",
+	"total_pages": "COALESCE(total_pages, 0)",
+	"series":      "COALESCE(series_position, 0)",
+}
+
+func (s *ProducerService) GetAllBooksByUserId(userId int, req RequestListBooks) ([]*ResponseGetBooks, *string, error) {
+	// Initialize var to place the book
+	var books []*ResponseGetBooks
+
+	sort := req.Sort
+	if sort == "" {
+		sort = "added"
+	}
+	desc := req.Order == "desc"
+	limit := pageSize(req.Limit)
+
+	cursor, err := decodeCursor(req.Cursor, sort, desc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Filters
+	q := &listQuery{}
+	q.where("user_id = ?", userId)
+	if req.Status != "" {
+		q.where("status = ?", req.Status)
+	}
+	if req.Author != "" {
+		q.where("author LIKE ?", "%"+escapeLike(req.Author)+"%")
+	}
+	if req.Format != "" {
+		q.where("format = ?", req.Format)
+	}
+	if tags := parseTagList(req.Tags); len(tags) > 0 {
+		clause, tagArgs := taggedWith("id", userId, tags)
+		q.where(clause, tagArgs...)
+	}
+	if req.AuthorId != 0 {
+		q.where("id IN (SELECT book_id FROM book_authors WHERE author_id = ?)", req.AuthorId)
+	}
+	if req.SeriesId != 0 {
+		q.where("series_id = ?", req.SeriesId)
+	}
+
+	// Query
+	query, args := q.build("SELECT id, title, author, isbn, metadata_pending, cover_key, thumbnail_key, file_key, file_type, total_pages, format, unit, status, "+bookSeriesColumns+" FROM books",
+		keyset{column: bookSorts[sort], idColumn: "id", desc: desc}, cursor, limit)
+
+	// tx stuffs
+	tx, err := s.DB.Begin()
+	defer shared.CommitOrRollback(tx, err)
+	if err != nil {
+		slog.Error("Commit Rollback error", "err", err)
+		return nil, nil, err
+	}
+
+	// Query
+	rows, err := tx.QueryContext(context.Background(), query, args...)
+	if err != nil {
+		slog.Error("Eror while query", "err", err)
+		return nil, nil, err
+	}
+
+	// close the rows of course
+	defer rows.Close()
+
+	// Foreach-ing queried rows
+	for rows.Next() {
+		var book ResponseGetBooks
+		var coverKey, thumbnailKey, fileKey sql.NullString
+		var series bookSeries
+		err := rows.Scan(&book.Id, &book.Title, &book.Author, &book.Isbn, &book.MetadataPending, &coverKey, &thumbnailKey, &fileKey, &book.FileType, &book.TotalPages, &book.Format, &book.Unit, &book.Status,
+			&series.id, &series.name, &series.position)
+		if err != nil {
+			slog.Error("Error querying", "err", err)
+			return nil, nil, err
+		}
+		book.Series = series.response()
+		book.FileUrl = bookFileURL(book.Id, fileKey)
+		book.CoverUrl = coverURL(coverKey)
+		book.ThumbnailUrl = coverURL(thumbnailKey)
+		book.Length = formatPosition(book.Unit, book.TotalPages)
+		books = append(books, &book)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	books, next := nextCursor(books, limit, sort, desc, func(book *ResponseGetBooks) (string, int) {
+		switch sort {
+		case "title":
+			return book.Title, book.Id
+		case "author":
+			return book.Author, book.Id
+		case "total_pages":
+			return strconv.Itoa(book.TotalPages), book.Id
+		case "series":
+			if book.Series == nil || book.Series.Position == nil {
+				return "0", book.Id
+			}
+			return strconv.FormatFloat(*book.Series.Position, 'f', 2, 64), book.Id
+		}
+		return strconv.Itoa(book.Id), book.Id
+	})
+
+	// Tags and authors are loaded for the whole page at once instead of one query per book
+	bookIds := make([]int, len(books))
+	for i, book := range books {
+		bookIds[i] = book.Id
+	}
+	tags, err := s.GetBookTags(bookIds)
+	if err != nil {
+		return nil, nil, err
+	}
+	authors, err := s.GetBookAuthors(bookIds)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, book := range books {
+		book.Tags = tags[book.Id]
+		if book.Tags == nil {
+			book.Tags = []string{}
+		}
+		book.Authors = authors[book.Id]
+		if book.Authors == nil {
+			book.Authors = []*ResponseBookAuthor{}
+		}
+	}
+
+	return books, next, nil
+}
+
+func (s *ProducerService) GetBookById(bookId int, userId int) (*ResponseGetBook, error) {
+	// init some vars
+	var book ResponseGetBook
+	var coverKey, thumbnailKey, fileKey sql.NullString
+	var series bookSeries
+
+	// Create a query
+	query := "SELECT id, title, author, isbn, metadata_pending, cover_key, thumbnail_key, file_key, file_type, total_pages, format, unit, status, " + bookSeriesColumns + " FROM books WHERE id = ? && user_id = ?"
+
+	// tx stuffs
+	tx, err := s.DB.Begin()
+	defer shared.CommitOrRollback(tx, err)
+	if err != nil {
+		slog.Error("Commit Rollback error", "err", err)
+		return &book, err
+	}
+
+	// Query and checks if the book exist
+	err = tx.QueryRowContext(context.Background(), query, bookId, userId).Scan(&book.Id, &book.Title, &book.Author, &book.Isbn, &book.MetadataPending, &coverKey, &thumbnailKey, &fileKey, &book.FileType, &book.TotalPages, &book.Format, &book.Unit, &book.Status,
+		&series.id, &series.name, &series.position)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			slog.Error("Book not found", "err", err)
+			return &book, fiber.NewError(fiber.StatusBadRequest, "Book with such credentials does not exist")
+		}
+	}
+	book.Length = formatPosition(book.Unit, book.TotalPages)
+	book.CoverUrl = coverURL(coverKey)
+	book.ThumbnailUrl = coverURL(thumbnailKey)
+	book.FileUrl = bookFileURL(book.Id, fileKey)
+	book.Series = series.response()
+
+	authors, err := s.GetBookAuthors([]int{book.Id})
+	if err != nil {
+		return &book, err
+	}
+	book.Authors = authors[book.Id]
+	if book.Authors == nil {
+		book.Authors = []*ResponseBookAuthor{}
+	}
+
+	return &book, nil
+}
+
+func (s *ProducerService) DeleteBookById(bookId int, userId int) (err error) {
+	// The cover and the file go along with the book
+	var coverKey, thumbnailKey, fileKey sql.NullString
+	err = s.DB.QueryRowContext(context.Background(), "SELECT cover_key, thumbnail_key, file_key FROM books WHERE id = ? AND user_id = ?", bookId, userId).
+		Scan(&coverKey, &thumbnailKey, &fileKey)
+	if err != nil && err != sql.ErrNoRows {
+		slog.Error("Eror while query", "err", err)
+		return err
+	}
+
+	// Create a query
+	query := "DELETE FROM books where id = ? && user_id = ?"
+
+	// Runs after the commit below, the blobs only go once the book is really gone
+	defer func() {
+		if err == nil {
+			s.deleteBlobs(coverKey.String, thumbnailKey.String, fileKey.String)
+		}
+	}()
+
+	// tx stuffs
+	tx, err := s.DB.Begin()
+	if err != nil {
+		slog.Error("Commit Rollback error", "err", err)
+		return err
+	}
+	defer func() {
+		err = shared.CommitOrRollback(tx, err)
+	}()
+
+	// Execute the query with ExecContext
+	result, err := tx.ExecContext(context.Background(), query, bookId, userId)
+	if err != nil {
+		slog.Error("Error while deleting data", "err", err)
+		return err
+	}
+
+	// checks the affected row to make sure if there is in fact deleted book
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		slog.Error("Failed, no rows affected")
+		return fiber.NewError(fiber.StatusBadRequest, "Delete failed, book probably does not exist")
+	}
+
+	// Authors and series only exist through their books
+	err = shared.PruneAuthors(context.Background(), tx, userId)
+	if err != nil {
+		slog.Error("Error while deleting data", "err", err)
+		return err
+	}
+	err = pruneSeries(tx, userId)
+	return err
+}
+
+// UpdateBook edits the book in place instead of making the user delete it and lose its history.
+// A new total_pages has to agree with what was already read: it can't go under the latest progress,
+// the status follows it, and the in-progress goals get settled when the change decides them.
+// Everything is written in one transaction holding the book, the goal messages go out once it's committed.
+func (s *ProducerService) UpdateBook(bookId int, userId int, req RequestUpdateBook) (*ResponseGetBook, error) {
+	// Checks if the user hold the book
+	book, err := s.GetBookById(bookId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	// Checked before anything gets written
+	if (req.Author != nil || req.Authors != nil) && len(bookAuthorNames(req)) == 0 {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "A book needs at least one author")
+	}
+	if req.SeriesPosition != nil {
+		leaving := req.Series != nil && strings.TrimSpace(*req.Series) == ""
+		if leaving || (req.Series == nil && book.Series == nil) {
+			return nil, errNoSeries
+		}
+	}
+
+	settled, err := s.updateBook(book, userId, req)
+	if err != nil {
+		return nil, err
+	}
+	if settled != nil {
+		s.publishSettledGoals(settled)
+	}
+
+	return s.GetBookById(bookId, userId)
+}
+
+// updateBook writes an UpdateBook, the goals the new page count settled are returned for publishing
+func (s *ProducerService) updateBook(book *ResponseGetBook, userId int, req RequestUpdateBook) (settled *settledGoals, err error) {
+	// tx stuffs
+	tx, err := s.DB.Begin()
+	if err != nil {
+		slog.Error("Commit Rollback error", "err", err)
+		return nil, err
+	}
+	defer func() {
+		err = shared.CommitOrRollback(tx, err)
+	}()
+
+	// The lock keeps progresses and other edits out until this one is done, the checks below read what it holds
+	query := "SELECT total_pages, unit, status FROM books WHERE id = ? AND user_id = ? FOR UPDATE"
+	err = tx.QueryRowContext(context.Background(), query, book.Id, userId).Scan(&book.TotalPages, &book.Unit, &book.Status)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fiber.NewError(fiber.StatusNotFound, "Book not found")
+		}
+		slog.Error("Eror while query", "err", err)
+		return nil, err
+	}
+
+	var sets []string
+	var args []any
+
+	if req.Title != nil {
+		sets = append(sets, "title = ?")
+		args = append(args, *req.Title)
+	}
+	// The length in the book's own unit wins over total_pages
+	if req.Length != nil {
+		totalPages, err := parsePosition(book.Unit, *req.Length)
+		if err != nil {
+			return nil, err
+		}
+		req.TotalPages = &totalPages
+	}
+	if req.TotalPages != nil && *req.TotalPages <= 0 {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "The length of a book must be more than 0")
+	}
+	if req.TotalPages != nil && book.Unit == "percent" && *req.TotalPages != percentTotal {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "A book counted in percent is always 100% long")
+	}
+
+	pagesChanged := req.TotalPages != nil && *req.TotalPages != book.TotalPages
+	status := book.Status
+	if pagesChanged {
+		untilPage, err := latestUntilPage(tx, book.Id)
+		if err != nil {
+			return nil, err
+		}
+
+		if *req.TotalPages < untilPage {
+			return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("total_pages can't be lower than your latest progress, you're already at %s", formatPosition(book.Unit, untilPage)))
+		}
+
+		// Only reading and completed follow the page count, a status the user picked stays as it is
+		if status == "reading" || status == "completed" {
+			status = "reading"
+			if untilPage > 0 && untilPage >= *req.TotalPages {
+				status = "completed"
+			}
+			sets = append(sets, "status = ?")
+			args = append(args, status)
+		}
+
+		sets = append(sets, "total_pages = ?")
+		args = append(args, *req.TotalPages)
+	}
+
+	if len(sets) > 0 {
+		// Create a query
+		query := "UPDATE books SET " + strings.Join(sets, ", ") + " WHERE id = ? AND user_id = ?"
+
+		_, err = tx.ExecContext(context.Background(), query, append(args, book.Id, userId)...)
+		if err != nil {
+			slog.Error("Error while updating data", "err", err)
+			return nil, err
+		}
+	}
+
+	if req.Author != nil || req.Authors != nil || req.Series != nil || req.SeriesPosition != nil {
+		err = updateBookCredits(tx, book, userId, req)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if status != book.Status {
+		err = finishRun(tx, book.Id, status == "completed")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if pagesChanged {
+		settled, err = settleGoals(tx, book.Id, *req.TotalPages, status == "completed")
+		if err != nil {
+			slog.Error("Error while settling goals", "book_id", book.Id, "err", err)
+			return nil, err
+		}
+	}
+
+	return settled, nil
+}
+
+// latestUntilPage is how far the current run got, 0 before its first progress.
+// It's a locking read, so a progress committed after the transaction started still counts.
+func latestUntilPage(tx *sql.Tx, bookId int) (int, error) {
+	query := `SELECT until_page FROM progresses
+		WHERE run_id = (SELECT MAX(id) FROM reading_runs WHERE book_id = ?) ORDER BY created_at DESC, id DESC LIMIT 1 FOR SHARE`
+
+	var untilPage int
+	err := tx.QueryRowContext(context.Background(), query, bookId).Scan(&untilPage)
+	if err != nil && err != sql.ErrNoRows {
+		slog.Error("Eror while query", "err", err)
+		return 0, err
+	}
+
+	return untilPage, nil
+}
+
+// settledGoals are the goals a new page count decided, finished when the book got completed by it, expired otherwise
+type settledGoals struct {
+	goalIds  []int
+	finished bool
+}
+
+// settleGoals decides the in-progress goals a new page count made pointless to wait for.
+// A book that is now completed fulfils them, since the whole book got read.
+// Otherwise only the goals aiming past the last page are lost, the consumer expires them once they're published.
+func settleGoals(tx *sql.Tx, bookId int, totalPages int, completed bool) (*settledGoals, error) {
+	query := "SELECT id FROM goals WHERE book_id = ? AND status = 'in-progress'"
+	args := []any{bookId}
+	if !completed {
+		query += " AND target_page > ?"
+		args = append(args, totalPages)
+	}
+
+	rows, err := tx.QueryContext(context.Background(), query+" FOR UPDATE", args...)
+	if err != nil {
+		slog.Error("Eror while query", "err", err)
+		return nil, err
+	}
+
+	settled := &settledGoals{finished: completed}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			slog.Error("Error querying", "err", err)
+			return nil, err
+		}
+		settled.goalIds = append(settled.goalIds, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if !completed {
+		return settled, nil
+	}
+
+	for _, goalId := range settled.goalIds {
+		_, err = tx.ExecContext(context.Background(), "UPDATE goals SET status = 'finished' WHERE id = ?", goalId)
+		if err != nil {
+			slog.Error("Error while updating data", "err", err)
+			return nil, err
+		}
+	}
+
+	return settled, nil
+}
+
+// publishSettledGoals tells the consumer about the goals an UpdateBook settled.
+// The change is already committed, a message that can't go out is logged instead of failing the request.
+func (s *ProducerService) publishSettledGoals(settled *settledGoals) {
+	for _, goalId := range settled.goalIds {
+		msg, _, err := s.getGoalMessage(goalId)
+		if err != nil {
+			slog.Error("Error while publishing settled goal", "goal_id", goalId, "err", err)
+			continue
+		}
+
+		// Expiring goes through the consumer like a deadline that passed, it sets the status and mails the owner
+		if !settled.finished {
+			err = s.sendDeadlineMessage(msg, 0, nil)
+		} else {
+			var body []byte
+			body, err = json.Marshal(msg)
+			if err == nil {
+				// Send the message to exchange
+				err = s.sendGoalMessage(body)
+			}
+		}
+		if err != nil {
+			slog.Error("Error while publishing settled goal", "goal_id", goalId, "err", err)
+		}
+	}
+}
+
+func (s *ProducerService) SetBookStatus(bookId int, status string) error {
+	if _, ok := bookTransitions[status]; !ok {
+		slog.Error("Status invalid")
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+
+	// Create a query
+	query := "UPDATE books SET status = ? WHERE id = ?"
+
+	// tx stuffs
+	tx, err := s.DB.Begin()
+	defer shared.CommitOrRollback(tx, err)
+	if err != nil {
+		slog.Error("Commit Rollback error", "err", err)
+		return err
+	}
+
+	// Execute the query with ExecContext
+	result, err := tx.ExecContext(context.Background(), query, status, bookId)
+	if err != nil {
+		slog.Error("Error while inserting data", "err", err)
+		return err
+	}
+
+	// checks the affected row to make sure if there is in fact updated book
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		slog.Error("Failed, no rows affected")
+		return fiber.NewError(fiber.StatusBadRequest, "Update failed, no rows affected")
+	}
+
+	return nil
+}
+
+// bookTransitions is the lifecycle of a book, which statuses can be set by hand from which.
+// completed is never picked, a book gets there by reading it to the last page and leaves it by starting a re-read.
+var bookTransitions = map[string][]string{
+	"want-to-read": {"reading", "abandoned"},
+	"reading":      {"paused", "abandoned"},
+	"paused":       {"reading", "abandoned"},
+	"abandoned":    {"reading", "want-to-read"},
+	"completed":    {},
+}
+
+// TransitionBook moves the book to another status its current one allows.
+// Abandoning a book settles its in-progress goals, they get cancelled (or expired when asked to)
+// and the owner gets the email for it.
+func (s *ProducerService) TransitionBook(bookId int, userId int, req RequestSetBookStatus) (*ResponseGetBook, error) {
+	// Checks if the user hold the book
+	book, err := s.GetBookById(bookId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if book.Status == req.Status {
+		return book, nil
+	}
+
+	if !slices.Contains(bookTransitions[book.Status], req.Status) {
+		return nil, fiber.NewError(fiber.StatusConflict, fmt.Sprintf("A %s book can't be set to %s", book.Status, req.Status))
+	}
+
+	status := req.Status
+	if status == "reading" {
+		// acquire latest progress
+		progress, err := s.getLatestProgress(book.Id)
+		if err != nil {
+			return nil, err
+		}
+
+		// Picking up a book that was already read to its last page finishes it
+		if progress.UntilPage > 0 && progress.UntilPage >= book.TotalPages {
+			status = "completed"
+		}
+	}
+
+	// Create a query, the current status is in the condition so two racing requests can't both pass the check above
+	query := "UPDATE books SET status = ? WHERE id = ? AND user_id = ? AND status = ?"
+
+	result, err := s.DB.ExecContext(context.Background(), query, status, bookId, userId, book.Status)
+	if err != nil {
+		slog.Error("Error while updating data", "err", err)
+		return nil, err
+	}
+
+	// checks the affected row to make sure if there is in fact updated book
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, fiber.NewError(fiber.StatusConflict, "The book changed in the meantime, try again")
+	}
+
+	if status == "completed" {
+		err = s.finishCurrentRun(book.Id, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if status == "abandoned" {
+		err = s.abandonGoals(book.Id, req.Goals == "expire")
+		if err != nil {
+			slog.Error("Error while settling goals", "book_id", book.Id, "err", err)
+			return nil, err
+		}
+	}
+
+	return s.GetBookById(bookId, userId)
+}
+
+// abandonGoals ends the in-progress goals of a book nobody is going to read anymore.
+// Cancelling happens right here, expiring goes through the consumer like a deadline that passed.
+func (s *ProducerService) abandonGoals(bookId int, expire bool) error {
+	query := "SELECT id FROM goals WHERE book_id = ? AND status = 'in-progress'"
+
+	rows, err := s.DB.QueryContext(context.Background(), query, bookId)
+	if err != nil {
+		slog.Error("Eror while query", "err", err)
+		return err
+	}
+
+	var goalIds []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			slog.Error("Error querying", "err", err)
+			return err
+		}
+		goalIds = append(goalIds, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, goalId := range goalIds {
+		msg, _, err := s.getGoalMessage(goalId)
+		if err != nil {
+			return err
+		}
+
+		if expire {
+			err = s.sendDeadlineMessage(msg, 0, nil)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err = s.SetGoalStatus("cancelled", goalId)
+		if err != nil {
+			return err
+		}
+
+		err = s.sendCancelledMessage(msg)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// READING RUNS
+
+// getCurrentRun returns the run the book is on, the one with the highest number
+func (s *ProducerService) getCurrentRun(bookId int) (*ReadingRun, error) {
+	var run ReadingRun
+
+	// Create a query
+	query := "SELECT id, book_id, number, started_at, finished_at FROM reading_runs WHERE book_id = ? ORDER BY number DESC LIMIT 1"
+
+	err := s.DB.QueryRowContext(context.Background(), query, bookId).Scan(&run.Id, &run.BookId, &run.Number, &run.StartedAt, &run.FinishedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fiber.NewError(fiber.StatusNotFound, "Book has no reading run")
+		}
+		slog.Error("Eror while query", "err", err)
+		return nil, err
+	}
+
+	return &run, nil
+}
+
+// finishCurrentRun stamps the current run as read to the end, or takes the stamp back
+func (s *ProducerService) finishCurrentRun(bookId int, finished bool) error {
+	return finishRun(s.DB, bookId, finished)
+}
+
+// finishRun is finishCurrentRun on a *sql.DB or inside a transaction
+func finishRun(db shared.Execer, bookId int, finished bool) error {
+	var finishedAt any
+	if finished {
+		finishedAt = time.Now()
+	}
+
+	// Create a query
+	query := "UPDATE reading_runs SET finished_at = ? WHERE book_id = ? ORDER BY number DESC LIMIT 1"
+
+	_, err := db.ExecContext(context.Background(), query, finishedAt, bookId)
+	if err != nil {
+		slog.Error("Error while updating data", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+// StartReread opens a new run on a completed book, it is back to reading from the first page.
+// Progresses and goals of the earlier runs stay as they were.
+func (s *ProducerService) StartReread(bookId int, userId int) (book *ResponseGetBook, err error) {
+	// Checks if the user hold the book
+	book, err = s.GetBookById(bookId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if book.Status != "completed" {
+		return nil, fiber.NewError(fiber.StatusConflict, "Only a completed book can be read again")
+	}
+
+	// tx stuffs
+	tx, err := s.DB.Begin()
+	if err != nil {
+		slog.Error("Commit Rollback error", "err", err)
+		return nil, err
+	}
+	defer func() {
+		err = shared.CommitOrRollback(tx, err)
+	}()
+
+	// The status is in the condition so two racing requests can't both open a run
+	result, err := tx.ExecContext(context.Background(), "UPDATE books SET status = 'reading' WHERE id = ? AND status = 'completed'", bookId)
+	if err != nil {
+		slog.Error("Error while updating data", "err", err)
+		return nil, err
+	}
+
+	// checks the affected row to make sure if there is in fact updated book
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, fiber.NewError(fiber.StatusConflict, "The book changed in the meantime, try again")
+	}
+
+	// Create a query
+	query := "INSERT INTO reading_runs (book_id, number) SELECT ?, COALESCE(MAX(number), 0) + 1 FROM reading_runs WHERE book_id = ?"
+
+	_, err = tx.ExecContext(context.Background(), query, bookId, bookId)
+	if err != nil {
+		slog.Error("Error while inserting data", "err", err)
+		return nil, err
+	}
+
+	book.Status = "reading"
+	return book, nil
+}
+
+// GetReadingRuns lists every run of the book with how far it got, the first read comes first
+func (s *ProducerService) GetReadingRuns(bookId int, userId int) ([]*ResponseReadingRun, error) {
+	runs := []*ResponseReadingRun{}
+
+	// Create a query
+	query := `SELECT r.id, r.number, r.started_at, r.finished_at, COALESCE(MAX(p.until_page), 0), COUNT(p.id), b.total_pages
+		FROM reading_runs r JOIN books b ON b.id = r.book_id LEFT JOIN progresses p ON p.run_id = r.id
+		WHERE r.book_id = ? AND b.user_id = ? GROUP BY r.id, r.number, r.started_at, r.finished_at, b.total_pages ORDER BY r.number`
+
+	rows, err := s.DB.QueryContext(context.Background(), query, bookId, userId)
+	if err != nil {
+		slog.Error("Eror while query", "err", err)
+		return nil, err
+	}
+
+	// close the rows of course
+	defer rows.Close()
+
+	// Times are shown in the user's timezone
+	loc := s.getUserLocation(userId)
+
+	for rows.Next() {
+		var run ResponseReadingRun
+		var finishedAt sql.NullTime
+		var totalPages int
+		if err := rows.Scan(&run.Id, &run.Number, &run.StartedAt, &finishedAt, &run.PagesRead, &run.Progresses, &totalPages); err != nil {
+			slog.Error("Error querying", "err", err)
+			return nil, err
+		}
+		run.Percent = percentOf(run.PagesRead, totalPages)
+		run.StartedAt = run.StartedAt.In(loc)
+		if finishedAt.Valid {
+			t := finishedAt.Time.In(loc)
+			run.FinishedAt = &t
+		}
+		runs = append(runs, &run)
+	}
+
+	return runs, rows.Err()
+}
+
+// COVERS
+
+// getCoverMaxBytes is the biggest cover accepted, serverBodyLimit grows with it
+func getCoverMaxBytes() int64 {
+	maxBytes := shared.NewConfig().GetInt64("COVER_MAX_BYTES")
+	if maxBytes <= 0 {
+		return 2 * 1024 * 1024
+	}
+
+	return maxBytes
+}
+
+// SetBookCover stores a new cover with its thumbnail and drops the old ones.
+// The type is sniffed from the bytes, whatever the upload claims to be doesn't count.
+func (s *ProducerService) SetBookCover(bookId int, userId int, data []byte) (*ResponseGetBook, error) {
+	// Checks if the user hold the book
+	book, err := s.GetBookById(bookId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	extension, ok := coverTypes[http.DetectContentType(data)]
+	if !ok {
+		return nil, fiber.NewError(fiber.StatusUnsupportedMediaType, "The cover has to be a JPEG, PNG or GIF image")
+	}
+
+	// The size is in the header, a too big image is turned down before it gets decoded
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "The cover image could not be read")
+	}
+	if config.Width*config.Height > coverMaxPixels {
+		return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("The cover can't have more than %d pixels", coverMaxPixels))
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "The cover image could not be read")
+	}
+
+	thumbnail, err := makeThumbnail(img)
+	if err != nil {
+		return nil, err
+	}
+
+	coverKey, thumbnailKey, err := newCoverKeys(extension)
+	if err != nil {
+		return nil, err
+	}
+
+	store := shared.GetBlobStore()
+	ctx := context.Background()
+	if err := store.Put(ctx, coverKey, mime.TypeByExtension(extension), data); err != nil {
+		slog.Error("Error while storing cover", "err", err)
+		return nil, err
+	}
+	if err := store.Put(ctx, thumbnailKey, "image/jpeg", thumbnail); err != nil {
+		slog.Error("Error while storing thumbnail", "err", err)
+		s.deleteBlobs(coverKey)
+		return nil, err
+	}
+
+	// The old keys are read in the same statement, two uploads at once can't lose track of a cover
+	var oldCover, oldThumbnail sql.NullString
+
+	// tx stuffs
+	tx, err := s.DB.Begin()
+	if err != nil {
+		s.deleteBlobs(coverKey, thumbnailKey)
+		return nil, err
+	}
+
+	query := "SELECT cover_key, thumbnail_key FROM books WHERE id = ? AND user_id = ? FOR UPDATE"
+	err = tx.QueryRowContext(ctx, query, bookId, userId).Scan(&oldCover, &oldThumbnail)
+	if err == nil {
+		query = "UPDATE books SET cover_key = ?, thumbnail_key = ? WHERE id = ?"
+		_, err = tx.ExecContext(ctx, query, coverKey, thumbnailKey, bookId)
+	}
+	if err = shared.CommitOrRollback(tx, err); err != nil {
+		slog.Error("Error while updating data", "err", err)
+		s.deleteBlobs(coverKey, thumbnailKey)
+		return nil, err
+	}
+
+	s.deleteBlobs(oldCover.String, oldThumbnail.String)
+
+	book.CoverUrl = coverURL(sql.NullString{String: coverKey, Valid: true})
+	book.ThumbnailUrl = coverURL(sql.NullString{String: thumbnailKey, Valid: true})
+
+	return book, nil
+}
+
+func (s *ProducerService) DeleteBookCover(bookId int, userId int) error {
+	var coverKey, thumbnailKey sql.NullString
+
+	query := "SELECT cover_key, thumbnail_key FROM books WHERE id = ? AND user_id = ?"
+	err := s.DB.QueryRowContext(context.Background(), query, bookId, userId).Scan(&coverKey, &thumbnailKey)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fiber.NewError(fiber.StatusBadRequest, "Book with such credentials does not exist")
+		}
+		slog.Error("Eror while query", "err", err)
+		return err
+	}
+	if !coverKey.Valid {
+		return fiber.NewError(fiber.StatusNotFound, "The book has no cover")
+	}
+
+	query = "UPDATE books SET cover_key = NULL, thumbnail_key = NULL WHERE id = ? AND cover_key = ?"
+	_, err = s.DB.ExecContext(context.Background(), query, bookId, coverKey.String)
+	if err != nil {
+		slog.Error("Error while updating data", "err", err)
+		return err
+	}
+
+	s.deleteBlobs(coverKey.String, thumbnailKey.String)
+
+	return nil
+}
+
+// GetCover opens a cover or thumbnail by the name in its url, covers are public so emails can show them
+func (s *ProducerService) GetCover(name string) (io.ReadCloser, string, error) {
+	if !coverNamePattern.MatchString(name) {
+		return nil, "", fiber.NewError(fiber.StatusNotFound, "Cover not found")
+	}
+
+	blob, contentType, err := shared.GetBlobStore().Get(context.Background(), "covers/"+name)
+	if errors.Is(err, shared.ErrBlobNotFound) {
+		return nil, "", fiber.NewError(fiber.StatusNotFound, "Cover not found")
+	}
+	if err != nil {
+		slog.Error("Error while reading cover", "err", err)
+		return nil, "", err
+	}
+
+	return blob, contentType, nil
+}
+
+// deleteBlobs removes blobs nothing points at anymore, a blob left behind only costs space so failures are just logged
+func (s *ProducerService) deleteBlobs(keys ...string) {
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		if err := shared.GetBlobStore().Delete(context.Background(), key); err != nil {
+			slog.Error("Error while deleting blob", "key", key, "err", err)
+		}
+	}
+}
+
+// BOOK FILES
+
+// SetBookFile stores the ebook itself so reading apps can download it, an older file is dropped.
+// Like covers the type is sniffed from the bytes, only EPUB and PDF are taken.
+func (s *ProducerService) SetBookFile(bookId int, userId int, data []byte) (*ResponseGetBook, error) {
+	// Checks if the user hold the book
+	book, err := s.GetBookById(bookId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	contentType := sniffBookFile(data)
+	if contentType == "" {
+		return nil, fiber.NewError(fiber.StatusUnsupportedMediaType, "The book file has to be an EPUB or a PDF")
+	}
+
+	id, err := shared.GenerateOpaqueToken(16)
+	if err != nil {
+		return nil, err
+	}
+	fileKey := "files/" + id + bookFileTypes[contentType]
+
+	ctx := context.Background()
+	if err := shared.GetBlobStore().Put(ctx, fileKey, contentType, data); err != nil {
+		slog.Error("Error while storing book file", "err", err)
+		return nil, err
+	}
+
+	// The old key is read in the same statement, two uploads at once can't lose track of a file
+	var oldFile sql.NullString
+
+	// tx stuffs
+	tx, err := s.DB.Begin()
+	if err != nil {
+		s.deleteBlobs(fileKey)
+		return nil, err
+	}
+
+	query := "SELECT file_key FROM books WHERE id = ? AND user_id = ? FOR UPDATE"
+	err = tx.QueryRowContext(ctx, query, bookId, userId).Scan(&oldFile)
+	if err == nil {
+		query = "UPDATE books SET file_key = ?, file_type = ? WHERE id = ?"
+		_, err = tx.ExecContext(ctx, query, fileKey, contentType, bookId)
+	}
+	if err = shared.CommitOrRollback(tx, err); err != nil {
+		slog.Error("Error while updating data", "err", err)
+		s.deleteBlobs(fileKey)
+		return nil, err
+	}
+
+	s.deleteBlobs(oldFile.String)
+
+	book.FileUrl = bookFileURL(book.Id, sql.NullString{String: fileKey, Valid: true})
+	book.FileType = &contentType
+
+	return book, nil
+}
+
+func (s *ProducerService) DeleteBookFile(bookId int, userId int) error {
+	var fileKey sql.NullString
+
+	query := "SELECT file_key FROM books WHERE id = ? AND user_id = ?"
+	err := s.DB.QueryRowContext(context.Background(), query, bookId, userId).Scan(&fileKey)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fiber.NewError(fiber.StatusBadRequest, "Book with such credentials does not exist")
+		}
+		slog.Error("Eror while query", "err", err)
+		return err
+	}
+	if !fileKey.Valid {
+		return fiber.NewError(fiber.StatusNotFound, "The book has no file")
+	}
+
+	query = "UPDATE books SET file_key = NULL, file_type = NULL WHERE id = ? AND file_key = ?"
+	_, err = s.DB.ExecContext(context.Background(), query, bookId, fileKey.String)
+	if err != nil {
+		slog.Error("Error while updating data", "err", err)
+		return err
+	}
+
+	s.deleteBlobs(fileKey.String)
+
+	return nil
+}
+
+// GetBookFile opens the book's file, it returns the content type and the name to save it under along with it
+func (s *ProducerService) GetBookFile(bookId int, userId int) (io.ReadCloser, string, string, error) {
+	var title string
+	var fileKey, fileType sql.NullString
+
+	query := "SELECT COALESCE(title, ''), file_key, file_type FROM books WHERE id = ? AND user_id = ?"
+	err := s.DB.QueryRowContext(context.Background(), query, bookId, userId).Scan(&title, &fileKey, &fileType)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, "", "", fiber.NewError(fiber.StatusBadRequest, "Book with such credentials does not exist")
+		}
+		slog.Error("Eror while query", "err", err)
+		return nil, "", "", err
+	}
+	if !fileKey.Valid {
+		return nil, "", "", fiber.NewError(fiber.StatusNotFound, "The book has no file")
+	}
+
+	blob, _, err := shared.GetBlobStore().Get(context.Background(), fileKey.String)
+	if errors.Is(err, shared.ErrBlobNotFound) {
+		return nil, "", "", fiber.NewError(fiber.StatusNotFound, "The book has no file")
+	}
+	if err != nil {
+		slog.Error("Error while reading book file", "err", err)
+		return nil, "", "", err
+	}
+
+	return blob, fileType.String, bookFileName(title, fileType.String), nil
+}
+
+// AUTHORS
+
+var (
+	errAuthorExists = fiber.NewError(fiber.StatusConflict, "You already have an author with that name, merge them instead")
+	errAuthorName   = fiber.NewError(fiber.StatusBadRequest, "The name needs at least one letter or digit")
+)
+
+// MigrateAuthors credits the books from before authors had their own table, their author text is split and the same
+// authors written differently end up as one. Books already credited are skipped, so running it again does nothing.
+func (s *ProducerService) MigrateAuthors() error {
+	// Books still waiting on their metadata get credited by the consumer
+	query := `SELECT id, user_id, author FROM books b
+		WHERE NOT metadata_pending AND COALESCE(author, '') <> ''
+		AND NOT EXISTS (SELECT 1 FROM book_authors ba WHERE ba.book_id = b.id)`
+
+	rows, err := s.DB.QueryContext(context.Background(), query)
+	if err != nil {
+		slog.Error("Eror while query", "err", err)
+		return err
+	}
+
+	// Collect first, we don't want to write while still reading the rows
+	type uncredited struct {
+		id     int
+		userId int
+		author string
+	}
+	var books []uncredited
+	for rows.Next() {
+		var book uncredited
+		if err := rows.Scan(&book.id, &book.userId, &book.author); err != nil {
+			rows.Close()
+			return err
+		}
+		books = append(books, book)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, book := range books {
+		if err := s.creditBook(book.id, book.userId, shared.SplitAuthors(book.author)); err != nil {
+			slog.Error("Error while crediting authors", "book_id", book.id, "err", err)
+			return err
+		}
+	}
+
+	if len(books) > 0 {
+		slog.Info("Book authors migrated", "count", len(books))
+	}
+
+	return nil
+}
+
+// creditBook runs SetBookAuthors in a transaction of its own
+func (s *ProducerService) creditBook(bookId int, userId int, names []string) (err error) {
+	// tx stuffs
+	tx, err := s.DB.Begin()
+	if err != nil {
+		slog.Error("Commit Rollback error", "err", err)
+		return err
+	}
+	defer func() {
+		err = shared.CommitOrRollback(tx, err)
+	}()
+
+	return shared.SetBookAuthors(context.Background(), tx, userId, bookId, names)
+}
+
+// updateBookCredits applies the authors and series of an UpdateBook
+func updateBookCredits(tx *sql.Tx, book *ResponseGetBook, userId int, req RequestUpdateBook) (err error) {
+	if req.Authors != nil || req.Author != nil {
+		err = shared.SetBookAuthors(context.Background(), tx, userId, book.Id, bookAuthorNames(req))
+		if err != nil {
+			slog.Error("Error while crediting authors", "err", err)
+			return err
+		}
+	}
+
+	if req.Series == nil {
+		if req.SeriesPosition != nil {
+			_, err = tx.ExecContext(context.Background(), "UPDATE books SET series_position = ? WHERE id = ?", req.SeriesPosition, book.Id)
+			if err != nil {
+				slog.Error("Error while updating data", "err", err)
+				return err
+			}
+		}
+		return nil
+	}
+
+	name := strings.TrimSpace(*req.Series)
+	if name == "" {
+		_, err = tx.ExecContext(context.Background(), "UPDATE books SET series_id = NULL, series_position = NULL WHERE id = ?", book.Id)
+		if err != nil {
+			slog.Error("Error while updating data", "err", err)
+			return err
+		}
+		return pruneSeries(tx, userId)
+	}
+
+	// Staying in the same series keeps the position unless a new one is given
+	position := req.SeriesPosition
+	if position == nil && book.Series != nil && strings.EqualFold(book.Series.Name, name) {
+		position = book.Series.Position
+	}
+
+	return setBookSeries(tx, userId, book.Id, name, position)
+}
+
+// bookAuthorNames is who an UpdateBook credits, authors wins over author
+func bookAuthorNames(req RequestUpdateBook) []string {
+	if req.Authors != nil {
+		return shared.CleanAuthors(req.Authors)
+	}
+	if req.Author != nil {
+		return shared.SplitAuthors(*req.Author)
+	}
+	return nil
+}
+
+// GetBookAuthors loads the authors of many books in one query, in the order they're credited
+func (s *ProducerService) GetBookAuthors(bookIds []int) (map[int][]*ResponseBookAuthor, error) {
+	authors := map[int][]*ResponseBookAuthor{}
+	if len(bookIds) == 0 {
+		return authors, nil
+	}
+
+	args := make([]any, len(bookIds))
+	for i, id := range bookIds {
+		args[i] = id
+	}
+	query := fmt.Sprintf(`SELECT ba.book_id, a.id, a.name FROM book_authors ba JOIN authors a ON a.id = ba.author_id
+		WHERE ba.book_id IN (%s) ORDER BY ba.position`, strings.TrimSuffix(strings.Repeat("?, ", len(bookIds)), ", "))
+
+	rows, err := s.DB.QueryContext(context.Background(), query, args...)
+	if err != nil {
+		slog.Error("Eror while query", "err", err)
+		return nil, err
+	}
+
+	// close the rows of course
+	defer rows.Close()
+
+	for rows.Next() {
+		var bookId int
+		var author ResponseBookAuthor
+		if err := rows.Scan(&bookId, &author.Id, &author.Name); err != nil {
+			slog.Error("Error querying", "err", err)
+			return nil, err
+		}
+		authors[bookId] = append(authors[bookId], &author)
+	}
+
+	return authors, rows.Err()
+}
+
+func (s *ProducerService) GetAuthors(userId int) ([]*ResponseAuthor, error) {
+	// Initialize var to place the authors
+	authors := []*ResponseAuthor{}
+
+	// Query
+	query := `SELECT a.id, a.name, COUNT(ba.book_id), a.created_at FROM authors a
+		LEFT JOIN book_authors ba ON ba.author_id = a.id
+		WHERE a.user_id = ? GROUP BY a.id, a.name, a.created_at ORDER BY a.name`
+
+	rows, err := s.DB.QueryContext(context.Background(), query, userId)
+	if err != nil {
+		slog.Error("Eror while query", "err", err)
+		return nil, err
+	}
+
+	// close the rows of course
+	defer rows.Close()
+
+	// Times are shown in the user's timezone
+	loc := s.getUserLocation(userId)
+
+	// Foreach-ing queried rows
+	for rows.Next() {
+		var author ResponseAuthor
+		err := rows.Scan(&author.Id, &author.Name, &author.Books, &author.CreatedAt)
+		if err != nil {
+			slog.Error("Error querying", "err", err)
+			return nil, err
+		}
+		author.CreatedAt = author.CreatedAt.In(loc)
+		authors = append(authors, &author)
+	}
+
+	return authors, rows.Err()
+}
+
+func (s *ProducerService) GetAuthor(authorId int, userId int) (*ResponseAuthor, error) {
+	var author ResponseAuthor
+
+	// Query
+	query := `SELECT a.id, a.name, COUNT(ba.book_id), a.created_at FROM authors a
+		LEFT JOIN book_authors ba ON ba.author_id = a.id
+		WHERE a.id = ? AND a.user_id = ? GROUP BY a.id, a.name, a.created_at`
+
+	err := s.DB.QueryRowContext(context.Background(), query, authorId, userId).Scan(&author.Id, &author.Name, &author.Books, &author.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, fiber.NewError(fiber.StatusNotFound, "Author not found")
+	}
+	if err != nil {
+		slog.Error("Eror while query", "err", err)
+		return nil, err
+	}
+	author.CreatedAt = author.CreatedAt.In(s.getUserLocation(userId))
+
+	return &author, nil
+}
+
+// RenameAuthor fixes how an author is written, the author text of their books follows
+func (s *ProducerService) RenameAuthor(authorId int, userId int, req RequestAuthor) (err error) {
+	name := strings.Join(strings.Fields(req.Name), " ")
+	key := shared.AuthorKey(name)
+	if key == "" {
+		return errAuthorName
+	}
+
+	// Checks if the user has the author
+	_, err = s.GetAuthor(authorId, userId)
+	if err != nil {
+		return err
+	}
+
+	// tx stuffs
+	tx, err := s.DB.Begin()
+	if err != nil {
+		slog.Error("Commit Rollback error", "err", err)
+		return err
+	}
+	defer func() {
+		err = shared.CommitOrRollback(tx, err)
+	}()
+
+	_, err = tx.ExecContext(context.Background(), "UPDATE authors SET name = ?, name_key = ? WHERE id = ? AND user_id = ?", name, key, authorId, userId)
+	if err != nil {
+		if shared.IsDuplicateEntry(err) {
+			return errAuthorExists
+		}
+		slog.Error("Error while updating data", "err", err)
+		return err
+	}
+
+	return syncAuthorText(tx, authorId)
+}
+
+// MergeAuthor moves every book of the author over to another one and removes the author,
+// for the same person written in ways the names can't tell apart, e.g. "Tolkien" and "J.R.R. Tolkien"
+func (s *ProducerService) MergeAuthor(authorId int, userId int, req RequestMergeAuthor) (err error) {
+	if req.Into == authorId {
+		return fiber.NewError(fiber.StatusBadRequest, "An author can't be merged into itself")
+	}
+
+	// Checks if the user has both authors
+	_, err = s.GetAuthor(authorId, userId)
+	if err != nil {
+		return err
+	}
+	_, err = s.GetAuthor(req.Into, userId)
+	if err != nil {
+		return err
+	}
+
+	// tx stuffs
+	tx, err := s.DB.Begin()
+	if err != nil {
+		slog.Error("Commit Rollback error", "err", err)
+		return err
+	}
+	defer func() {
+		err = shared.CommitOrRollback(tx, err)
+	}()
+
+	// Books crediting both keep the credit they already have, MySQL wants the subquery wrapped to read the table it deletes from
+	_, err = tx.ExecContext(context.Background(), `DELETE FROM book_authors WHERE author_id = ?
+		AND book_id IN (SELECT book_id FROM (SELECT book_id FROM book_authors WHERE author_id = ?) AS credited)`, authorId, req.Into)
+	if err != nil {
+		slog.Error("Error while deleting data", "err", err)
+		return err
+	}
+
+	_, err = tx.ExecContext(context.Background(), "UPDATE book_authors SET author_id = ? WHERE author_id = ?", req.Into, authorId)
+	if err != nil {
+		slog.Error("Error while updating data", "err", err)
+		return err
+	}
+
+	_, err = tx.ExecContext(context.Background(), "DELETE FROM authors WHERE id = ? AND user_id = ?", authorId, userId)
+	if err != nil {
+		slog.Error("Error while deleting data", "err", err)
+		return err
+	}
+
+	return syncAuthorText(tx, req.Into)
+}
+
+// syncAuthorText writes the author text of the author's books again from their credits
+func syncAuthorText(tx *sql.Tx, authorId int) error {
+	query := `SELECT ba.book_id, a.name FROM book_authors ba JOIN authors a ON a.id = ba.author_id
+		WHERE ba.book_id IN (SELECT book_id FROM book_authors WHERE author_id = ?)
+		ORDER BY ba.book_id, ba.position`
+
+	rows, err := tx.QueryContext(context.Background(), query, authorId)
+	if err != nil {
+		slog.Error("Eror while query", "err", err)
+		return err
+	}
+
+	var credits []authorCredit
+	for rows.Next() {
+		var credit authorCredit
+		if err := rows.Scan(&credit.bookId, &credit.name); err != nil {
+			rows.Close()
+			slog.Error("Error querying", "err", err)
+			return err
+		}
+		credits = append(credits, credit)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for bookId, text := range authorTexts(credits) {
+		_, err = tx.ExecContext(context.Background(), "UPDATE books SET author = ? WHERE id = ?", text, bookId)
+		if err != nil {
+			slog.Error("Error while updating data", "err", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// authorCredit is one name a book is credited to
+type authorCredit struct {
+	bookId int
+	name   string
+}
+
+// authorTexts is the books.author text of every book in the credits, given in order.
+// It's joined the way shared.JoinAuthors does it, so shared.SplitAuthors reads the same names back.
+func authorTexts(credits []authorCredit) map[int]string {
+	names := map[int][]string{}
+	for _, credit := range credits {
+		names[credit.bookId] = append(names[credit.bookId], credit.name)
+	}
+
+	texts := map[int]string{}
+	for bookId, bookNames := range names {
+		texts[bookId] = shared.JoinAuthors(bookNames)
+	}
+
+	return texts
+}
+
+// SERIES
+
+var (
+	errSeriesExists = fiber.NewError(fiber.StatusConflict, "You already have a series with that name")
+	errNoSeries     = fiber.NewError(fiber.StatusBadRequest, "A series position needs a series")
+)
+
+// bookSeriesColumns are the series columns of a query on the books table, in the order a bookSeries scans them
+const bookSeriesColumns = "series_id, (SELECT s.name FROM series s WHERE s.id = books.series_id), series_position"
+
+type bookSeries struct {
+	id       sql.NullInt64
+	name     sql.NullString
+	position sql.NullFloat64
+}
+
+func (b bookSeries) response() *ResponseBookSeries {
+	if !b.id.Valid {
+		return nil
+	}
+
+	series := &ResponseBookSeries{Id: int(b.id.Int64), Name: b.name.String}
+	if b.position.Valid {
+		position := b.position.Float64
+		series.Position = &position
+	}
+
+	return series
+}
+
+// setBookSeries puts the book in the series, which gets created when the user doesn't have it yet
+func setBookSeries(tx *sql.Tx, userId int, bookId int, name string, position *float64) error {
+	// LAST_INSERT_ID(id) makes an existing series hand back its id too
+	result, err := tx.ExecContext(context.Background(), "INSERT INTO series (user_id, name) VALUES (?, ?) ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id)",
+		userId, strings.TrimSpace(name))
+	if err != nil {
+		slog.Error("Error while inserting data", "err", err)
+		return err
+	}
+	seriesId, err := result.LastInsertId()
+	if err != nil {
+		slog.Error("Failed to get last insert ID", "err", err)
+		return err
+	}
+
+	_, err = tx.ExecContext(context.Background(), "UPDATE books SET series_id = ?, series_position = ? WHERE id = ?", seriesId, position, bookId)
+	if err != nil {
+		slog.Error("Error while updating data", "err", err)
+		return err
+	}
+
+	// The book may have left another series
+	return pruneSeries(tx, userId)
+}
+
+// pruneSeries removes the user's series no book is in anymore
+func pruneSeries(tx *sql.Tx, userId int) error {
+	_, err := tx.ExecContext(context.Background(), `DELETE s FROM series s
+		LEFT JOIN books b ON b.series_id = s.id
+		WHERE s.user_id = ? AND b.id IS NULL`, userId)
+	if err != nil {
+		slog.Error("Error while deleting data", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *ProducerService) GetAllSeries(userId int) ([]*ResponseSeries, error) {
+	// Initialize var to place the series
+	series := []*ResponseSeries{}
+
+	// Query
+	query := `SELECT s.id, s.name, COUNT(b.id), s.created_at FROM series s
+		LEFT JOIN books b ON b.series_id = s.id
+		WHERE s.user_id = ? GROUP BY s.id, s.name, s.created_at ORDER BY s.name`
+
+	rows, err := s.DB.QueryContext(context.Background(), query, userId)
+	if err != nil {
+		slog.Error("Eror while query", "err", err)
+		return nil, err
+	}
+
+	// close the rows of course
+	defer rows.Close()
+
+	// Times are shown in the user's timezone
+	loc := s.getUserLocation(userId)
+
+	// Foreach-ing queried rows
+	for rows.Next() {
+		var one ResponseSeries
+		err := rows.Scan(&one.Id, &one.Name, &one.Books, &one.CreatedAt)
+		if err != nil {
+			slog.Error("Error querying", "err", err)
+			return nil, err
+		}
+		one.CreatedAt = one.CreatedAt.In(loc)
+		series = append(series, &one)
+	}
+
+	return series, rows.Err()
+}
+
+func (s *ProducerService) GetSeries(seriesId int, userId int) (*ResponseSeries, error) {
+	var series ResponseSeries
+
+	// Query
+	query := `SELECT s.id, s.name, COUNT(b.id), s.created_at FROM series s
+		LEFT JOIN books b ON b.series_id = s.id
+		WHERE s.id = ? AND s.user_id = ? GROUP BY s.id, s.name, s.created_at`
+
+	err := s.DB.QueryRowContext(context.Background(), query, seriesId, userId).Scan(&series.Id, &series.Name, &series.Books, &series.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, fiber.NewError(fiber.StatusNotFound, "Series not found")
+	}
+	if err != nil {
+		slog.Error("Eror while query", "err", err)
+		return nil, err
+	}
+	series.CreatedAt = series.CreatedAt.In(s.getUserLocation(userId))
+
+	return &series, nil
+}
+
+func (s *ProducerService) RenameSeries(seriesId int, userId int, req RequestSeries) error {
+	// Checks if the user has the series
+	_, err := s.GetSeries(seriesId, userId)
+	if err != nil {
+		return err
+	}
+
+	// Create a query
+	query := "UPDATE series SET name = ? WHERE id = ? AND user_id = ?"
+
+	_, err = s.DB.ExecContext(context.Background(), query, strings.TrimSpace(req.Name), seriesId, userId)
+	if err != nil {
+		if shared.IsDuplicateEntry(err) {
+			return errSeriesExists
+		}
+		slog.Error("Error while updating data", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+// DeleteSeries removes the series, the books themselves stay and just aren't in a series anymore
+func (s *ProducerService) DeleteSeries(seriesId int, userId int) (err error) {
+	// tx stuffs
+	tx, err := s.DB.Begin()
+	if err != nil {
+		slog.Error("Commit Rollback error", "err", err)
+		return err
+	}
+	defer func() {
+		err = shared.CommitOrRollback(tx, err)
+	}()
+
+	// The foreign key only clears series_id, a position outside a series means nothing
+	_, err = tx.ExecContext(context.Background(), "UPDATE books SET series_position = NULL WHERE series_id = ? AND user_id = ?", seriesId, userId)
+	if err != nil {
+		slog.Error("Error while updating data", "err", err)
+		return err
+	}
+
+	result, err := tx.ExecContext(context.Background(), "DELETE FROM series WHERE id = ? AND user_id = ?", seriesId, userId)
+	if err != nil {
+		slog.Error("Error while deleting data", "err", err)
+		return err
+	}
+
+	// checks the affected row to make sure if there is in fact deleted series
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return fiber.NewError(fiber.StatusNotFound, "Series not found")
+	}
+
+	return nil
+}
+
+// TAGS
+
+// normalizeTags trims the names and drops empty ones and duplicates, tag names are case-insensitive
+func normalizeTags(names []string) []string {
+	var tags []string
+	seen := map[string]bool{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+		tags = append(tags, name)
+	}
+
+	return tags
+}
+
+// parseTagList reads the comma separated tags query parameter
+func parseTagList(raw string) []string {
+	if raw == "" {
+		return nil
+	}
+
+	return normalizeTags(strings.Split(raw, ","))
+}
+
+// taggedWith is the condition for books carrying every one of the tags, column is the book id column
+func taggedWith(column string, userId int, tags []string) (string, []any) {
+	args := []any{userId}
+	for _, tag := range tags {
+		args = append(args, tag)
+	}
+	args = append(args, len(tags))
+
+	condition := fmt.Sprintf(`%s IN (SELECT bt.book_id FROM book_tags bt JOIN tags t ON t.id = bt.tag_id
+		WHERE t.user_id = ? AND t.name IN (%s) GROUP BY bt.book_id HAVING COUNT(DISTINCT t.id) = ?)`,
+		column, strings.TrimSuffix(strings.Repeat("?, ", len(tags)), ", "))
+
+	return condition, args
+}
+
+func (s *ProducerService) GetTags(userId int) ([]*ResponseTag, error) {
+	// Initialize var to place the tags
+	tags := []*ResponseTag{}
+
+	// Query
+	query := `SELECT t.id, t.name, COUNT(bt.book_id), t.created_at FROM tags t
+		LEFT JOIN book_tags bt ON bt.tag_id = t.id
+		WHERE t.user_id = ? GROUP BY t.id, t.name, t.created_at ORDER BY t.name`
+
+	rows, err := s.DB.QueryContext(context.Background(), query, userId)
+	if err != nil {
+		slog.Error("Eror while query", "err", err)
+		return nil, err
+	}
+
+	// close the rows of course
+	defer rows.Close()
+
+	// Times are shown in the user's timezone
+	loc := s.getUserLocation(userId)
+
+	// Foreach-ing queried rows
+	for rows.Next() {
+		var tag ResponseTag
+		err := rows.Scan(&tag.Id, &tag.Name, &tag.Books, &tag.CreatedAt)
+		if err != nil {
+			slog.Error("Error querying", "err", err)
+			return nil, err
+		}
+		tag.CreatedAt = tag.CreatedAt.In(loc)
+		tags = append(tags, &tag)
+	}
+
+	return tags, rows.Err()
+}
+
+func (s *ProducerService) CreateTag(userId int, req RequestTag) (int, error) {
+	// Crate the query
+	query := "INSERT INTO tags (user_id, name) VALUES (?, ?)"
+
+	result, err := s.DB.ExecContext(context.Background(), query, userId, strings.TrimSpace(req.Name))
+	if err != nil {
+		if shared.IsDuplicateEntry(err) {
+			return 0, errTagExists
+		}
+		slog.Error("Error while inserting data", "err", err)
+		return 0, err
+	}
+
+	// Get the last inserted ID
+	id, err := result.LastInsertId()
+	if err != nil {
+		slog.Error("Failed to get last insert ID", "err", err)
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+var errTagExists = fiber.NewError(fiber.StatusConflict, "You already have a tag with that name")
+
+func (s *ProducerService) RenameTag(tagId int, userId int, req RequestTag) error {
+	// Create a query
+	query := "UPDATE tags SET name = ? WHERE id = ? AND user_id = ?"
+
+	result, err := s.DB.ExecContext(context.Background(), query, strings.TrimSpace(req.Name), tagId, userId)
+	if err != nil {
+		if shared.IsDuplicateEntry(err) {
+			return errTagExists
+		}
+		slog.Error("Error while updating data", "err", err)
+		return err
+	}
+
+	// MySQL reports 0 rows when the name didn't change, so make sure the tag is actually missing
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		var exists bool
+		err = s.DB.QueryRowContext(context.Background(), "SELECT EXISTS(SELECT 1 FROM tags WHERE id = ? AND user_id = ?)", tagId, userId).Scan(&exists)
+		if err != nil {
+			slog.Error("Eror while query", "err", err)
+			return err
+		}
+		if !exists {
+			return fiber.NewError(fiber.StatusNotFound, "Tag not found")
+		}
+	}
+
+	return nil
+}
+
+// DeleteTag removes the tag, the books themselves stay and just lose it
+func (s *ProducerService) DeleteTag(tagId int, userId int) error {
+	// Create a query
+	query := "DELETE FROM tags WHERE id = ? AND user_id = ?"
+
+	result, err := s.DB.ExecContext(context.Background(), query, tagId, userId)
+	if err != nil {
+		slog.Error("Error while deleting data", "err", err)
+		return err
+	}
+
+	// checks the affected row to make sure if there is in fact deleted tag
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return fiber.NewError(fiber.StatusNotFound, "Tag not found")
+	}
+
+	return nil
+}
+
+// SetBookTags replaces the tags of a book, tags that don't exist yet get created on the way
+func (s *ProducerService) SetBookTags(bookId int, userId int, names []string) (tags []string, err error) {
+	// Checks if the user hold the book
+	_, err = s.GetBookById(bookId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	// tx stuffs
+	tx, err := s.DB.Begin()
+	if err != nil {
+		slog.Error("Commit Rollback error", "err", err)
+		return nil, err
+	}
+	defer func() {
+		err = shared.CommitOrRollback(tx, err)
+	}()
+
+	err = setBookTags(tx, userId, bookId, names)
+	if err != nil {
+		return nil, err
+	}
+
+	return normalizeTags(names), nil
+}
+
+// setBookTags does the work of SetBookTags inside the caller's transaction
+func setBookTags(tx *sql.Tx, userId int, bookId int, names []string) error {
+	tags := normalizeTags(names)
+
+	// IGNORE because most of them usually exist already, the UNIQUE key makes this a create-if-missing
+	for _, tag := range tags {
+		_, err := tx.ExecContext(context.Background(), "INSERT IGNORE INTO tags (user_id, name) VALUES (?, ?)", userId, tag)
+		if err != nil {
+			slog.Error("Error while inserting data", "err", err)
+			return err
+		}
+	}
+
+	_, err := tx.ExecContext(context.Background(), "DELETE FROM book_tags WHERE book_id = ?", bookId)
+	if err != nil {
+		slog.Error("Error while deleting data", "err", err)
+		return err
+	}
+
+	if len(tags) == 0 {
+		return nil
+	}
+
+	args := []any{bookId, userId}
+	for _, tag := range tags {
+		args = append(args, tag)
+	}
+	query := fmt.Sprintf("INSERT INTO book_tags (book_id, tag_id) SELECT ?, id FROM tags WHERE user_id = ? AND name IN (%s)",
+		strings.TrimSuffix(strings.Repeat("?, ", len(tags)), ", "))
+
+	_, err = tx.ExecContext(context.Background(), query, args...)
+	if err != nil {
+		slog.Error("Error while inserting data", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+// GetBookTags loads the tag names of many books in one query
+func (s *ProducerService) GetBookTags(bookIds []int) (map[int][]string, error) {
+	tags := map[int][]string{}
+	if len(bookIds) == 0 {
+		return tags, nil
+	}
+
+	args := make([]any, len(bookIds))
+	for i, id := range bookIds {
+		args[i] = id
+	}
+	query := fmt.Sprintf(`SELECT bt.book_id, t.name FROM book_tags bt JOIN tags t ON t.id = bt.tag_id
+		WHERE bt.book_id IN (%s) ORDER BY t.name`, strings.TrimSuffix(strings.Repeat("?, ", len(bookIds)), ", "))
+
+	rows, err := s.DB.QueryContext(context.Background(), query, args...)
+	if err != nil {
+		slog.Error("Eror while query", "err", err)
+		return nil, err
+	}
+
+	// close the rows of course
+	defer rows.Close()
+
+	for rows.Next() {
+		var bookId int
+		var name string
+		if err := rows.Scan(&bookId, &name); err != nil {
+			slog.Error("Error querying", "err", err)
+			return nil, err
+		}
+		tags[bookId] = append(tags[bookId], name)
+	}
+
+	return tags, rows.Err()
+}
+
+// FillBookTags sets the tags of a single book
+func (s *ProducerService) FillBookTags(book *ResponseGetBook) error {
+	tags, err := s.GetBookTags([]int{book.Id})
+	if err != nil {
+		return err
+	}
+
+	book.Tags = tags[book.Id]
+	if book.Tags == nil {
+		book.Tags = []string{}
+	}
+
+	return nil
+}
+
+// PROGRESSES
+func (s *ProducerService) CreateProgress(req RequestCreateProgress) (err error) {
+
+	// Acquire latest progress for validation purpose (make sure if the FROM_PAGE and UNTIl_PAGE is right)
+	previousProgress, err := s.getLatestProgress(req.BookId)
+	if err != nil {
+		return err
+	}
+
+	// Acquire the book to check if the page is maxed out or not
+	book, err := s.GetBookById(req.BookId, req.UserId)
+	if err != nil {
+		slog.Error("GetBookById")
+		return err
+	}
+
+	// checks if the book already finished?
+	if book.Status == "completed" {
+		return fiber.NewError(fiber.StatusBadRequest, "Sorry but you're already finished your book! Start a re-read to track it again")
+	}
+
+	// Progress only counts while reading, the other statuses have to go back to reading first
+	if book.Status != "reading" {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("The book is %s, set it back to reading before adding progress", book.Status))
+	}
+
+	// A book created from an ISBN may still wait for its page count
+	if book.TotalPages <= 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "The length of the book is not known yet, set its total pages first")
+	}
+
+	// The position is in the book's unit, until takes over until_page when both are there
+	untilPage, err := resolvePosition(book.Unit, req.Until, req.UntilPage)
+	if err != nil {
+		return err
+	}
+	if untilPage <= 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "The until page field is required")
+	}
+
+	// checks if it's exceeds book page
+	if untilPage > book.TotalPages {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Until exceeds the book's length of %s", book.Length))
+	}
+
+	// The progress goes on the current run
+	run, err := s.getCurrentRun(book.Id)
+	if err != nil {
+		return err
+	}
+
+	// checks if it's maxed out or nah
+	if book.TotalPages == untilPage {
+		// Set the book status to completed
+		err = s.SetBookStatus(book.Id, "completed")
+		if err != nil {
+			slog.Error("Calls setbookstatus")
+			return err
+		}
+
+		err = s.finishCurrentRun(book.Id, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Checks if the progress fulfilled a goal
+	goals, err := s.GetAllGoalsWithBookId(req.BookId, req.UserId)
+	if err != nil {
+		slog.Error("Calls GetAllGoals")
+		return err
+	}
+
+	// Checks all goals
+	for _, goal := range goals {
+		// Skip if goal's status finished, or it belongs to an earlier read
+		if goal.Status != "in-progress" || goal.RunId != run.Id {
+			continue
+		}
+		if untilPage >= goal.TargetPage {
+			// Set the goal status
+			err = s.SetGoalStatus("finished", goal.Id)
+			if err != nil {
+				return err
+			}
+
+			user, err := s.GetUser(strconv.Itoa(req.UserId))
+			if err != nil {
+				return err
+			}
+
+			msg := &shared.Msg{
+				Recipient:  user.Recipient(),
+				Id:         goal.Id,
+				Email:      user.Email,
+				Name:       goal.Name,
+				BookTitle:  book.Title,
+				CoverUrl:   book.ThumbnailUrl,
+				TargetPage: goal.TargetPage,
+				Target:     goal.Target,
+				ExpiredAt:  goal.ExpiredAt,
+			}
+
+			body, err := json.Marshal(msg)
+			if err != nil {
+				return err
 			}
 
 			// Send the message to exchange
@@ -353,23 +4624,36 @@ func (s *ProducerService) CreateProgress(req RequestCreateProgress) error {
 		fromPage = previousProgress.UntilPage
 	}
 
-	if previousProgress.UntilPage >= req.UntilPage {
+	if previousProgress.UntilPage >= untilPage {
 		return fiber.NewError(fiber.StatusBadRequest, "Current until_page is lesser or same as previous until_page, no improvement")
 	}
 
 	// Create a query
-	query := "INSERT INTO progresses(book_id, from_page, until_page, description) VALUES (?, ?, ?, ?)"
+	query := "INSERT INTO progresses(book_id, run_id, from_page, until_page, description) VALUES (?, ?, ?, ?, ?)"
 
 	// tx stuffs
 	tx, err := s.DB.Begin()
-	defer shared.CommitOrRollback(tx, err)
 	if err != nil {
 		slog.Error("Commit Rollback error", "err", err)
 		return err
 	}
+	defer func() {
+		err = shared.CommitOrRollback(tx, err)
+	}()
+
+	// An UpdateBook holds the book while it changes the length, the progress waits for it and checks against the new one
+	var totalPages int
+	err = tx.QueryRowContext(context.Background(), "SELECT total_pages FROM books WHERE id = ? FOR SHARE", req.BookId).Scan(&totalPages)
+	if err != nil {
+		slog.Error("Eror while query", "err", err)
+		return err
+	}
+	if untilPage > totalPages {
+		return fiber.NewError(fiber.StatusConflict, "The length of the book changed in the meantime, try again")
+	}
 
 	// Execute the query with ExecContext
-	_, err = tx.ExecContext(context.Background(), query, req.BookId, fromPage, req.UntilPage, req.Description)
+	_, err = tx.ExecContext(context.Background(), query, req.BookId, run.Id, fromPage, untilPage, req.Description)
 	if err != nil {
 		slog.Error("Error while inserting data", "err", err)
 		return err
@@ -400,12 +4684,76 @@ func (s *ProducerService) sendGoalMessage(body []byte) error {
 	return nil
 }
 
+// sendDeadlineMessage publishes the deadline message, the delayed exchange holds it until the delay passed
+func (s *ProducerService) sendDeadlineMessage(msg *shared.Msg, delay time.Duration, headers amqp091.Table) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	// Make agent
+	agent, err := shared.NewAgent(s.AMQP, context.Background())
+	if err != nil {
+		return err
+	}
+
+	if headers == nil {
+		headers = amqp091.Table{}
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	headers["x-delay"] = delay.Milliseconds() // delay in milliseconds
+
+	// Publish the message
+	err = agent.Publish(amqp091.Publishing{
+		DeliveryMode: amqp091.Persistent,
+		ContentType:  "application/json",
+		Body:         body,
+		Headers:      headers,
+	}, "goal_exchange", "deadline")
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// sendCancelledMessage tells the owner their goal got called off, it goes out right away
+func (s *ProducerService) sendCancelledMessage(msg *shared.Msg) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	// Make agent
+	agent, err := shared.NewAgent(s.AMQP, context.Background())
+	if err != nil {
+		return err
+	}
+
+	// Publish the message
+	err = agent.Publish(amqp091.Publishing{
+		DeliveryMode: amqp091.Persistent,
+		ContentType:  "application/json",
+		Body:         body,
+	}, "goal_exchange", "cancelled")
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (s *ProducerService) getLatestProgress(bookId int) (*Progress, error) {
 	// init some vars
 	var progress Progress
 
-	// Create a query
-	query := "SELECT * FROM progresses WHERE book_id = ? ORDER BY created_at DESC LIMIT 1"
+	// Create a query, only the current run counts, a re-read starts again from the first page
+	query := `SELECT id, book_id, run_id, from_page, until_page, description, created_at FROM progresses
+		WHERE run_id = (SELECT MAX(id) FROM reading_runs WHERE book_id = ?) ORDER BY created_at DESC, id DESC LIMIT 1`
 
 	// tx stuffs
 	tx, err := s.DB.Begin()
@@ -419,6 +4767,7 @@ func (s *ProducerService) getLatestProgress(bookId int) (*Progress, error) {
 	err = tx.QueryRowContext(context.Background(), query, bookId).Scan(
 		&progress.Id,
 		&progress.BookId,
+		&progress.RunId,
 		&progress.FromPage,
 		&progress.UntilPage,
 		&progress.Description,
@@ -435,25 +4784,69 @@ func (s *ProducerService) getLatestProgress(bookId int) (*Progress, error) {
 	return &progress, nil
 }
 
-func (s *ProducerService) GetAllProgressByBookId(bookId int) ([]*ResponseGetProgress, error) {
+// progressSorts maps the sort parameter to the column progresses are ordered by
+var progressSorts = map[string]string{
+	"created":    "p.created_at",
+	"until_page": "p.until_page",
+}
+
+func (s *ProducerService) GetAllProgressByBookId(bookId int, userId int, req RequestListProgresses) ([]*ResponseGetProgress, *string, error) {
 	var progresses []*ResponseGetProgress
 
+	sort := req.Sort
+	if sort == "" {
+		sort = "created"
+	}
+	desc := req.Order == "desc"
+	limit := pageSize(req.Limit)
+
+	cursor, err := decodeCursor(req.Cursor, sort, desc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Dates in the range are the user's own days
+	loc := s.getUserLocation(userId)
+
+	// Filters
+	q := &listQuery{}
+	q.where("p.book_id = ? AND b.user_id = ?", bookId, userId)
+	if req.RunId != 0 {
+		q.where("p.run_id = ?", req.RunId)
+	}
+	if req.From != "" {
+		from, err := shared.ParseUserTime(req.From)
+		if err != nil {
+			return nil, nil, err
+		}
+		q.where("p.created_at >= ?", from.StartIn(loc))
+	}
+	if req.To != "" {
+		to, err := shared.ParseUserTime(req.To)
+		if err != nil {
+			return nil, nil, err
+		}
+		q.where("p.created_at <= ?", to.In(loc))
+	}
+
 	// Create a query
-	query := "SELECT id, from_page, until_page, created_at, description FROM progresses WHERE book_id = ?"
+	query, args := q.build(`SELECT p.id, p.run_id, p.from_page, p.until_page, p.created_at, p.description, b.unit, b.total_pages
+		FROM progresses p JOIN books b ON b.id = p.book_id`,
+		keyset{column: progressSorts[sort], idColumn: "p.id", desc: desc}, cursor, limit)
 
 	// tx stuffs
 	tx, err := s.DB.Begin()
 	defer shared.CommitOrRollback(tx, err)
 	if err != nil {
 		slog.Error("Commit Rollback error", "err", err)
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Query
-	rows, err := tx.QueryContext(context.Background(), query, bookId)
+	rows, err := tx.QueryContext(context.Background(), query, args...)
 	if err != nil {
 		slog.Error("Eror while query", "err", err)
-		return nil, err
+		return nil, nil, err
 	}
 
 	// close the rows of course
@@ -462,15 +4855,35 @@ func (s *ProducerService) GetAllProgressByBookId(bookId int) ([]*ResponseGetProg
 	// Foreach-ing queried rows
 	for rows.Next() {
 		var progress ResponseGetProgress
-		err := rows.Scan(&progress.Id, &progress.FromPage, &progress.UntilPage, &progress.CreatedAt, &progress.Description)
+		var unit string
+		var totalPages int
+		err := rows.Scan(&progress.Id, &progress.RunId, &progress.FromPage, &progress.UntilPage, &progress.CreatedAt, &progress.Description, &unit, &totalPages)
 		if err != nil {
 			slog.Error("Error querying", "err", err)
-			return nil, err
+			return nil, nil, err
 		}
+		progress.From = formatPosition(unit, progress.FromPage)
+		progress.Until = formatPosition(unit, progress.UntilPage)
+		progress.Percent = percentOf(progress.UntilPage, totalPages)
 		progresses = append(progresses, &progress)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	progresses, next := nextCursor(progresses, limit, sort, desc, func(progress *ResponseGetProgress) (string, int) {
+		if sort == "until_page" {
+			return strconv.Itoa(progress.UntilPage), progress.Id
+		}
+		return progress.CreatedAt.UTC().Format(time.DateTime), progress.Id
+	})
+
+	// Times are shown in the user's timezone, only after the cursor took the UTC value
+	for _, progress := range progresses {
+		progress.CreatedAt = progress.CreatedAt.In(loc)
+	}
 
-	return progresses, err
+	return progresses, next, nil
 }
 
 func (s *ProducerService) DeleteLatestProgress(bookId int, userId int) error {
@@ -499,6 +4912,12 @@ func (s *ProducerService) DeleteLatestProgress(bookId int, userId int) error {
 		if err != nil {
 			return err
 		}
+
+		// and the run it finished isn't finished anymore
+		err = s.finishCurrentRun(bookId, false)
+		if err != nil {
+			return err
+		}
 	}
 
 	// query
@@ -522,6 +4941,84 @@ func (s *ProducerService) DeleteLatestProgress(bookId int, userId int) error {
 	return nil
 }
 
+// SEARCH
+
+// Search looks for the terms in the user's book titles, authors and progress descriptions.
+// Both go through MySQL FULLTEXT indexes and the hits come back best match first.
+func (s *ProducerService) Search(userId int, req RequestSearch) ([]*ResponseSearchHit, error) {
+	hits := []*ResponseSearchHit{}
+
+	terms := searchTerms(req.Q)
+	if len(terms) == 0 {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Search query has no words to look for")
+	}
+	against := booleanQuery(terms)
+
+	limit := req.Limit
+	if limit == 0 {
+		limit = 20
+	}
+
+	bookQuery := `SELECT 'book', b.id, 0, b.title, b.author, '', NULL, MATCH(b.title, b.author) AGAINST (? IN BOOLEAN MODE) AS score
+		FROM books b WHERE b.user_id = ? AND MATCH(b.title, b.author) AGAINST (? IN BOOLEAN MODE)`
+	progressQuery := `SELECT 'progress', b.id, p.id, b.title, b.author, p.description, p.created_at, MATCH(p.description) AGAINST (? IN BOOLEAN MODE) AS score
+		FROM progresses p JOIN books b ON b.id = p.book_id WHERE b.user_id = ? AND MATCH(p.description) AGAINST (? IN BOOLEAN MODE)`
+
+	// Create a query
+	var parts []string
+	var args []any
+	if req.Type != "progresses" {
+		parts = append(parts, bookQuery)
+		args = append(args, against, userId, against)
+	}
+	if req.Type != "books" {
+		parts = append(parts, progressQuery)
+		args = append(args, against, userId, against)
+	}
+	query := strings.Join(parts, " UNION ALL ") + " ORDER BY score DESC LIMIT ? OFFSET ?"
+	args = append(args, limit, req.Offset)
+
+	rows, err := s.DB.QueryContext(context.Background(), query, args...)
+	if err != nil {
+		slog.Error("Eror while query", "err", err)
+		return nil, err
+	}
+
+	// close the rows of course
+	defer rows.Close()
+
+	// Times are shown in the user's timezone
+	loc := s.getUserLocation(userId)
+
+	// Foreach-ing queried rows
+	for rows.Next() {
+		var hit ResponseSearchHit
+		var progressId int
+		var description string
+		var createdAt sql.NullTime
+		err := rows.Scan(&hit.Type, &hit.BookId, &progressId, &hit.Title, &hit.Author, &description, &createdAt, &hit.Score)
+		if err != nil {
+			slog.Error("Error querying", "err", err)
+			return nil, err
+		}
+
+		if hit.Type == "progress" {
+			hit.ProgressId = &progressId
+			hit.Snippet = highlight(description, terms, snippetRunes)
+		} else {
+			hit.Snippet = highlight(hit.Title+" by "+hit.Author, terms, 0)
+		}
+		if createdAt.Valid {
+			createdAt.Time = createdAt.Time.In(loc)
+			hit.CreatedAt = &createdAt.Time
+		}
+
+		hits = append(hits, &hit)
+	}
+
+	return hits, rows.Err()
+}
+
 // GOALS
 
 func (s *ProducerService) CreateGoal(req *RequestCreateGoal) error {
@@ -536,24 +5033,62 @@ func (s *ProducerService) CreateGoal(req *RequestCreateGoal) error {
 		return fiber.NewError(fiber.StatusBadRequest, "Book already finished, nothing to chase bro")
 	}
 
+	// Abandoned books won't get read, a goal on them could only expire
+	if book.Status == "abandoned" {
+		return fiber.NewError(fiber.StatusBadRequest, "Book is abandoned, pick it up again before setting a goal")
+	}
+
+	// Find a user first, for the email and the timezone
+	user, err := s.GetUser(strconv.Itoa(req.UserId))
+	if err != nil {
+		return err
+	}
+
+	// Goal emails would otherwise go to an inbox nobody proved they own
+	if shared.NewConfig().GetBool("REQUIRE_VERIFIED_EMAIL") && !user.VerifiedAt.Valid {
+		return fiber.NewError(fiber.StatusForbidden, "Please verify your email before creating a goal")
+	}
+
 	// acquire latest progress
 	progress, err := s.getLatestProgress(book.Id)
 	if err != nil {
 		return err
 	}
 
+	// The target is in the book's unit, target takes over target_page when both are there
+	targetPage, err := resolvePosition(book.Unit, req.Target, req.TargetPage)
+	if err != nil {
+		return err
+	}
+	if targetPage <= 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "The target page field is required")
+	}
+	if targetPage > book.TotalPages {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Target exceeds the book's length of %s", book.Length))
+	}
+
 	// Checks if the target page exceeds book latest progress.
-	if progress.UntilPage >= req.TargetPage {
+	if progress.UntilPage >= targetPage {
 		return fiber.NewError(fiber.StatusBadRequest, "Your target already fulfilled or maybe exceeds your latest progress")
 	}
 
-	// Validate the expired_time
-	if !time.Now().Before(req.ExpiredAt) {
+	// Validate the expired_time, a time without offset is the user's own wall clock
+	if req.ExpiredAt.IsZero() {
+		return fiber.NewError(fiber.StatusBadRequest, "The expired at field is required")
+	}
+	expiredAt := req.ExpiredAt.In(user.Location())
+	if !time.Now().Before(expiredAt) {
 		return fiber.NewError(fiber.StatusBadRequest, "Expired Time is invalid")
 	}
 
+	// The goal is about the current run, a re-read gets goals of its own
+	run, err := s.getCurrentRun(book.Id)
+	if err != nil {
+		return err
+	}
+
 	// Create a query
-	query := "INSERT INTO goals (book_id, user_id, name, target_page, expired_at) VALUES (?, ?, ?, ?, ?)"
+	query := "INSERT INTO goals (book_id, run_id, user_id, name, target_page, expired_at) VALUES (?, ?, ?, ?, ?, ?)"
 
 	// tx stuffs
 	tx, err := s.DB.Begin()
@@ -566,10 +5101,11 @@ func (s *ProducerService) CreateGoal(req *RequestCreateGoal) error {
 	// Execute the query with ExecContext
 	result, err := tx.ExecContext(context.Background(), query,
 		req.BookId,
+		run.Id,
 		req.UserId,
 		req.Name,
-		req.TargetPage,
-		req.ExpiredAt)
+		targetPage,
+		expiredAt)
 
 	if err != nil {
 		slog.Error("Error while inserting data", "err", err)
@@ -581,47 +5117,25 @@ func (s *ProducerService) CreateGoal(req *RequestCreateGoal) error {
 		return err
 	}
 
-	// Find a user first to get the email
-	user, err := s.GetUser(strconv.Itoa(req.UserId))
-	if err != nil {
-		return err
-	}
-
 	// Crafts a body
 	msg := &shared.Msg{
+		Recipient:  user.Recipient(),
 		Id:         int(lastInsertId),
 		Email:      user.Email,
 		Name:       req.Name,
 		BookTitle:  book.Title,
-		TargetPage: req.TargetPage,
-		ExpiredAt:  req.ExpiredAt,
+		CoverUrl:   book.ThumbnailUrl,
+		TargetPage: targetPage,
+		Target:     formatPosition(book.Unit, targetPage),
+		ExpiredAt:  expiredAt,
 	}
 
 	// Craft a delay time first, expired_time - now
 	delay := time.Until(msg.ExpiredAt)
 	slog.Info(strconv.Itoa(int(delay.Milliseconds())))
 
-	body, err := json.Marshal(msg)
-	if err != nil {
-		return err
-	}
-
 	// Make agent to send delayed message to queue.
-	agent, err := shared.NewAgent(s.AMQP, context.Background())
-	if err != nil {
-		return err
-	}
-
-	// Publish the message
-	err = agent.Publish(amqp091.Publishing{
-		DeliveryMode: amqp091.Persistent,
-		ContentType:  "application/json",
-		Body:         body,
-		Headers: amqp091.Table{
-			"x-delay": delay.Milliseconds(), // delay in milliseconds
-		},
-	}, "goal_exchange", "deadline")
-
+	err = s.sendDeadlineMessage(msg, delay, nil)
 	if err != nil {
 		return err
 	}
@@ -631,7 +5145,8 @@ func (s *ProducerService) CreateGoal(req *RequestCreateGoal) error {
 
 func (s *ProducerService) GetAllGoalsWithBookId(bookId int, userId int) ([]*ResponseGetGoal, error) {
 	// Checks if the BookId exists
-	query := "SELECT id, name, target_page, status, expired_at FROM goals WHERE book_id && user_id = ?"
+	query := `SELECT g.id, g.book_id, g.run_id, g.name, g.target_page, g.status, g.expired_at, b.unit, b.total_pages
+		FROM goals g JOIN books b ON b.id = g.book_id WHERE g.book_id = ? AND g.user_id = ?`
 
 	// Initialize var to place the book
 	var goals []*ResponseGetGoal
@@ -654,19 +5169,31 @@ func (s *ProducerService) GetAllGoalsWithBookId(bookId int, userId int) ([]*Resp
 	// close the rows of course
 	defer rows.Close()
 
+	// Times are shown in the user's timezone
+	loc := s.getUserLocation(userId)
+
 	for rows.Next() {
 		var goal ResponseGetGoal
+		var unit string
+		var totalPages int
 		err := rows.Scan(
 			&goal.Id,
+			&goal.BookId,
+			&goal.RunId,
 			&goal.Name,
 			&goal.TargetPage,
 			&goal.Status,
 			&goal.ExpiredAt,
+			&unit,
+			&totalPages,
 		)
 		if err != nil {
 			slog.Error("Error Querying")
 			return goals, err
 		}
+		goal.Target = formatPosition(unit, goal.TargetPage)
+		goal.Percent = percentOf(goal.TargetPage, totalPages)
+		goal.ExpiredAt = goal.ExpiredAt.In(loc)
 		goals = append(goals, &goal)
 	}
 
@@ -674,7 +5201,7 @@ func (s *ProducerService) GetAllGoalsWithBookId(bookId int, userId int) ([]*Resp
 }
 
 func (s *ProducerService) SetGoalStatus(status string, goalId int) error {
-	if status != "finished" && status != "expired" {
+	if status != "finished" && status != "expired" && status != "cancelled" {
 		slog.Error("Status invalid")
 		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
 	}
@@ -710,26 +5237,77 @@ func (s *ProducerService) SetGoalStatus(status string, goalId int) error {
 	return nil
 }
 
-func (s *ProducerService) GetAllGoals(userId int) ([]*ResponseGetGoal, error) {
-	// Checks if the BookId exists
-	query := "SELECT id, name, target_page, status, expired_at FROM goals WHERE user_id = ?"
+// goalSorts maps the sort parameter to the column goals are ordered by
+var goalSorts = map[string]string{
+	"added":       "g.id",
+	"expired_at":  "g.expired_at",
+	"target_page": "g.target_page",
+}
 
+func (s *ProducerService) GetAllGoals(userId int, req RequestListGoals) ([]*ResponseGetGoal, *string, error) {
 	// Initialize var to place the book
 	var goals []*ResponseGetGoal
 
+	sort := req.Sort
+	if sort == "" {
+		sort = "added"
+	}
+	desc := req.Order == "desc"
+	limit := pageSize(req.Limit)
+
+	cursor, err := decodeCursor(req.Cursor, sort, desc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Dates in the range are the user's own days
+	loc := s.getUserLocation(userId)
+
+	// Filters
+	q := &listQuery{}
+	q.where("g.user_id = ?", userId)
+	if req.Status != "" {
+		q.where("g.status = ?", req.Status)
+	}
+	if req.BookId != 0 {
+		q.where("g.book_id = ?", req.BookId)
+	}
+	if req.RunId != 0 {
+		q.where("g.run_id = ?", req.RunId)
+	}
+	if req.ExpiredFrom != "" {
+		from, err := shared.ParseUserTime(req.ExpiredFrom)
+		if err != nil {
+			return nil, nil, err
+		}
+		q.where("g.expired_at >= ?", from.StartIn(loc))
+	}
+	if req.ExpiredTo != "" {
+		to, err := shared.ParseUserTime(req.ExpiredTo)
+		if err != nil {
+			return nil, nil, err
+		}
+		q.where("g.expired_at <= ?", to.In(loc))
+	}
+
+	// Query
+	query, args := q.build(`SELECT g.id, g.book_id, g.run_id, g.name, g.target_page, g.status, g.expired_at, b.unit, b.total_pages
+		FROM goals g JOIN books b ON b.id = g.book_id`,
+		keyset{column: goalSorts[sort], idColumn: "g.id", desc: desc}, cursor, limit)
+
 	// tx stuffs
 	tx, err := s.DB.Begin()
 	defer shared.CommitOrRollback(tx, err)
 	if err != nil {
 		slog.Error("Commit Rollback error", "err", err)
-		return goals, err
+		return nil, nil, err
 	}
 
 	// Query
-	rows, err := tx.QueryContext(context.Background(), query, userId)
+	rows, err := tx.QueryContext(context.Background(), query, args...)
 	if err != nil {
 		slog.Error("Eror while query", "err", err)
-		return goals, err
+		return nil, nil, err
 	}
 
 	// close the rows of course
@@ -737,19 +5315,510 @@ func (s *ProducerService) GetAllGoals(userId int) ([]*ResponseGetGoal, error) {
 
 	for rows.Next() {
 		var goal ResponseGetGoal
+		var unit string
+		var totalPages int
 		err := rows.Scan(
 			&goal.Id,
+			&goal.BookId,
+			&goal.RunId,
 			&goal.Name,
 			&goal.TargetPage,
 			&goal.Status,
 			&goal.ExpiredAt,
+			&unit,
+			&totalPages,
 		)
 		if err != nil {
 			slog.Error("Error Querying")
-			return goals, err
+			return nil, nil, err
 		}
+		goal.Target = formatPosition(unit, goal.TargetPage)
+		goal.Percent = percentOf(goal.TargetPage, totalPages)
 		goals = append(goals, &goal)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
 
-	return goals, nil
+	goals, next := nextCursor(goals, limit, sort, desc, func(goal *ResponseGetGoal) (string, int) {
+		switch sort {
+		case "expired_at":
+			return goal.ExpiredAt.UTC().Format(time.DateTime), goal.Id
+		case "target_page":
+			return strconv.Itoa(goal.TargetPage), goal.Id
+		}
+		return strconv.Itoa(goal.Id), goal.Id
+	})
+
+	// Times are shown in the user's timezone, only after the cursor took the UTC value
+	for _, goal := range goals {
+		goal.ExpiredAt = goal.ExpiredAt.In(loc)
+	}
+
+	return goals, next, nil
+}
+
+// STATS
+
+// GetStats sums up the user's reading, overall and per tag.
+// With tags given, only the books carrying all of them are counted.
+// Pages only add up for books counted in pages, the percents are what compare across formats.
+func (s *ProducerService) GetStats(userId int, req RequestStats) (*ResponseStats, error) {
+	stats := &ResponseStats{
+		BooksByStatus: map[string]int{},
+		BooksByFormat: map[string]int{},
+		GoalsByStatus: map[string]int{},
+		Rereads:       &ResponseRereadStats{},
+		Tags:          []*ResponseTagStats{},
+	}
+
+	// Which books count
+	bookFilter := "b.user_id = ?"
+	filterArgs := []any{userId}
+	if tags := parseTagList(req.Tags); len(tags) > 0 {
+		condition, args := taggedWith("b.id", userId, tags)
+		bookFilter += " AND " + condition
+		filterArgs = append(filterArgs, args...)
+	}
+
+	// The furthest page of every run, summed up per book, that's how much of it got read
+	pagesRead := `LEFT JOIN (SELECT book_id, SUM(pages) AS pages, SUM(entries) AS entries FROM
+		(SELECT book_id, MAX(until_page) AS pages, COUNT(*) AS entries FROM progresses GROUP BY book_id, run_id) runs
+		GROUP BY book_id) pr ON pr.book_id = b.id`
+	// what that comes to in pages and in percent of the book, pr.pages is in the book's own unit
+	sumPages := "COALESCE(SUM(IF(b.unit = 'pages', pr.pages, 0)), 0)"
+	sumPercent := "COALESCE(SUM(pr.pages * 100 / b.total_pages), 0)"
+
+	// Books per status and format, with the pages read and the progress entries
+	query := fmt.Sprintf(`SELECT b.status, b.format, COUNT(*), %s, %s, COALESCE(SUM(pr.entries), 0)
+		FROM books b %s WHERE %s GROUP BY b.status, b.format`, sumPages, sumPercent, pagesRead, bookFilter)
+	rows, err := s.DB.QueryContext(context.Background(), query, filterArgs...)
+	if err != nil {
+		slog.Error("Eror while query", "err", err)
+		return nil, err
+	}
+	for rows.Next() {
+		var status, format string
+		var books, pages, entries int
+		var percent float64
+		if err := rows.Scan(&status, &format, &books, &pages, &percent, &entries); err != nil {
+			rows.Close()
+			slog.Error("Error querying", "err", err)
+			return nil, err
+		}
+		stats.Books += books
+		stats.BooksByStatus[status] += books
+		stats.BooksByFormat[format] += books
+		stats.PagesRead += pages
+		stats.PercentRead += percent
+		stats.Progresses += entries
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	stats.PercentRead = math.Round(stats.PercentRead*100) / 100
+
+	// Re-reads, every run after the first one of the counted books
+	query = fmt.Sprintf(`SELECT COUNT(*), COUNT(r.finished_at), COALESCE(SUM(IF(b.unit = 'pages', rp.pages, 0)), 0),
+		COALESCE(SUM(rp.pages * 100 / b.total_pages), 0)
+		FROM reading_runs r JOIN books b ON b.id = r.book_id
+		LEFT JOIN (SELECT run_id, MAX(until_page) AS pages FROM progresses GROUP BY run_id) rp ON rp.run_id = r.id
+		WHERE r.number > 1 AND %s`, bookFilter)
+	err = s.DB.QueryRowContext(context.Background(), query, filterArgs...).Scan(&stats.Rereads.Started, &stats.Rereads.Completed, &stats.Rereads.PagesRead, &stats.Rereads.PercentRead)
+	if err != nil {
+		slog.Error("Eror while query", "err", err)
+		return nil, err
+	}
+	stats.Rereads.PercentRead = math.Round(stats.Rereads.PercentRead*100) / 100
+
+	// Goals of the counted books
+	query = fmt.Sprintf("SELECT g.status, COUNT(*) FROM goals g JOIN books b ON b.id = g.book_id WHERE %s GROUP BY g.status", bookFilter)
+	rows, err = s.DB.QueryContext(context.Background(), query, filterArgs...)
+	if err != nil {
+		slog.Error("Eror while query", "err", err)
+		return nil, err
+	}
+	for rows.Next() {
+		var status string
+		var goals int
+		if err := rows.Scan(&status, &goals); err != nil {
+			rows.Close()
+			slog.Error("Error querying", "err", err)
+			return nil, err
+		}
+		stats.GoalsByStatus[status] = goals
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Per tag breakdown, a book with several tags counts once under each of them
+	query = fmt.Sprintf(`SELECT t.id, t.name, b.status, COUNT(b.id), %s, %s
+		FROM tags t JOIN book_tags bt ON bt.tag_id = t.id JOIN books b ON b.id = bt.book_id %s
+		WHERE %s GROUP BY t.id, t.name, b.status ORDER BY t.name`, sumPages, sumPercent, pagesRead, bookFilter)
+	rows, err = s.DB.QueryContext(context.Background(), query, filterArgs...)
+	if err != nil {
+		slog.Error("Eror while query", "err", err)
+		return nil, err
+	}
+
+	// close the rows of course
+	defer rows.Close()
+
+	byTag := map[int]*ResponseTagStats{}
+	for rows.Next() {
+		var id, books, pages int
+		var name, status string
+		var percent float64
+		if err := rows.Scan(&id, &name, &status, &books, &pages, &percent); err != nil {
+			slog.Error("Error querying", "err", err)
+			return nil, err
+		}
+
+		tag, ok := byTag[id]
+		if !ok {
+			tag = &ResponseTagStats{Id: id, Name: name, BooksByStatus: map[string]int{}}
+			byTag[id] = tag
+			stats.Tags = append(stats.Tags, tag)
+		}
+		tag.Books += books
+		tag.BooksByStatus[status] = books
+		tag.PagesRead += pages
+		tag.PercentRead = math.Round((tag.PercentRead+percent)*100) / 100
+	}
+
+	return stats, rows.Err()
+}
+
+// IMPORTS
+
+// getImportMaxBytes is the biggest export accepted, serverBodyLimit grows with it
+func getImportMaxBytes() int64 {
+	maxBytes := shared.NewConfig().GetInt64("IMPORT_MAX_BYTES")
+	if maxBytes <= 0 {
+		return 2 * 1024 * 1024
+	}
+
+	return maxBytes
+}
+
+var errImportRunning = fiber.NewError(fiber.StatusConflict, "An import is already running, wait for it to finish")
+
+// CreateImportJob queues a Goodreads or StoryGraph export, the consumer imports it and mails a summary when done.
+// The file is checked here first, so a wrong file fails now instead of in an email later.
+func (s *ProducerService) CreateImportJob(userId int, payload []byte) (*ResponseImportJob, error) {
+	source, books, err := shared.ParseReadingHistory(bytes.NewReader(payload), time.UTC)
+	if errors.Is(err, shared.ErrUnknownImportFormat) {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "The file is not a Goodreads or StoryGraph CSV export")
+	}
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("The CSV file could not be read: %s", err))
+	}
+	if len(books) == 0 {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "The export has no books in it")
+	}
+
+	// Find a user first, the summary goes to their email
+	user, err := s.GetUser(strconv.Itoa(userId))
+	if err != nil {
+		return nil, err
+	}
+
+	// A job the consumer lost, by crashing mid import or losing the message, would block imports forever.
+	// Past IMPORT_STALE_AFTER it counts as failed.
+	stale := time.Now().Add(-shared.GetDuration("IMPORT_STALE_AFTER", time.Hour))
+	query := `UPDATE import_jobs SET status = 'failed', error = 'The import stopped answering, importing the same file again skips the books already imported',
+		payload = NULL, finished_at = ? WHERE user_id = ? AND ((status = 'queued' AND created_at < ?) OR (status = 'running' AND started_at < ?))`
+	_, err = s.DB.ExecContext(context.Background(), query, time.Now(), userId, stale, stale)
+	if err != nil {
+		slog.Error("Error while updating data", "err", err)
+		return nil, err
+	}
+
+	// One import at a time, two running side by side would both take the same book for a new one
+	var running bool
+	query = "SELECT EXISTS(SELECT 1 FROM import_jobs WHERE user_id = ? AND status IN ('queued', 'running'))"
+	err = s.DB.QueryRowContext(context.Background(), query, userId).Scan(&running)
+	if err != nil {
+		slog.Error("Eror while query", "err", err)
+		return nil, err
+	}
+	if running {
+		return nil, errImportRunning
+	}
+
+	// Create a query
+	query = "INSERT INTO import_jobs (user_id, source, payload, total_rows) VALUES (?, ?, ?, ?)"
+
+	result, err := s.DB.ExecContext(context.Background(), query, userId, source, string(payload), len(books))
+	if err != nil {
+		slog.Error("Error while inserting data", "err", err)
+		return nil, err
+	}
+
+	jobId, err := result.LastInsertId()
+	if err != nil {
+		slog.Error("Failed to get last insert ID", "err", err)
+		return nil, err
+	}
+
+	err = s.publishImportMessage(&shared.ImportMsg{
+		Recipient: user.Recipient(),
+		JobId:     int(jobId),
+		Email:     user.Email,
+	})
+	if err != nil {
+		// Nobody is going to pick the job up, don't leave it blocking the next import
+		_, markErr := s.DB.ExecContext(context.Background(), "UPDATE import_jobs SET status = 'failed', error = 'Could not be queued', payload = NULL WHERE id = ?", jobId)
+		if markErr != nil {
+			slog.Error("Error while updating data", "err", markErr)
+		}
+		return nil, err
+	}
+
+	return s.GetImportJob(int(jobId), userId)
+}
+
+// publishImportMessage hands the job over to the consumer
+func (s *ProducerService) publishImportMessage(msg *shared.ImportMsg) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	// Make agent
+	agent, err := shared.NewAgent(s.AMQP, context.Background())
+	if err != nil {
+		return err
+	}
+	defer agent.Channel.Close()
+
+	// Make agent work! Publish a message
+	return agent.Publish(amqp091.Publishing{
+		DeliveryMode: amqp091.Persistent,
+		ContentType:  "application/json",
+		Body:         body,
+	}, "import_exchange", "import")
+}
+
+func (s *ProducerService) GetImportJob(jobId int, userId int) (*ResponseImportJob, error) {
+	var job ResponseImportJob
+	var failures sql.NullString
+	var startedAt, finishedAt sql.NullTime
+
+	// Create a query
+	query := `SELECT id, source, status, total_rows, imported, skipped, failed, failures, error, created_at, started_at, finished_at
+		FROM import_jobs WHERE id = ? AND user_id = ?`
+
+	err := s.DB.QueryRowContext(context.Background(), query, jobId, userId).Scan(
+		&job.Id,
+		&job.Source,
+		&job.Status,
+		&job.TotalRows,
+		&job.Imported,
+		&job.Skipped,
+		&job.Failed,
+		&failures,
+		&job.Error,
+		&job.CreatedAt,
+		&startedAt,
+		&finishedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fiber.NewError(fiber.StatusNotFound, "Import not found")
+		}
+		slog.Error("Eror while query", "err", err)
+		return nil, err
+	}
+
+	job.Failures = []string{}
+	if failures.Valid {
+		if err := json.Unmarshal([]byte(failures.String), &job.Failures); err != nil {
+			return nil, err
+		}
+	}
+
+	// Times are shown in the user's timezone
+	loc := s.getUserLocation(userId)
+	job.CreatedAt = job.CreatedAt.In(loc)
+	if startedAt.Valid {
+		t := startedAt.Time.In(loc)
+		job.StartedAt = &t
+	}
+	if finishedAt.Valid {
+		t := finishedAt.Time.In(loc)
+		job.FinishedAt = &t
+	}
+
+	return &job, nil
+}
+
+// EXPORTS
+
+// GetLibrary loads the books of a library export with their reading log, in the order they were started.
+// With a date range only the books with a progress or a finished read in it are kept, and only the progresses in it.
+func (s *ProducerService) GetLibrary(userId int, req RequestExportLibrary) ([]*ExportLibraryBook, error) {
+	books := []*ExportLibraryBook{}
+	loc := s.getUserLocation(userId)
+
+	var from, to *time.Time
+	if req.From != "" {
+		parsed, err := shared.ParseUserTime(req.From)
+		if err != nil {
+			return nil, err
+		}
+		start := parsed.StartIn(loc)
+		from = &start
+	}
+	if req.To != "" {
+		parsed, err := shared.ParseUserTime(req.To)
+		if err != nil {
+			return nil, err
+		}
+		end := parsed.In(loc)
+		to = &end
+	}
+
+	// Filters
+	q := &listQuery{}
+	q.where("b.user_id = ?", userId)
+	if req.Status != "" {
+		q.where("b.status = ?", req.Status)
+	}
+	if from != nil || to != nil {
+		progressRange, progressArgs := between("p.created_at", from, to)
+		runRange, runArgs := between("r.finished_at", from, to)
+		q.where(fmt.Sprintf(`(b.id IN (SELECT p.book_id FROM progresses p WHERE %s)
+			OR b.id IN (SELECT r.book_id FROM reading_runs r WHERE %s))`, progressRange, runRange),
+			append(progressArgs, runArgs...)...)
+	}
+
+	// Create a query
+	query := fmt.Sprintf(`SELECT b.id, b.title, b.author, b.isbn, b.cover_key, b.thumbnail_key, b.total_pages, b.format, b.unit, b.status,
+		MIN(r.started_at), MAX(r.finished_at), COUNT(r.finished_at)
+		FROM books b LEFT JOIN reading_runs r ON r.book_id = b.id
+		WHERE %s
+		GROUP BY b.id, b.title, b.author, b.isbn, b.cover_key, b.thumbnail_key, b.total_pages, b.format, b.unit, b.status
+		ORDER BY MIN(r.started_at), b.id`, strings.Join(q.conditions, " AND "))
+
+	rows, err := s.DB.QueryContext(context.Background(), query, q.args...)
+	if err != nil {
+		slog.Error("Eror while query", "err", err)
+		return nil, err
+	}
+
+	// close the rows of course
+	defer rows.Close()
+
+	byId := map[int]*ExportLibraryBook{}
+	var bookIds []int
+	for rows.Next() {
+		var book ExportLibraryBook
+		var addedAt, finishedAt sql.NullTime
+		var coverKey, thumbnailKey sql.NullString
+		err := rows.Scan(&book.Id, &book.Title, &book.Author, &book.Isbn, &coverKey, &thumbnailKey, &book.TotalPages, &book.Format, &book.Unit, &book.Status,
+			&addedAt, &finishedAt, &book.ReadCount)
+		if err != nil {
+			slog.Error("Error querying", "err", err)
+			return nil, err
+		}
+		book.CoverUrl = coverURL(coverKey)
+		book.ThumbnailUrl = coverURL(thumbnailKey)
+		book.Length = formatPosition(book.Unit, book.TotalPages)
+		if addedAt.Valid {
+			t := addedAt.Time.In(loc)
+			book.AddedAt = &t
+		}
+		if finishedAt.Valid {
+			t := finishedAt.Time.In(loc)
+			book.FinishedAt = &t
+		}
+		book.Tags = []string{}
+		book.Progresses = []*ExportLibraryProgress{}
+
+		books = append(books, &book)
+		byId[book.Id] = &book
+		bookIds = append(bookIds, book.Id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(books) == 0 {
+		return books, nil
+	}
+
+	// calls service for the tags
+	tags, err := s.GetBookTags(bookIds)
+	if err != nil {
+		return nil, err
+	}
+	for bookId, names := range tags {
+		byId[bookId].Tags = names
+	}
+
+	// The reading log, oldest first
+	p := &listQuery{}
+	p.where("b.user_id = ?", userId)
+	if from != nil || to != nil {
+		progressRange, progressArgs := between("p.created_at", from, to)
+		p.where(progressRange, progressArgs...)
+	}
+
+	query = fmt.Sprintf(`SELECT p.id, p.book_id, p.run_id, p.from_page, p.until_page, p.description, p.created_at
+		FROM progresses p JOIN books b ON b.id = p.book_id
+		WHERE %s ORDER BY p.created_at, p.id`, strings.Join(p.conditions, " AND "))
+
+	progressRows, err := s.DB.QueryContext(context.Background(), query, p.args...)
+	if err != nil {
+		slog.Error("Eror while query", "err", err)
+		return nil, err
+	}
+
+	// close the rows of course
+	defer progressRows.Close()
+
+	for progressRows.Next() {
+		var progress ExportLibraryProgress
+		var bookId int
+		err := progressRows.Scan(&progress.Id, &bookId, &progress.RunId, &progress.FromPage, &progress.UntilPage, &progress.Description, &progress.CreatedAt)
+		if err != nil {
+			slog.Error("Error querying", "err", err)
+			return nil, err
+		}
+
+		// The status filter left the book out
+		book, ok := byId[bookId]
+		if !ok {
+			continue
+		}
+
+		progress.From = formatPosition(book.Unit, progress.FromPage)
+		progress.Until = formatPosition(book.Unit, progress.UntilPage)
+		progress.Percent = percentOf(progress.UntilPage, book.TotalPages)
+		progress.CreatedAt = progress.CreatedAt.In(loc)
+		book.Progresses = append(book.Progresses, &progress)
+	}
+
+	return books, progressRows.Err()
+}
+
+// between is the condition for column being in the range, a missing end leaves that side open
+func between(column string, from *time.Time, to *time.Time) (string, []any) {
+	var conditions []string
+	var args []any
+	if from != nil {
+		conditions = append(conditions, column+" >= ?")
+		args = append(args, *from)
+	}
+	if to != nil {
+		conditions = append(conditions, column+" <= ?")
+		args = append(args, *to)
+	}
+
+	return strings.Join(conditions, " AND "), args
 }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jirbthagoras/hon/shared"
+)
+
+// After renaming or merging an author the books are written again from their credits,
+// the text has to split back into the same authors or the author filter and exports lose the co-authors
+func TestAuthorTexts(t *testing.T) {
+	tests := []struct {
+		name    string
+		credits []authorCredit
+		want    map[int][]string
+	}{
+		{
+			name: "one of two authors renamed",
+			credits: []authorCredit{
+				{bookId: 1, name: "Neil Gaiman"},
+				// was "T. Pratchett" before the rename
+				{bookId: 1, name: "Terry Pratchett"},
+			},
+			want: map[int][]string{1: {"Neil Gaiman", "Terry Pratchett"}},
+		},
+		{
+			name: "names with commas in them",
+			credits: []authorCredit{
+				{bookId: 3, name: "Le Guin, Ursula K."},
+				{bookId: 3, name: "Martin Luther King, Jr."},
+			},
+			want: map[int][]string{3: {"Le Guin, Ursula K.", "Martin Luther King, Jr."}},
+		},
+		{
+			name: "several books keep their order",
+			credits: []authorCredit{
+				{bookId: 1, name: "Terry Pratchett"},
+				{bookId: 1, name: "Neil Gaiman"},
+				{bookId: 2, name: "Terry Pratchett"},
+			},
+			want: map[int][]string{1: {"Terry Pratchett", "Neil Gaiman"}, 2: {"Terry Pratchett"}},
+		},
+		{
+			name: "no credits",
+			want: map[int][]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := map[int][]string{}
+			for bookId, text := range authorTexts(tt.credits) {
+				got[bookId] = shared.SplitAuthors(text)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("split author texts = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// formatUnits are the formats a book can come in and the units its progress is counted in, the first unit is the default.
+// Positions are stored as integers in the unit: pages, whole percents, locations, or seconds into the audiobook.
+// Percent works for every format, it's what's left when the length isn't known.
+var formatUnits = map[string][]string{
+	"paper":     {"pages", "percent"},
+	"ebook":     {"percent", "location"},
+	"audiobook": {"seconds", "percent"},
+}
+
+// percentTotal is the length of every book counted in percent
+const percentTotal = 100
+
+// resolveUnit fills in the defaults and checks the unit fits the format
+func resolveUnit(format string, unit string) (string, string, error) {
+	if format == "" {
+		format = "paper"
+	}
+
+	units := formatUnits[format]
+	if unit == "" {
+		return format, units[0], nil
+	}
+
+	if !slices.Contains(units, unit) {
+		return "", "", fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("A %s book can't be counted in %s, use one of: %s", format, unit, strings.Join(units, ", ")))
+	}
+
+	return format, unit, nil
+}
+
+// parsePosition reads a position written in the unit.
+// Pages and locations are plain numbers, percents may end with %, audiobooks take h:mm:ss or mm:ss.
+func parsePosition(unit string, raw string) (int, error) {
+	raw = strings.TrimSpace(raw)
+
+	switch unit {
+	case "percent":
+		raw = strings.TrimSpace(strings.TrimSuffix(raw, "%"))
+	case "seconds":
+		if strings.Contains(raw, ":") {
+			return parseDuration(raw)
+		}
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 0 {
+		return 0, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("%q is not a valid position in %s", raw, unit))
+	}
+
+	return value, nil
+}
+
+// parseDuration reads h:mm:ss or mm:ss into seconds
+func parseDuration(raw string) (int, error) {
+	invalid := fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("%q is not a valid time, use h:mm:ss or mm:ss", raw))
+
+	parts := strings.Split(raw, ":")
+	if len(parts) > 3 {
+		return 0, invalid
+	}
+
+	seconds := 0
+	for i, part := range parts {
+		value, err := strconv.Atoi(part)
+		if err != nil || value < 0 {
+			return 0, invalid
+		}
+		// only the leading part may go past 59
+		if i > 0 && value > 59 {
+			return 0, invalid
+		}
+		seconds = seconds*60 + value
+	}
+
+	return seconds, nil
+}
+
+// resolvePosition takes the unit-aware value when it's given, the plain integer one otherwise
+func resolvePosition(unit string, raw string, fallback int) (int, error) {
+	if raw == "" {
+		return fallback, nil
+	}
+
+	return parsePosition(unit, raw)
+}
+
+// formatPosition writes a position the way parsePosition reads it
+func formatPosition(unit string, value int) string {
+	switch unit {
+	case "percent":
+		return fmt.Sprintf("%d%%", value)
+	case "seconds":
+		hours, minutes, seconds := value/3600, value%3600/60, value%60
+		if hours > 0 {
+			return fmt.Sprintf("%d:%02d:%02d", hours, minutes, seconds)
+		}
+		return fmt.Sprintf("%d:%02d", minutes, seconds)
+	}
+
+	return strconv.Itoa(value)
+}
+
+// percentOf normalises a position to a percent of the whole book, that's what lets formats be compared
+func percentOf(value int, total int) float64 {
+	if total <= 0 {
+		return 0
+	}
+
+	return math.Round(float64(value)*10000/float64(total)) / 100
+}
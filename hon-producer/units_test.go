@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParsePosition(t *testing.T) {
+	tests := []struct {
+		name    string
+		unit    string
+		raw     string
+		want    int
+		wantErr bool
+	}{
+		{name: "page", unit: "pages", raw: "142", want: 142},
+		{name: "page with spaces", unit: "pages", raw: "  7 ", want: 7},
+		{name: "location", unit: "location", raw: "3051", want: 3051},
+		{name: "zero", unit: "pages", raw: "0", want: 0},
+		{name: "percent", unit: "percent", raw: "45", want: 45},
+		{name: "percent sign", unit: "percent", raw: "45%", want: 45},
+		{name: "percent sign after a space", unit: "percent", raw: "45 %", want: 45},
+		{name: "plain seconds", unit: "seconds", raw: "5400", want: 5400},
+		{name: "minutes and seconds", unit: "seconds", raw: "12:30", want: 750},
+		{name: "hours, minutes and seconds", unit: "seconds", raw: "1:02:03", want: 3723},
+		{name: "leading part past 59", unit: "seconds", raw: "90:00", want: 5400},
+		{name: "percent sign on pages", unit: "pages", raw: "45%", wantErr: true},
+		{name: "time on pages", unit: "pages", raw: "1:30", wantErr: true},
+		{name: "negative", unit: "pages", raw: "-3", wantErr: true},
+		{name: "decimal", unit: "percent", raw: "45.5", wantErr: true},
+		{name: "empty", unit: "pages", raw: "", wantErr: true},
+		{name: "minutes past 59", unit: "seconds", raw: "1:60:00", wantErr: true},
+		{name: "seconds past 59", unit: "seconds", raw: "10:75", wantErr: true},
+		{name: "too many parts", unit: "seconds", raw: "1:00:00:00", wantErr: true},
+		{name: "missing part", unit: "seconds", raw: "1::00", wantErr: true},
+		{name: "negative part", unit: "seconds", raw: "1:-5", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePosition(tt.unit, tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePosition(%q, %q) err = %v, want error %v", tt.unit, tt.raw, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parsePosition(%q, %q) = %d, want %d", tt.unit, tt.raw, got, tt.want)
+			}
+		})
+	}
+}
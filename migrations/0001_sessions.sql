@@ -0,0 +1,24 @@
+-- Sessions table, one row per logged in device
+CREATE TABLE sessions (
+                          id BIGINT AUTO_INCREMENT,
+                          user_id BIGINT NOT NULL,
+                          user_agent VARCHAR(255),
+                          ip_address VARCHAR(45),
+                          created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                          last_used_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                          expires_at DATETIME NOT NULL,
+                          revoked_at DATETIME NULL,
+                          FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+                          PRIMARY KEY(id)
+);
+
+-- Refresh tokens table, every rotation adds a row so reused tokens can be detected
+CREATE TABLE refresh_tokens (
+                                id BIGINT AUTO_INCREMENT,
+                                session_id BIGINT NOT NULL,
+                                token_hash CHAR(64) NOT NULL UNIQUE,
+                                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                                used_at DATETIME NULL,
+                                FOREIGN KEY (session_id) REFERENCES sessions(id) ON DELETE CASCADE,
+                                PRIMARY KEY(id)
+);
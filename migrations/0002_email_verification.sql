@@ -0,0 +1,13 @@
+ALTER TABLE users ADD COLUMN verified_at DATETIME NULL AFTER password;
+
+-- Email verifications table, keeps the jti of every verification token so each one works only once
+CREATE TABLE email_verifications (
+                                     id BIGINT AUTO_INCREMENT,
+                                     user_id BIGINT NOT NULL,
+                                     token_hash CHAR(64) NOT NULL UNIQUE,
+                                     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                                     expires_at DATETIME NOT NULL,
+                                     used_at DATETIME NULL,
+                                     FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+                                     PRIMARY KEY(id)
+);
@@ -0,0 +1,11 @@
+-- Password resets table, only the hash of the reset token is kept
+CREATE TABLE password_resets (
+                                 id BIGINT AUTO_INCREMENT,
+                                 user_id BIGINT NOT NULL,
+                                 token_hash CHAR(64) NOT NULL UNIQUE,
+                                 created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                                 expires_at DATETIME NOT NULL,
+                                 used_at DATETIME NULL,
+                                 FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+                                 PRIMARY KEY(id)
+);
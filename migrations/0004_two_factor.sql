@@ -0,0 +1,15 @@
+ALTER TABLE users
+    ADD COLUMN totp_secret VARCHAR(64) NULL AFTER verified_at,
+    ADD COLUMN totp_enabled_at DATETIME NULL AFTER totp_secret,
+    ADD COLUMN totp_last_step BIGINT NULL AFTER totp_enabled_at;
+
+-- Recovery codes table, one time codes for when the authenticator app is gone
+CREATE TABLE recovery_codes (
+                                id BIGINT AUTO_INCREMENT,
+                                user_id BIGINT NOT NULL,
+                                code_hash CHAR(64) NOT NULL,
+                                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                                used_at DATETIME NULL,
+                                FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+                                PRIMARY KEY(id)
+);
@@ -0,0 +1,15 @@
+-- API keys table, personal keys for scripts and bots, only the hash of the key is kept
+CREATE TABLE api_keys (
+                          id BIGINT AUTO_INCREMENT,
+                          user_id BIGINT NOT NULL,
+                          name VARCHAR(100) NOT NULL,
+                          prefix VARCHAR(16) NOT NULL,
+                          key_hash CHAR(64) NOT NULL UNIQUE,
+                          read_only BOOLEAN NOT NULL DEFAULT FALSE,
+                          created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                          last_used_at DATETIME NULL,
+                          expires_at DATETIME NULL,
+                          revoked_at DATETIME NULL,
+                          FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+                          PRIMARY KEY(id)
+);
@@ -0,0 +1,9 @@
+-- Login throttles table, failed login counters per account (email) and per ip
+CREATE TABLE login_throttles (
+                                 scope ENUM('account', 'ip') NOT NULL,
+                                 identifier VARCHAR(255) NOT NULL,
+                                 failures INT NOT NULL DEFAULT 0,
+                                 last_failure_at DATETIME NULL,
+                                 locked_until DATETIME NULL,
+                                 PRIMARY KEY(scope, identifier)
+);
@@ -0,0 +1,4 @@
+ALTER TABLE users
+    ADD COLUMN role ENUM('user', 'admin') NOT NULL DEFAULT 'user' AFTER totp_last_step,
+    ADD COLUMN disabled_at DATETIME NULL AFTER role,
+    ADD COLUMN created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP AFTER disabled_at;
@@ -0,0 +1,2 @@
+ALTER TABLE users
+    ADD COLUMN deletion_scheduled_at DATETIME NULL AFTER disabled_at;
@@ -0,0 +1,8 @@
+-- The application now reads and writes every DATETIME in UTC (loc=UTC and time_zone='+00:00' in the DSN).
+-- If the database used to run in another zone, shift the existing values first, e.g. for a server on UTC+7:
+--   UPDATE goals SET expired_at = expired_at - INTERVAL 7 HOUR;
+ALTER TABLE users
+    ADD COLUMN display_name VARCHAR(100) NULL AFTER deletion_scheduled_at,
+    ADD COLUMN timezone VARCHAR(64) NOT NULL DEFAULT 'UTC' AFTER display_name,
+    ADD COLUMN locale VARCHAR(16) NOT NULL DEFAULT 'en' AFTER timezone,
+    ADD COLUMN date_format VARCHAR(16) NOT NULL DEFAULT 'long' AFTER locale;
@@ -0,0 +1,3 @@
+-- Keyset pagination walks these in order, without them every page is a filesort
+CREATE INDEX idx_progresses_book_created ON progresses (book_id, created_at);
+CREATE INDEX idx_goals_user_expired ON goals (user_id, expired_at);
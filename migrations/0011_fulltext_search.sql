@@ -0,0 +1,3 @@
+-- Used by GET /api/search, words shorter than innodb_ft_min_token_size (3 by default) are not indexed
+CREATE FULLTEXT INDEX ft_books_title_author ON books (title, author);
+CREATE FULLTEXT INDEX ft_progresses_description ON progresses (description);
@@ -0,0 +1,20 @@
+-- Tags table, user-defined labels for books, names are unique per user
+CREATE TABLE tags (
+                      id BIGINT AUTO_INCREMENT,
+                      user_id BIGINT NOT NULL,
+                      name VARCHAR(50) NOT NULL,
+                      created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                      FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+                      UNIQUE KEY uq_tags_user_name (user_id, name),
+                      PRIMARY KEY(id)
+);
+
+-- Book tags table, which book carries which tag
+CREATE TABLE book_tags (
+                           book_id BIGINT NOT NULL,
+                           tag_id BIGINT NOT NULL,
+                           FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE,
+                           FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE,
+                           INDEX idx_book_tags_tag (tag_id),
+                           PRIMARY KEY(book_id, tag_id)
+);
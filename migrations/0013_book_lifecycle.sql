@@ -0,0 +1,3 @@
+-- want-to-read, paused and abandoned books, goals of abandoned books can get cancelled
+ALTER TABLE books MODIFY status ENUM('want-to-read', 'reading', 'paused', 'completed', 'abandoned') DEFAULT 'reading';
+ALTER TABLE goals MODIFY status ENUM('finished', 'in-progress', 'expired', 'cancelled') DEFAULT 'in-progress';
@@ -0,0 +1,30 @@
+-- Reading runs, a book can be read more than once and progresses and goals belong to one read
+CREATE TABLE reading_runs (
+                              id BIGINT AUTO_INCREMENT,
+                              book_id BIGINT NOT NULL,
+                              number INT NOT NULL,
+                              started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                              finished_at DATETIME NULL,
+                              FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE,
+                              UNIQUE KEY uq_reading_runs_book_number (book_id, number),
+                              PRIMARY KEY(id)
+);
+
+-- Every existing book is on its first read, completed ones finished it with their last progress
+INSERT INTO reading_runs (book_id, number, started_at, finished_at)
+SELECT b.id, 1,
+       COALESCE((SELECT MIN(p.created_at) FROM progresses p WHERE p.book_id = b.id), CURRENT_TIMESTAMP),
+       IF(b.status = 'completed', COALESCE((SELECT MAX(p.created_at) FROM progresses p WHERE p.book_id = b.id), CURRENT_TIMESTAMP), NULL)
+FROM books b;
+
+ALTER TABLE progresses ADD COLUMN run_id BIGINT NULL AFTER book_id;
+UPDATE progresses p JOIN reading_runs r ON r.book_id = p.book_id SET p.run_id = r.id;
+ALTER TABLE progresses
+    MODIFY run_id BIGINT NOT NULL,
+    ADD FOREIGN KEY (run_id) REFERENCES reading_runs(id) ON DELETE CASCADE;
+
+ALTER TABLE goals ADD COLUMN run_id BIGINT NULL AFTER book_id;
+UPDATE goals g JOIN reading_runs r ON r.book_id = g.book_id SET g.run_id = r.id;
+ALTER TABLE goals
+    MODIFY run_id BIGINT NOT NULL,
+    ADD FOREIGN KEY (run_id) REFERENCES reading_runs(id) ON DELETE CASCADE;
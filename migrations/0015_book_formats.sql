@@ -0,0 +1,4 @@
+-- Ebooks and audiobooks, total_pages and the progress pages are counted in the book's unit
+ALTER TABLE books
+    ADD COLUMN format ENUM('paper', 'ebook', 'audiobook') NOT NULL DEFAULT 'paper' AFTER total_pages,
+    ADD COLUMN unit ENUM('pages', 'percent', 'location', 'seconds') NOT NULL DEFAULT 'pages' AFTER format;
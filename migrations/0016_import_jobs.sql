@@ -0,0 +1,20 @@
+-- Goodreads and StoryGraph imports, the CSV waits in payload until the consumer is done with it
+CREATE TABLE import_jobs (
+                             id BIGINT AUTO_INCREMENT,
+                             user_id BIGINT NOT NULL,
+                             source ENUM('goodreads', 'storygraph') NOT NULL,
+                             status ENUM('queued', 'running', 'completed', 'failed') NOT NULL DEFAULT 'queued',
+                             payload MEDIUMTEXT NULL,
+                             total_rows INT NOT NULL DEFAULT 0,
+                             imported INT NOT NULL DEFAULT 0,
+                             skipped INT NOT NULL DEFAULT 0,
+                             failed INT NOT NULL DEFAULT 0,
+                             failures TEXT NULL,
+                             error TEXT NULL,
+                             created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                             started_at DATETIME NULL,
+                             finished_at DATETIME NULL,
+                             FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+                             INDEX idx_import_jobs_user_status (user_id, status),
+                             PRIMARY KEY(id)
+);
@@ -0,0 +1,16 @@
+-- Books created from an ISBN, metadata_pending is set while the consumer still fetches the details
+ALTER TABLE books
+    ADD COLUMN isbn CHAR(13) NULL AFTER author,
+    ADD COLUMN metadata_pending BOOLEAN NOT NULL DEFAULT FALSE AFTER isbn,
+    ADD INDEX idx_books_user_isbn (user_id, isbn);
+
+-- What the metadata provider answered per ISBN, misses included
+CREATE TABLE isbn_metadata (
+                               isbn CHAR(13) NOT NULL,
+                               title VARCHAR(255) NULL,
+                               author VARCHAR(255) NULL,
+                               total_pages INT NULL,
+                               found BOOLEAN NOT NULL,
+                               fetched_at DATETIME NOT NULL,
+                               PRIMARY KEY(isbn)
+);
@@ -0,0 +1,4 @@
+-- Book covers, the keys point into the blob store
+ALTER TABLE books
+    ADD COLUMN cover_key VARCHAR(255) NULL AFTER metadata_pending,
+    ADD COLUMN thumbnail_key VARCHAR(255) NULL AFTER cover_key;
@@ -0,0 +1,39 @@
+-- Series a user's books belong to, names are unique per user
+CREATE TABLE series (
+                        id BIGINT AUTO_INCREMENT,
+                        user_id BIGINT NOT NULL,
+                        name VARCHAR(255) NOT NULL,
+                        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                        FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+                        UNIQUE KEY uq_series_user_name (user_id, name),
+                        PRIMARY KEY(id)
+);
+
+ALTER TABLE books
+    ADD COLUMN series_id BIGINT NULL AFTER author,
+    ADD COLUMN series_position DECIMAL(6,2) NULL AFTER series_id,
+    ADD FOREIGN KEY (series_id) REFERENCES series(id) ON DELETE SET NULL;
+
+-- Authors per user, name_key is the name without case, punctuation and spacing so "J.R.R. Tolkien" and "j r r tolkien" are one.
+-- The existing author strings are split and deduplicated into it by the producer on startup.
+CREATE TABLE authors (
+                         id BIGINT AUTO_INCREMENT,
+                         user_id BIGINT NOT NULL,
+                         name VARCHAR(255) NOT NULL,
+                         name_key VARCHAR(255) NOT NULL,
+                         created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                         FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+                         UNIQUE KEY uq_authors_user_name_key (user_id, name_key),
+                         PRIMARY KEY(id)
+);
+
+-- Which authors wrote a book, position keeps the order they're credited in
+CREATE TABLE book_authors (
+                              book_id BIGINT NOT NULL,
+                              author_id BIGINT NOT NULL,
+                              position INT NOT NULL,
+                              FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE,
+                              FOREIGN KEY (author_id) REFERENCES authors(id) ON DELETE CASCADE,
+                              INDEX idx_book_authors_author (author_id),
+                              PRIMARY KEY(book_id, author_id)
+);
@@ -0,0 +1,4 @@
+-- Book files, the ebook itself for reading apps to download, the key points into the blob store
+ALTER TABLE books
+    ADD COLUMN file_key VARCHAR(255) NULL AFTER thumbnail_key,
+    ADD COLUMN file_type VARCHAR(64) NULL AFTER file_key;
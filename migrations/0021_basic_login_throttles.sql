@@ -0,0 +1,3 @@
+-- HTTP basic logins, e-readers on the OPDS catalog, count their failures apart from the web login
+ALTER TABLE login_throttles
+    MODIFY scope ENUM('account', 'ip', 'basic_account', 'basic_ip') NOT NULL;
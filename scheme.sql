@@ -2,30 +2,82 @@ CREATE TABLE users (
                        id BIGINT AUTO_INCREMENT,
                        email VARCHAR(255) NOT NULL UNIQUE,
                        password VARCHAR(255) NOT NULL,
+                       verified_at DATETIME NULL,
+                       totp_secret VARCHAR(64) NULL,
+                       totp_enabled_at DATETIME NULL,
+                       totp_last_step BIGINT NULL,
+                       role ENUM('user', 'admin') NOT NULL DEFAULT 'user',
+                       disabled_at DATETIME NULL,
+                       deletion_scheduled_at DATETIME NULL,
+                       display_name VARCHAR(100) NULL,
+                       timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+                       locale VARCHAR(16) NOT NULL DEFAULT 'en',
+                       date_format VARCHAR(16) NOT NULL DEFAULT 'long',
+                       created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
                        PRIMARY KEY(id)
 );
 
+-- Series table, the series a user's books belong to, names are unique per user
+CREATE TABLE series (
+                        id BIGINT AUTO_INCREMENT,
+                        user_id BIGINT NOT NULL,
+                        name VARCHAR(255) NOT NULL,
+                        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                        FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+                        UNIQUE KEY uq_series_user_name (user_id, name),
+                        PRIMARY KEY(id)
+);
+
 -- Books table
 CREATE TABLE books (
                        id BIGINT AUTO_INCREMENT,
                        user_id BIGINT NOT NULL,
                        title VARCHAR(255),
                        author VARCHAR(255),
+                       series_id BIGINT NULL,
+                       series_position DECIMAL(6,2) NULL,
+                       isbn CHAR(13) NULL,
+                       metadata_pending BOOLEAN NOT NULL DEFAULT FALSE,
+                       cover_key VARCHAR(255) NULL,
+                       thumbnail_key VARCHAR(255) NULL,
+                       file_key VARCHAR(255) NULL,
+                       file_type VARCHAR(64) NULL,
                        total_pages INT,
-                       status ENUM('reading', 'completed') DEFAULT 'reading',
+                       format ENUM('paper', 'ebook', 'audiobook') NOT NULL DEFAULT 'paper',
+                       unit ENUM('pages', 'percent', 'location', 'seconds') NOT NULL DEFAULT 'pages',
+                       status ENUM('want-to-read', 'reading', 'paused', 'completed', 'abandoned') DEFAULT 'reading',
                        FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+                       FOREIGN KEY (series_id) REFERENCES series(id) ON DELETE SET NULL,
+                       FULLTEXT INDEX ft_books_title_author (title, author),
+                       INDEX idx_books_user_isbn (user_id, isbn),
                        PRIMARY KEY(id)
 );
 
+-- Reading runs table, one row per read of a book, re-reading a book starts the next number
+CREATE TABLE reading_runs (
+                              id BIGINT AUTO_INCREMENT,
+                              book_id BIGINT NOT NULL,
+                              number INT NOT NULL,
+                              started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                              finished_at DATETIME NULL,
+                              FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE,
+                              UNIQUE KEY uq_reading_runs_book_number (book_id, number),
+                              PRIMARY KEY(id)
+);
+
 -- Progresses table
 CREATE TABLE progresses (
                             id BIGINT AUTO_INCREMENT,
                             book_id BIGINT NOT NULL,
+                            run_id BIGINT NOT NULL,
                             from_page INT NOT NULL,
                             until_page INT NOT NULL,
                             description TEXT NOT NULL,
                             created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
                             FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE,
+                            FOREIGN KEY (run_id) REFERENCES reading_runs(id) ON DELETE CASCADE,
+                            INDEX idx_progresses_book_created (book_id, created_at),
+                            FULLTEXT INDEX ft_progresses_description (description),
                             PRIMARY KEY(id)
 );
 
@@ -33,12 +85,176 @@ CREATE TABLE progresses (
 CREATE TABLE goals (
                        id BIGINT AUTO_INCREMENT,
                        book_id BIGINT NOT NULL,
+                       run_id BIGINT NOT NULL,
                        user_id BIGINT NOT NULL,
                        name VARCHAR(255),
                        target_page INT,
-                       status ENUM('finished', 'in-progress', 'expired') DEFAULT 'in-progress',
+                       status ENUM('finished', 'in-progress', 'expired', 'cancelled') DEFAULT 'in-progress',
                        expired_at DATETIME,
                        FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE,
+                       FOREIGN KEY (run_id) REFERENCES reading_runs(id) ON DELETE CASCADE,
                        FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+                       INDEX idx_goals_user_expired (user_id, expired_at),
                        PRIMARY KEY(id)
-);
\ No newline at end of file
+);
+
+-- Tags table, user-defined labels for books, names are unique per user
+CREATE TABLE tags (
+                      id BIGINT AUTO_INCREMENT,
+                      user_id BIGINT NOT NULL,
+                      name VARCHAR(50) NOT NULL,
+                      created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                      FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+                      UNIQUE KEY uq_tags_user_name (user_id, name),
+                      PRIMARY KEY(id)
+);
+
+-- Book tags table, which book carries which tag
+CREATE TABLE book_tags (
+                           book_id BIGINT NOT NULL,
+                           tag_id BIGINT NOT NULL,
+                           FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE,
+                           FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE,
+                           INDEX idx_book_tags_tag (tag_id),
+                           PRIMARY KEY(book_id, tag_id)
+);
+
+-- Authors table, name_key is the name without case, punctuation and spacing so "J.R.R. Tolkien" and "j r r tolkien" are one
+CREATE TABLE authors (
+                         id BIGINT AUTO_INCREMENT,
+                         user_id BIGINT NOT NULL,
+                         name VARCHAR(255) NOT NULL,
+                         name_key VARCHAR(255) NOT NULL,
+                         created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                         FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+                         UNIQUE KEY uq_authors_user_name_key (user_id, name_key),
+                         PRIMARY KEY(id)
+);
+
+-- Book authors table, which authors wrote a book, position keeps the order they're credited in
+CREATE TABLE book_authors (
+                              book_id BIGINT NOT NULL,
+                              author_id BIGINT NOT NULL,
+                              position INT NOT NULL,
+                              FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE,
+                              FOREIGN KEY (author_id) REFERENCES authors(id) ON DELETE CASCADE,
+                              INDEX idx_book_authors_author (author_id),
+                              PRIMARY KEY(book_id, author_id)
+);
+
+-- Sessions table, one row per logged in device
+CREATE TABLE sessions (
+                          id BIGINT AUTO_INCREMENT,
+                          user_id BIGINT NOT NULL,
+                          user_agent VARCHAR(255),
+                          ip_address VARCHAR(45),
+                          created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                          last_used_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                          expires_at DATETIME NOT NULL,
+                          revoked_at DATETIME NULL,
+                          FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+                          PRIMARY KEY(id)
+);
+
+-- Refresh tokens table, every rotation adds a row so reused tokens can be detected
+CREATE TABLE refresh_tokens (
+                                id BIGINT AUTO_INCREMENT,
+                                session_id BIGINT NOT NULL,
+                                token_hash CHAR(64) NOT NULL UNIQUE,
+                                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                                used_at DATETIME NULL,
+                                FOREIGN KEY (session_id) REFERENCES sessions(id) ON DELETE CASCADE,
+                                PRIMARY KEY(id)
+);
+
+-- Email verifications table, keeps the jti of every verification token so each one works only once
+CREATE TABLE email_verifications (
+                                     id BIGINT AUTO_INCREMENT,
+                                     user_id BIGINT NOT NULL,
+                                     token_hash CHAR(64) NOT NULL UNIQUE,
+                                     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                                     expires_at DATETIME NOT NULL,
+                                     used_at DATETIME NULL,
+                                     FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+                                     PRIMARY KEY(id)
+);
+
+-- Password resets table, only the hash of the reset token is kept
+CREATE TABLE password_resets (
+                                 id BIGINT AUTO_INCREMENT,
+                                 user_id BIGINT NOT NULL,
+                                 token_hash CHAR(64) NOT NULL UNIQUE,
+                                 created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                                 expires_at DATETIME NOT NULL,
+                                 used_at DATETIME NULL,
+                                 FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+                                 PRIMARY KEY(id)
+);
+
+-- Recovery codes table, one time codes for when the authenticator app is gone
+CREATE TABLE recovery_codes (
+                                id BIGINT AUTO_INCREMENT,
+                                user_id BIGINT NOT NULL,
+                                code_hash CHAR(64) NOT NULL,
+                                created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                                used_at DATETIME NULL,
+                                FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+                                PRIMARY KEY(id)
+);
+
+-- API keys table, personal keys for scripts and bots, only the hash of the key is kept
+CREATE TABLE api_keys (
+                          id BIGINT AUTO_INCREMENT,
+                          user_id BIGINT NOT NULL,
+                          name VARCHAR(100) NOT NULL,
+                          prefix VARCHAR(16) NOT NULL,
+                          key_hash CHAR(64) NOT NULL UNIQUE,
+                          read_only BOOLEAN NOT NULL DEFAULT FALSE,
+                          created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                          last_used_at DATETIME NULL,
+                          expires_at DATETIME NULL,
+                          revoked_at DATETIME NULL,
+                          FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+                          PRIMARY KEY(id)
+);
+
+-- Login throttles table, failed login counters per account (email) and per ip
+CREATE TABLE login_throttles (
+                                 scope ENUM('account', 'ip', 'basic_account', 'basic_ip') NOT NULL,
+                                 identifier VARCHAR(255) NOT NULL,
+                                 failures INT NOT NULL DEFAULT 0,
+                                 last_failure_at DATETIME NULL,
+                                 locked_until DATETIME NULL,
+                                 PRIMARY KEY(scope, identifier)
+);
+-- Import jobs table, Goodreads and StoryGraph exports waiting for or done by the consumer
+CREATE TABLE import_jobs (
+                             id BIGINT AUTO_INCREMENT,
+                             user_id BIGINT NOT NULL,
+                             source ENUM('goodreads', 'storygraph') NOT NULL,
+                             status ENUM('queued', 'running', 'completed', 'failed') NOT NULL DEFAULT 'queued',
+                             payload MEDIUMTEXT NULL,
+                             total_rows INT NOT NULL DEFAULT 0,
+                             imported INT NOT NULL DEFAULT 0,
+                             skipped INT NOT NULL DEFAULT 0,
+                             failed INT NOT NULL DEFAULT 0,
+                             failures TEXT NULL,
+                             error TEXT NULL,
+                             created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                             started_at DATETIME NULL,
+                             finished_at DATETIME NULL,
+                             FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+                             INDEX idx_import_jobs_user_status (user_id, status),
+                             PRIMARY KEY(id)
+);
+
+-- ISBN metadata table, what the metadata provider answered per ISBN, misses included
+CREATE TABLE isbn_metadata (
+                               isbn CHAR(13) NOT NULL,
+                               title VARCHAR(255) NULL,
+                               author VARCHAR(255) NULL,
+                               total_pages INT NULL,
+                               found BOOLEAN NOT NULL,
+                               fetched_at DATETIME NOT NULL,
+                               PRIMARY KEY(isbn)
+);
@@ -0,0 +1,257 @@
+package shared
+
+import (
+	"encoding/base64"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+
+	// where the middleware keeps the principal inside c.Locals
+	principalKey = "hon.principal"
+
+	// APIKeyPrefix marks personal API keys, so they can't be mistaken for a JWT
+	APIKeyPrefix = "hon_"
+	// HeaderAPIKey is the alternative to "Authorization: Bearer hon_..." for tools that can't set that header
+	HeaderAPIKey = "X-API-Key"
+)
+
+// APIKeyResolver looks an API key up and returns who owns it. Keys live in the producer's database,
+// so the producer registers the resolver at startup; without one API keys are simply rejected.
+type APIKeyResolver func(key string) (*Principal, error)
+
+var apiKeyResolver APIKeyResolver
+
+func RegisterAPIKeyResolver(resolver APIKeyResolver) {
+	apiKeyResolver = resolver
+}
+
+// PasswordResolver checks the email and password of HTTP basic auth and returns who it is, registered by the producer too.
+// Without one only API keys work as basic auth passwords.
+type PasswordResolver func(email string, password string, ip string) (*Principal, error)
+
+var passwordResolver PasswordResolver
+
+func RegisterPasswordResolver(resolver PasswordResolver) {
+	passwordResolver = resolver
+}
+
+// SessionChecker tells whether the session an access token was issued for is still alive, registered by the producer too.
+// Without one a revoked session's access tokens keep working until they expire.
+type SessionChecker func(sessionId int, userId int) error
+
+var sessionChecker SessionChecker
+
+func RegisterSessionChecker(checker SessionChecker) {
+	sessionChecker = checker
+}
+
+// DefaultScopes is what a normal login gets
+var DefaultScopes = []string{ScopeRead, ScopeWrite}
+
+// Principal is whoever is calling the API, resolved once by the middleware and read by handlers.
+// At most one of SessionId (logged in with a JWT) or APIKeyId (a script using an API key) is set,
+// neither is for HTTP basic with the account password.
+type Principal struct {
+	UserId    int
+	SessionId int
+	APIKeyId  int
+	Scopes    []string
+}
+
+func (p *Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+// AuthMiddleware rejects the request unless it carries a valid bearer token
+func AuthMiddleware(c *fiber.Ctx) error {
+	principal, err := authenticate(c)
+	if err != nil {
+		c.Set(fiber.HeaderWWWAuthenticate, `Bearer realm="Hon"`)
+		return err
+	}
+
+	c.Locals(principalKey, principal)
+	return c.Next()
+}
+
+// BasicAuthMiddleware is AuthMiddleware for clients that only speak HTTP basic, like e-readers browsing a catalog.
+// The basic password is an API key or the account password, API keys in their usual places work as well.
+func BasicAuthMiddleware(c *fiber.Ctx) error {
+	principal, err := authenticateBasic(c)
+	if err != nil {
+		// Makes the client ask for the username and password
+		c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="Hon", charset="UTF-8"`)
+		return err
+	}
+
+	c.Locals(principalKey, principal)
+	return c.Next()
+}
+
+// OptionalAuthMiddleware lets anonymous requests through, but still resolves the principal when a token is sent.
+// A token that is sent but invalid is still an error, silently downgrading to anonymous would hide bugs.
+func OptionalAuthMiddleware(c *fiber.Ctx) error {
+	if c.Get(fiber.HeaderAuthorization) == "" && c.Get(HeaderAPIKey) == "" {
+		return c.Next()
+	}
+
+	return AuthMiddleware(c)
+}
+
+// RequireScopes guards a single route, it must run after AuthMiddleware
+func RequireScopes(scopes ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, err := GetPrincipal(c)
+		if err != nil {
+			return err
+		}
+
+		for _, scope := range scopes {
+			if !principal.HasScope(scope) {
+				return fiber.NewError(fiber.StatusForbidden, "Token lacks the "+scope+" scope")
+			}
+		}
+
+		return c.Next()
+	}
+}
+
+// RequireSession keeps API keys away from account management (passwords, 2FA, sessions, other API keys),
+// a leaked script key shouldn't be able to take the whole account over. It must run after AuthMiddleware.
+func RequireSession(c *fiber.Ctx) error {
+	principal, err := GetPrincipal(c)
+	if err != nil {
+		return err
+	}
+
+	if principal.APIKeyId != 0 {
+		return fiber.NewError(fiber.StatusForbidden, "API keys can't be used here, please login")
+	}
+
+	return c.Next()
+}
+
+// GetPrincipal returns the principal stored by the middleware
+func GetPrincipal(c *fiber.Ctx) (*Principal, error) {
+	principal, ok := c.Locals(principalKey).(*Principal)
+	if !ok || principal == nil {
+		return nil, fiber.NewError(fiber.StatusUnauthorized, "Unauthenticated")
+	}
+
+	return principal, nil
+}
+
+// GetUserId is the shortcut most handlers need
+func GetUserId(c *fiber.Ctx) (int, error) {
+	principal, err := GetPrincipal(c)
+	if err != nil {
+		return 0, err
+	}
+
+	return principal.UserId, nil
+}
+
+func authenticate(c *fiber.Ctx) (*Principal, error) {
+	// API keys can come in their own header
+	if key := c.Get(HeaderAPIKey); key != "" {
+		return authenticateAPIKey(key)
+	}
+
+	// getting the token
+	token, err := getBearerToken(c)
+	if err != nil {
+		return nil, err
+	}
+
+	// or as a bearer token, the prefix tells them apart from JWTs
+	if strings.HasPrefix(token, APIKeyPrefix) {
+		return authenticateAPIKey(token)
+	}
+
+	// validate the token
+	_, claims, err := ValidateToken(token)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusUnauthorized, "Token Invalid")
+	}
+
+	// single use tokens are not access tokens
+	if claims.Purpose != "" {
+		return nil, fiber.NewError(fiber.StatusUnauthorized, "Token Invalid")
+	}
+
+	// converts the subject from string to int
+	id, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusUnauthorized, "Token Invalid")
+	}
+
+	// A logout or a revoked session ends its access tokens too, not only the refresh token
+	if claims.SessionId != 0 && sessionChecker != nil {
+		if err := sessionChecker(claims.SessionId, id); err != nil {
+			return nil, err
+		}
+	}
+
+	scopes := strings.Fields(claims.Scope)
+	if len(scopes) == 0 {
+		scopes = DefaultScopes
+	}
+
+	return &Principal{UserId: id, SessionId: claims.SessionId, Scopes: scopes}, nil
+}
+
+func authenticateBasic(c *fiber.Ctx) (*Principal, error) {
+	scheme, credentials, _ := strings.Cut(c.Get(fiber.HeaderAuthorization), " ")
+	if !strings.EqualFold(scheme, "Basic") {
+		return authenticate(c)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(credentials))
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusUnauthorized, "Authorization header must be: Basic <base64 of email:password>")
+	}
+	email, password, _ := strings.Cut(string(raw), ":")
+
+	// The username doesn't matter with an API key, it already says whose it is
+	if strings.HasPrefix(password, APIKeyPrefix) {
+		return authenticateAPIKey(password)
+	}
+	if passwordResolver == nil {
+		return nil, fiber.NewError(fiber.StatusUnauthorized, "API key invalid")
+	}
+
+	return passwordResolver(email, password, c.IP())
+}
+
+func authenticateAPIKey(key string) (*Principal, error) {
+	if apiKeyResolver == nil || !strings.HasPrefix(key, APIKeyPrefix) {
+		return nil, fiber.NewError(fiber.StatusUnauthorized, "API key invalid")
+	}
+
+	return apiKeyResolver(key)
+}
+
+func getBearerToken(c *fiber.Ctx) (string, error) {
+	//  get the header
+	header := c.Get(fiber.HeaderAuthorization)
+
+	// checks if the token empty or nah
+	if header == "" {
+		return "", fiber.NewError(fiber.StatusUnauthorized, "Token Not Found")
+	}
+
+	// only the Bearer scheme is accepted, the scheme name itself is case insensitive
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", fiber.NewError(fiber.StatusUnauthorized, "Authorization header must be: Bearer <token>")
+	}
+
+	return strings.TrimSpace(token), nil
+}
@@ -0,0 +1,118 @@
+package shared
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"unicode"
+)
+
+// SplitAuthors splits a free text author field into the names in it, co-authors come separated by semicolons or ampersands.
+// Commas never split, they belong to names like "Le Guin, Ursula K." and "Martin Luther King, Jr.".
+// Blank names and names meaning the same author twice are dropped.
+func SplitAuthors(raw string) []string {
+	parts := strings.FieldsFunc(raw, func(r rune) bool {
+		return r == ';' || r == '&'
+	})
+
+	return CleanAuthors(parts)
+}
+
+// CleanAuthors trims the names and drops the blank ones and the ones meaning an author that came earlier
+func CleanAuthors(names []string) []string {
+	seen := map[string]bool{}
+	var cleaned []string
+	for _, name := range names {
+		name = strings.Join(strings.Fields(name), " ")
+		key := AuthorKey(name)
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		cleaned = append(cleaned, ClipRunes(name, 255))
+	}
+
+	return cleaned
+}
+
+// AuthorKey is what tells authors apart: case, punctuation and spacing don't count, "J.R.R. Tolkien" and "j r r tolkien" are one
+func AuthorKey(name string) string {
+	fields := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	return ClipRunes(strings.Join(fields, " "), 255)
+}
+
+// JoinAuthors is the books.author text of the names, what search, exports and emails keep showing.
+// The names go in separated by semicolons, so SplitAuthors gets the same names back.
+func JoinAuthors(names []string) string {
+	return ClipRunes(strings.Join(names, "; "), 255)
+}
+
+// SetBookAuthors credits the book to the names in order, the authors the user doesn't have yet are created.
+// An author spelled differently than the one already there is linked as it is, books.author follows with the stored spellings.
+// Authors left without books are removed.
+func SetBookAuthors(ctx context.Context, tx *sql.Tx, userId int, bookId int, names []string) error {
+	_, err := tx.ExecContext(ctx, "DELETE FROM book_authors WHERE book_id = ?", bookId)
+	if err != nil {
+		return err
+	}
+
+	var credited []string
+	for i, name := range CleanAuthors(names) {
+		// LAST_INSERT_ID(id) makes an existing author hand back its id too
+		result, err := tx.ExecContext(ctx, `INSERT INTO authors (user_id, name, name_key) VALUES (?, ?, ?)
+			ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id)`, userId, name, AuthorKey(name))
+		if err != nil {
+			return err
+		}
+		authorId, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		var stored string
+		err = tx.QueryRowContext(ctx, "SELECT name FROM authors WHERE id = ?", authorId).Scan(&stored)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, "INSERT INTO book_authors (book_id, author_id, position) VALUES (?, ?, ?)", bookId, authorId, i)
+		if err != nil {
+			return err
+		}
+		credited = append(credited, stored)
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE books SET author = ? WHERE id = ?", JoinAuthors(credited), bookId)
+	if err != nil {
+		return err
+	}
+
+	return PruneAuthors(ctx, tx, userId)
+}
+
+// Execer is a *sql.DB or a *sql.Tx
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// PruneAuthors removes the user's authors no book is credited to anymore
+func PruneAuthors(ctx context.Context, db Execer, userId int) error {
+	_, err := db.ExecContext(ctx, `DELETE a FROM authors a
+		LEFT JOIN book_authors ba ON ba.author_id = a.id
+		WHERE a.user_id = ? AND ba.author_id IS NULL`, userId)
+
+	return err
+}
+
+// ClipRunes keeps value within limit characters, the way VARCHAR columns count them, without cutting one in half
+func ClipRunes(value string, limit int) string {
+	runes := []rune(value)
+	if len(runes) > limit {
+		runes = runes[:limit]
+	}
+
+	return string(runes)
+}
@@ -0,0 +1,276 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BlobStore keeps files like book covers, keys are slash separated paths such as covers/ab12.jpg
+type BlobStore interface {
+	Put(ctx context.Context, key string, contentType string, data []byte) error
+	// Get returns the blob with its content type, ErrBlobNotFound when there is none
+	Get(ctx context.Context, key string) (io.ReadCloser, string, error)
+	// Delete does nothing for a key that isn't there
+	Delete(ctx context.Context, key string) error
+}
+
+var ErrBlobNotFound = errors.New("blob not found")
+
+// FileBlobStore keeps blobs as files under Dir, the content type comes back from the extension
+type FileBlobStore struct {
+	Dir string
+}
+
+func (f *FileBlobStore) path(key string) (string, error) {
+	path := filepath.Join(f.Dir, filepath.FromSlash(key))
+	if !strings.HasPrefix(path, filepath.Clean(f.Dir)+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+
+	return path, nil
+}
+
+func (f *FileBlobStore) Put(ctx context.Context, key string, contentType string, data []byte) error {
+	path, err := f.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	// Written aside and renamed, nobody reads half a file
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".blob-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (f *FileBlobStore) Get(ctx context.Context, key string) (io.ReadCloser, string, error) {
+	path, err := f.path(key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, "", ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	return file, mime.TypeByExtension(filepath.Ext(path)), nil
+}
+
+func (f *FileBlobStore) Delete(ctx context.Context, key string) error {
+	path, err := f.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// S3BlobStore keeps blobs in a bucket of anything speaking the S3 API, AWS itself or MinIO.
+// Requests use path-style addressing and are signed with AWS Signature Version 4.
+type S3BlobStore struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Client    *http.Client
+}
+
+func (s *S3BlobStore) Put(ctx context.Context, key string, contentType string, data []byte) error {
+	resp, err := s.do(ctx, http.MethodPut, key, contentType, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+
+	return nil
+}
+
+func (s *S3BlobStore) Get(ctx context.Context, key string) (io.ReadCloser, string, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, "", nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, "", ErrBlobNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, "", s3Error(resp)
+	}
+
+	return resp.Body, resp.Header.Get("Content-Type"), nil
+}
+
+func (s *S3BlobStore) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// S3 answers 204 whether the object was there or not
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3Error(resp)
+	}
+
+	return nil
+}
+
+func (s *S3BlobStore) do(ctx context.Context, method string, key string, contentType string, data []byte) (*http.Response, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(s.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	// Every segment is escaped on its own, the slashes stay
+	segments := strings.Split(s.Bucket+"/"+key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	escapedPath := endpoint.EscapedPath() + "/" + strings.Join(segments, "/")
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint.Scheme+"://"+endpoint.Host+escapedPath, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	s.sign(req, escapedPath, data, time.Now().UTC())
+
+	return s.Client.Do(req)
+}
+
+// sign adds the Signature Version 4 headers, only host and the x-amz headers are signed
+func (s *S3BlobStore) sign(req *http.Request, escapedPath string, data []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(data)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// The query goes in sorted by key, with spaces as %20 instead of the + form encoding uses
+	canonicalQuery := strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20")
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		escapedPath,
+		canonicalQuery,
+		"host:" + req.URL.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), day)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature))
+}
+
+func s3Error(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("s3 answered %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+var (
+	blobStore     BlobStore
+	blobStoreOnce sync.Once
+)
+
+// GetBlobStore picks the store from BLOB_STORE: fs (the default, files under BLOB_DIR) or s3 (the S3_* settings)
+func GetBlobStore() BlobStore {
+	blobStoreOnce.Do(func() {
+		config := NewConfig()
+
+		switch config.GetString("BLOB_STORE") {
+		case "s3":
+			region := config.GetString("S3_REGION")
+			if region == "" {
+				region = "us-east-1"
+			}
+			blobStore = &S3BlobStore{
+				Endpoint:  config.GetString("S3_ENDPOINT"),
+				Region:    region,
+				Bucket:    config.GetString("S3_BUCKET"),
+				AccessKey: config.GetString("S3_ACCESS_KEY"),
+				SecretKey: config.GetString("S3_SECRET_KEY"),
+				Client:    &http.Client{Timeout: 30 * time.Second},
+			}
+		default:
+			dir := config.GetString("BLOB_DIR")
+			if dir == "" {
+				dir = "blobs"
+			}
+			slog.Debug("Using filesystem blob store", "dir", dir)
+			blobStore = &FileBlobStore{Dir: dir}
+		}
+	})
+
+	return blobStore
+}
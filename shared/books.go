@@ -0,0 +1,62 @@
+package shared
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// NewBook is a book going in with its first read, whether it's created by hand or imported
+type NewBook struct {
+	Title           string
+	Author          string
+	Isbn            string
+	MetadataPending bool
+	TotalPages      int
+	Format          string
+	Unit            string
+	Status          string
+	// StartedAt and FinishedAt date the first read, a zero StartedAt starts it now
+	StartedAt  time.Time
+	FinishedAt *time.Time
+}
+
+// InsertBook inserts the book and its first read, title and author are clipped to fit their columns.
+// It returns the ids of both, crediting the authors is left to the caller.
+func InsertBook(ctx context.Context, tx *sql.Tx, userId int, book NewBook) (bookId int, runId int, err error) {
+	// Create a query
+	query := "INSERT INTO books(user_id, title, author, isbn, metadata_pending, total_pages, format, unit, status) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"
+
+	isbn := sql.NullString{String: book.Isbn, Valid: book.Isbn != ""}
+	result, err := tx.ExecContext(ctx, query, userId, ClipRunes(book.Title, 255), ClipRunes(book.Author, 255), isbn,
+		book.MetadataPending, book.TotalPages, book.Format, book.Unit, book.Status)
+	if err != nil {
+		return 0, 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Every book starts on its first read, progresses and goals hang on the current one
+	var startedAt, finishedAt any
+	if !book.StartedAt.IsZero() {
+		startedAt = book.StartedAt
+	}
+	if book.FinishedAt != nil {
+		finishedAt = *book.FinishedAt
+	}
+
+	query = "INSERT INTO reading_runs (book_id, number, started_at, finished_at) VALUES (?, 1, COALESCE(?, CURRENT_TIMESTAMP), ?)"
+
+	result, err = tx.ExecContext(ctx, query, id, startedAt, finishedAt)
+	if err != nil {
+		return 0, 0, err
+	}
+	run, err := result.LastInsertId()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return int(id), int(run), nil
+}
@@ -4,6 +4,7 @@ import (
 	"log/slog"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -35,3 +36,19 @@ func NewConfig() *viper.Viper {
 	slog.Debug("Returning cached config")
 	return config
 }
+
+// GetDuration reads a duration like "15m" or "720h" from config, falling back when it's empty or malformed.
+func GetDuration(key string, fallback time.Duration) time.Duration {
+	raw := NewConfig().GetString(key)
+	if raw == "" {
+		return fallback
+	}
+
+	duration, err := time.ParseDuration(raw)
+	if err != nil || duration <= 0 {
+		slog.Warn("Invalid duration in config, using fallback", "key", key, "value", raw)
+		return fallback
+	}
+
+	return duration
+}
@@ -0,0 +1,179 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	// The containers don't ship a zoneinfo database, users' timezones have to resolve anyway
+	_ "time/tzdata"
+)
+
+const (
+	DefaultTimezone   = "UTC"
+	DefaultLocale     = "en"
+	DefaultDateFormat = "long"
+)
+
+// DateFormats are the presets a user can pick from, the zone abbreviation is always added
+var DateFormats = map[string]string{
+	"iso":  "2006-01-02 15:04",
+	"us":   "01/02/2006 3:04 PM",
+	"eu":   "02/01/2006 15:04",
+	"long": "Monday, 2 January 2006 15:04",
+}
+
+// Locales holds the day and month names, Go only formats them in English
+var Locales = map[string]struct {
+	Days   [7]string
+	Months [12]string
+}{
+	"en": {
+		Days:   [7]string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"},
+		Months: [12]string{"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"},
+	},
+	"id": {
+		Days:   [7]string{"Minggu", "Senin", "Selasa", "Rabu", "Kamis", "Jumat", "Sabtu"},
+		Months: [12]string{"Januari", "Februari", "Maret", "April", "Mei", "Juni", "Juli", "Agustus", "September", "Oktober", "November", "Desember"},
+	},
+}
+
+// LoadLocation is time.LoadLocation that falls back to UTC, a bad value in the database shouldn't break anything
+func LoadLocation(timezone string) *time.Location {
+	if timezone == "" {
+		return time.UTC
+	}
+
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.UTC
+	}
+
+	return loc
+}
+
+// Recipient carries how the receiver of an email wants to be addressed and see dates
+type Recipient struct {
+	DisplayName string `json:"display_name"`
+	Timezone    string `json:"timezone"`
+	Locale      string `json:"locale"`
+	DateFormat  string `json:"date_format"`
+}
+
+// FormatTime renders t in the recipient's timezone, date format and language
+func (r Recipient) FormatTime(t time.Time) string {
+	return FormatTime(t, r.Timezone, r.Locale, r.DateFormat)
+}
+
+func FormatTime(t time.Time, timezone string, locale string, format string) string {
+	layout, ok := DateFormats[format]
+	if !ok {
+		layout = DateFormats[DefaultDateFormat]
+	}
+
+	t = t.In(LoadLocation(timezone))
+	formatted := t.Format(layout + " MST")
+
+	// Swap the English names, the numbers in the layout never contain letters so this is safe
+	names, ok := Locales[locale]
+	if !ok || locale == DefaultLocale {
+		return formatted
+	}
+	english := Locales[DefaultLocale]
+	formatted = strings.Replace(formatted, english.Days[t.Weekday()], names.Days[t.Weekday()], 1)
+	formatted = strings.Replace(formatted, english.Months[t.Month()-1], names.Months[t.Month()-1], 1)
+
+	return formatted
+}
+
+// wall clock layouts accepted besides RFC 3339
+var userTimeLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+}
+
+// UserTime is a time sent by a user. With an offset (RFC 3339) it is an exact instant,
+// without one it is a wall clock time in the user's timezone, and a bare date means the end of that day.
+type UserTime struct {
+	value    time.Time
+	wall     bool
+	dateOnly bool
+}
+
+func (u *UserTime) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		*u = UserTime{}
+		return nil
+	}
+
+	var raw string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	return u.parse(raw)
+}
+
+// ParseUserTime reads a time the way UserTime does in JSON, for values coming from query strings
+func ParseUserTime(raw string) (UserTime, error) {
+	var u UserTime
+	err := u.parse(raw)
+	return u, err
+}
+
+func (u *UserTime) parse(raw string) error {
+	raw = strings.TrimSpace(raw)
+
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		*u = UserTime{value: t}
+		return nil
+	}
+
+	for _, layout := range userTimeLayouts {
+		if t, err := time.Parse(layout, raw); err == nil {
+			*u = UserTime{value: t, wall: true}
+			return nil
+		}
+	}
+
+	if t, err := time.Parse(time.DateOnly, raw); err == nil {
+		*u = UserTime{value: t, wall: true, dateOnly: true}
+		return nil
+	}
+
+	// A fiber error so the client gets a 400 straight out of the body parser
+	return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Invalid time %q, use RFC 3339 or YYYY-MM-DD[THH:MM[:SS]]", raw))
+}
+
+func (u UserTime) IsZero() bool {
+	return u.value.IsZero()
+}
+
+// In resolves the time, wall clock values are read in loc
+func (u UserTime) In(loc *time.Location) time.Time {
+	if !u.wall {
+		return u.value
+	}
+
+	t := u.value
+	if u.dateOnly {
+		t = t.Add(24*time.Hour - time.Second)
+	}
+
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, loc)
+}
+
+// StartIn is In, except a bare date means the start of that day, for the lower end of a range
+func (u UserTime) StartIn(loc *time.Location) time.Time {
+	if !u.dateOnly {
+		return u.In(loc)
+	}
+
+	return time.Date(u.value.Year(), u.value.Month(), u.value.Day(), 0, 0, 0, 0, loc)
+}
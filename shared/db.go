@@ -2,7 +2,8 @@ package shared
 
 import (
 	"database/sql"
-	_ "github.com/go-sql-driver/mysql"
+	"errors"
+	"github.com/go-sql-driver/mysql"
 	"log/slog"
 	"os"
 	"time"
@@ -17,7 +18,9 @@ func GetConnection() *sql.DB {
 	dbHost := config.GetString("DB_HOST")
 	dbPort := config.GetString("DB_PORT")
 
-	db, err := sql.Open("mysql", dbUser+":"+dbPassword+"@tcp("+dbHost+":"+dbPort+")/"+dbName+"?charset=utf8&parseTime=True&loc=Local")
+	// Everything is stored in UTC, times are only turned into the user's timezone when shown.
+	// time_zone makes NOW() and TIMESTAMP columns agree with that.
+	db, err := sql.Open("mysql", dbUser+":"+dbPassword+"@tcp("+dbHost+":"+dbPort+")/"+dbName+"?charset=utf8&parseTime=True&loc=UTC&time_zone=%27%2B00%3A00%27")
 	if err != nil {
 		slog.Error(err.Error())
 		os.Exit(1)
@@ -43,3 +46,9 @@ func CommitOrRollback(tx *sql.Tx, err error) error {
 	}
 	return nil
 }
+
+// IsDuplicateEntry tells whether the error is MySQL refusing a row because of a UNIQUE key
+func IsDuplicateEntry(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062
+}
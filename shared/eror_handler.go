@@ -77,6 +77,20 @@ func handleValidationErrorMessage(tag string, param string, field string) string
 		msg = fmt.Sprintf("The %s field must be at least %s characters", strings.ToLower(field), param)
 	case "max":
 		msg = fmt.Sprintf("The %s field must be at most %s characters", strings.ToLower(field), param)
+	case "len":
+		msg = fmt.Sprintf("The %s field must be exactly %s characters", strings.ToLower(field), param)
+	case "numeric":
+		msg = fmt.Sprintf("The %s field must be numeric", field)
+	case "required_without":
+		msg = fmt.Sprintf("The %s field is required when %s is empty", field, toSpacedLower(param))
+	case "required_without_all":
+		others := strings.Fields(param)
+		for i, other := range others {
+			others[i] = toSpacedLower(other)
+		}
+		msg = fmt.Sprintf("The %s field is required when %s are empty", field, strings.Join(others, " and "))
+	case "oneof":
+		msg = fmt.Sprintf("The %s field must be one of: %s", field, strings.Replace(param, " ", ", ", -1))
 	case "eqfield":
 		if param == "Password" {
 			msg = "The password confirmation does not match"
@@ -87,3 +101,15 @@ func handleValidationErrorMessage(tag string, param string, field string) string
 
 	return msg
 }
+
+// toSpacedLower turns a struct field name like RecoveryCode into "recovery code", the way fields are shown in messages
+func toSpacedLower(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if i > 0 && r >= 'A' && r <= 'Z' {
+			b.WriteByte(' ')
+		}
+		b.WriteRune(r)
+	}
+	return strings.ToLower(b.String())
+}
@@ -1,117 +1,162 @@
 package shared
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var (
-	secretKey []byte
-)
+// Claims is what we put inside Hon's access token.
+// SessionId links the token to the session that issued it, so it can be told apart from the other devices.
+// Scope is a space separated list like OAuth does it, tokens without one are treated as full access.
+// Purpose is only set on single use tokens (email verification and friends), those never work as access tokens.
+type Claims struct {
+	jwt.RegisteredClaims
+	SessionId int    `json:"sid,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	Purpose   string `json:"pur,omitempty"`
+}
 
+// getSecretKey is only used for HS256, which is kept for setups without a key directory
+// and for tokens signed before the switch to asymmetric keys.
 func getSecretKey() []byte {
-	// taking the cached secret key
-	if secretKey != nil {
-		return secretKey
+	return []byte(NewConfig().GetString("JWT_SECRET_KEY"))
+}
+
+func GenerateToken(id int, sessionId int, expiry time.Time) (string, error) {
+	// Make a claim to register, using email as a subject cause why not?
+	claims := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.Itoa(id),
+			Issuer:    "Hon",
+			ExpiresAt: jwt.NewNumericDate(expiry),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+		SessionId: sessionId,
+		Scope:     strings.Join(DefaultScopes, " "),
 	}
 
-	// if cache empty, take it from ENV and convert it to []byte
-	secretKey = []byte(config.GetString("JWT_SECRET_KEY"))
+	return signClaims(claims)
+}
 
-	return secretKey
+// GeneratePurposeToken signs a token that is only good for one thing, like verifying an email.
+// tokenId ends up as the jti so the caller can remember it and refuse a second use.
+func GeneratePurposeToken(id int, purpose string, tokenId string, expiry time.Time) (string, error) {
+	claims := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenId,
+			Subject:   strconv.Itoa(id),
+			Issuer:    "Hon",
+			ExpiresAt: jwt.NewNumericDate(expiry),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+		Purpose: purpose,
+	}
+
+	return signClaims(claims)
 }
 
-func TokenMiddleware(c *fiber.Ctx) error {
-	// getting the token
-	jwtToken, err := getTokenFromRequest(c)
+// ValidatePurposeToken validates the token and makes sure it was made for this purpose
+func ValidatePurposeToken(tokenStr string, purpose string) (*Claims, error) {
+	_, claims, err := ValidateToken(tokenStr)
 	if err != nil {
-		slog.Error("Error getting token from request", "err", err)
-		return fiber.NewError(fiber.StatusUnauthorized, err.Error())
+		return nil, err
 	}
 
-	// validate the token
-	_, _, err = ValidateToken(jwtToken)
-	if err != nil {
-		return fiber.NewError(fiber.StatusUnauthorized, "Token Invalid")
+	if claims.Purpose != purpose || claims.ID == "" {
+		return nil, fmt.Errorf("invalid token")
 	}
 
-	return c.Next()
+	return claims, nil
 }
 
-func GenerateToken(id int, expiry time.Time) (string, error) {
-	// Make a claim to register, using email as a subject cause why not?
-	claims := jwt.RegisteredClaims{
-		Subject:   strconv.Itoa(id),
-		Issuer:    "Hon",
-		ExpiresAt: jwt.NewNumericDate(expiry),
-		NotBefore: jwt.NewNumericDate(time.Now()),
-		IssuedAt:  jwt.NewNumericDate(time.Now()),
+func signClaims(claims Claims) (string, error) {
+	// Sign with the current key of the key set, the kid header tells verifiers which public key to pick
+	if set := getKeySet(); set != nil {
+		key := set.signer()
+		if key == nil {
+			return "", errors.New("no signing key available")
+		}
+
+		token := jwt.NewWithClaims(key.Method, claims)
+		token.Header["kid"] = key.Id
+		return token.SignedString(key.Private)
 	}
 
-	//  Generate a secret key
+	// No key set configured, fall back to HS256 with the shared secret
 	secret := getSecretKey()
+	if len(secret) == 0 {
+		return "", errors.New("no JWT key configured")
+	}
 
-	//  Create and sign the token using the secret key
-	//  Signing method using HS256 cause why not
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString(secret)
 }
 
-func ValidateToken(tokenStr string) (*jwt.Token, *jwt.RegisteredClaims, error) {
-	// Create a instance or new claims to make sure if the parsed claims are type of RegisteredClaims
-	claims := &jwt.RegisteredClaims{}
-	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-		// Validate the algorithm
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		// Return the secret key for validation
-		return getSecretKey(), nil
-	})
-
-	// Checks if the token valid.
-	if err != nil || !token.Valid {
-		return nil, nil, fmt.Errorf("invalid token")
+// acceptHS256 tells whether HS256 tokens are still good. Without a key set they're the only kind there is.
+// With one, anybody holding the shared secret could mint tokens every verifier takes, so they're refused
+// unless JWT_HS256_UNTIL (RFC 3339) is set to let the tokens from before the switch run out first.
+func acceptHS256() bool {
+	if getKeySet() == nil {
+		return true
 	}
 
-	return token, claims, nil
-}
-
-func getTokenFromRequest(c *fiber.Ctx) (string, error) {
-	//  get the token
-	token := c.Get("Authorization")
+	until := NewConfig().GetString("JWT_HS256_UNTIL")
+	if until == "" {
+		return false
+	}
 
-	// checks if the token empty or nah
-	if token == "" {
-		return token, fiber.NewError(fiber.StatusUnauthorized, "Token Not Found")
+	cutoff, err := time.Parse(time.RFC3339, until)
+	if err != nil {
+		slog.Error("JWT_HS256_UNTIL is not an RFC 3339 time, refusing HS256 tokens", "value", until)
+		return false
 	}
 
-	return token, nil
+	return time.Now().Before(cutoff)
 }
 
-func GetSubjectFromToken(c *fiber.Ctx) (int, error) {
-	// get token with function
-	token, err := getTokenFromRequest(c)
-	if err != nil {
-		return 0, fiber.NewError(fiber.StatusUnauthorized, err.Error())
-	}
+func ValidateToken(tokenStr string) (*jwt.Token, *Claims, error) {
+	// Create a instance or new claims to make sure if the parsed claims are type of Claims
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+		// HS256 is only for setups without a key set, and for their old tokens until the cutoff after switching
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+			secret := getSecretKey()
+			if len(secret) == 0 || !acceptHS256() {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return secret, nil
+		}
 
-	// validate token and get the subject a.k.a email
-	_, claims, err := ValidateToken(token)
-	if err != nil {
-		return 0, fiber.NewError(fiber.StatusUnauthorized, err.Error())
-	}
+		set := getKeySet()
+		if set == nil {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
 
-	// converts from string to int
-	id, err := strconv.Atoi(claims.Subject)
-	if err != nil {
-		return 0, err
+		// Pick the key by kid, and make sure the token's alg is the one that key is meant for
+		kid, _ := token.Header["kid"].(string)
+		key, err := set.lookup(kid)
+		if err != nil {
+			return nil, err
+		}
+		if key.Method.Alg() != token.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+
+		return key.Public, nil
+	}, jwt.WithValidMethods([]string{"HS256", "RS256", "EdDSA"}), jwt.WithIssuer("Hon"))
+
+	// Checks if the token valid.
+	if err != nil || !token.Valid {
+		return nil, nil, fmt.Errorf("invalid token")
 	}
 
-	return id, nil
+	return token, claims, nil
 }
@@ -0,0 +1,460 @@
+package shared
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// signingKey is one key of the key set. Keys loaded from a public PEM or from a remote JWKS can only verify.
+type signingKey struct {
+	Id        string
+	Method    jwt.SigningMethod
+	Private   crypto.Signer
+	Public    crypto.PublicKey
+	CreatedAt time.Time
+}
+
+// KeySet holds every key Hon currently accepts, and the one it signs with.
+// It either lives in a directory of PEM files (the producer) or is fetched from a JWKS url (everyone else).
+type KeySet struct {
+	mu      sync.RWMutex
+	dir     string
+	jwksURL string
+	// pinned is JWT_SIGNING_KEY_ID, a pinned key is never rotated away automatically
+	pinned      string
+	keys        map[string]*signingKey
+	current     *signingKey
+	lastFetched time.Time
+}
+
+var (
+	keySet     *KeySet
+	keySetOnce sync.Once
+)
+
+// getKeySet returns nil when neither JWT_KEY_DIR nor JWT_JWKS_URL is configured, tokens then fall back to HS256.
+func getKeySet() *KeySet {
+	keySetOnce.Do(func() {
+		config := NewConfig()
+		dir := config.GetString("JWT_KEY_DIR")
+		jwksURL := config.GetString("JWT_JWKS_URL")
+
+		switch {
+		case dir != "":
+			set := &KeySet{dir: dir, keys: map[string]*signingKey{}}
+			if err := set.Reload(); err != nil {
+				slog.Error("Failed to load JWT keys", "dir", dir, "err", err)
+				os.Exit(1)
+			}
+			// A fresh directory gets its first key right away
+			if set.current == nil {
+				if err := set.Rotate(); err != nil {
+					slog.Error("Failed to generate JWT key", "dir", dir, "err", err)
+					os.Exit(1)
+				}
+			}
+			keySet = set
+		case jwksURL != "":
+			set := &KeySet{jwksURL: jwksURL, keys: map[string]*signingKey{}}
+			if err := set.fetchJWKS(); err != nil {
+				slog.Error("Failed to fetch JWKS, will retry on demand", "url", jwksURL, "err", err)
+			}
+			keySet = set
+		}
+	})
+
+	return keySet
+}
+
+// Reload reads every *.pem file of the key directory again, so keys added by another instance get picked up.
+func (k *KeySet) Reload() error {
+	if err := os.MkdirAll(k.dir, 0o700); err != nil {
+		return err
+	}
+
+	files, err := filepath.Glob(filepath.Join(k.dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+
+	keys := map[string]*signingKey{}
+	for _, file := range files {
+		key, err := loadKeyFile(file)
+		if err != nil {
+			slog.Error("Skipping unreadable JWT key", "file", file, "err", err)
+			continue
+		}
+		keys[key.Id] = key
+	}
+
+	// Pin the signing key if asked to, otherwise the newest private key wins
+	var current *signingKey
+	pinned := NewConfig().GetString("JWT_SIGNING_KEY_ID")
+	for _, key := range keys {
+		if key.Private == nil {
+			continue
+		}
+		if pinned != "" {
+			if key.Id == pinned {
+				current = key
+			}
+			continue
+		}
+		if current == nil || key.CreatedAt.After(current.CreatedAt) {
+			current = key
+		}
+	}
+
+	// Signing with some other key than the one asked for would be a surprise, so say it and keep the old set
+	if pinned != "" && current == nil {
+		return fmt.Errorf("pinned JWT signing key %q not found in %s", pinned, k.dir)
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.current = current
+	k.pinned = pinned
+	k.mu.Unlock()
+
+	return nil
+}
+
+// Rotate generates a brand new signing key, the previous ones stay around for verification until pruned.
+func (k *KeySet) Rotate() error {
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	id := time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+
+	var private crypto.Signer
+	var err error
+	switch NewConfig().GetString("JWT_SIGNING_ALG") {
+	case "RS256":
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "", "EdDSA":
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return fmt.Errorf("unsupported JWT_SIGNING_ALG %q", NewConfig().GetString("JWT_SIGNING_ALG"))
+	}
+	if err != nil {
+		return err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temp file first, other instances reloading the dir should never see half a key
+	path := filepath.Join(k.dir, id+".pem")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	slog.Info("Generated new JWT signing key", "kid", id)
+
+	return k.Reload()
+}
+
+// prune removes keys that stopped signing long enough ago that no token signed by them can still be valid.
+func (k *KeySet) prune(maxAge time.Duration) {
+	k.mu.RLock()
+	var expired []string
+	for id, key := range k.keys {
+		if key == k.current || key.Private == nil {
+			continue
+		}
+		if time.Since(key.CreatedAt) > maxAge {
+			expired = append(expired, id)
+		}
+	}
+	k.mu.RUnlock()
+
+	for _, id := range expired {
+		if err := os.Remove(filepath.Join(k.dir, id+".pem")); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Error("Failed to prune JWT key", "kid", id, "err", err)
+			continue
+		}
+		slog.Info("Pruned old JWT signing key", "kid", id)
+	}
+}
+
+// StartKeyRotation keeps the key directory fresh in the background: reloads it, rotates the signing key
+// once it's older than JWT_KEY_ROTATION_INTERVAL unless JWT_SIGNING_KEY_ID pins it, and prunes keys past JWT_KEY_RETENTION.
+// Does nothing when the key set isn't backed by a directory.
+func StartKeyRotation(ctx context.Context) {
+	set := getKeySet()
+	if set == nil || set.dir == "" {
+		return
+	}
+
+	interval := GetDuration("JWT_KEY_ROTATION_INTERVAL", 30*24*time.Hour)
+	retention := GetDuration("JWT_KEY_RETENTION", 24*time.Hour)
+
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if err := set.Reload(); err != nil {
+				slog.Error("Failed to reload JWT keys", "err", err)
+				continue
+			}
+
+			// A pinned key stays until it's unpinned, rotating would only pile up keys nobody signs with
+			set.mu.RLock()
+			due := set.pinned == "" && (set.current == nil || time.Since(set.current.CreatedAt) > interval)
+			set.mu.RUnlock()
+
+			if due {
+				if err := set.Rotate(); err != nil {
+					slog.Error("Failed to rotate JWT key", "err", err)
+					continue
+				}
+			}
+
+			// A key signs for one interval at most, tokens it signed may live for the retention after that
+			set.prune(interval + retention)
+		}
+	}()
+}
+
+func (k *KeySet) signer() *signingKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current
+}
+
+// lookup finds the verification key for a kid, remote key sets get refetched once when the kid is unknown
+func (k *KeySet) lookup(id string) (*signingKey, error) {
+	k.mu.RLock()
+	key, ok := k.keys[id]
+	stale := k.jwksURL != "" && time.Since(k.lastFetched) > 10*time.Minute
+	k.mu.RUnlock()
+
+	if k.jwksURL != "" && (!ok || stale) {
+		// Don't let a flood of garbage kids hammer the JWKS endpoint
+		k.mu.RLock()
+		recently := time.Since(k.lastFetched) < time.Minute
+		k.mu.RUnlock()
+
+		if !recently {
+			if err := k.fetchJWKS(); err != nil {
+				slog.Error("Failed to refresh JWKS", "url", k.jwksURL, "err", err)
+			}
+			k.mu.RLock()
+			key, ok = k.keys[id]
+			k.mu.RUnlock()
+		}
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", id)
+	}
+
+	return key, nil
+}
+
+func loadKeyFile(path string) (*signingKey, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	key := &signingKey{
+		Id:        strings.TrimSuffix(filepath.Base(path), ".pem"),
+		CreatedAt: info.ModTime(),
+	}
+
+	var parsed any
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch parsed := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Method, key.Private, key.Public = jwt.SigningMethodRS256, parsed, parsed.Public()
+	case ed25519.PrivateKey:
+		key.Method, key.Private, key.Public = jwt.SigningMethodEdDSA, parsed, parsed.Public()
+	case *rsa.PublicKey:
+		key.Method, key.Public = jwt.SigningMethodRS256, parsed
+	case ed25519.PublicKey:
+		key.Method, key.Public = jwt.SigningMethodEdDSA, parsed
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+
+	return key, nil
+}
+
+// JWK is the json representation of a public key, only the fields needed for RSA and Ed25519
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+func (k *KeySet) jwks() JWKSet {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range k.keys {
+		jwk := JWK{Kid: key.Id, Alg: key.Method.Alg(), Use: "sig"}
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set
+}
+
+func (k *KeySet) fetchJWKS() error {
+	k.mu.Lock()
+	k.lastFetched = time.Now()
+	k.mu.Unlock()
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(k.jwksURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var set JWKSet
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return err
+	}
+
+	keys := map[string]*signingKey{}
+	for _, jwk := range set.Keys {
+		key, err := parseJWK(jwk)
+		if err != nil {
+			slog.Error("Skipping unsupported JWK", "kid", jwk.Kid, "err", err)
+			continue
+		}
+		keys[key.Id] = key
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.mu.Unlock()
+
+	return nil
+}
+
+func parseJWK(jwk JWK) (*signingKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		public := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		return &signingKey{Id: jwk.Kid, Method: jwt.SigningMethodRS256, Public: public}, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key size")
+		}
+		return &signingKey{Id: jwk.Kid, Method: jwt.SigningMethodEdDSA, Public: ed25519.PublicKey(x)}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+}
+
+// JWKSHandler serves the public half of every active key, so other services can verify Hon tokens without a shared secret
+func JWKSHandler(c *fiber.Ctx) error {
+	set := getKeySet()
+	if set == nil {
+		return c.Status(fiber.StatusOK).JSON(JWKSet{Keys: []JWK{}})
+	}
+
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.Status(fiber.StatusOK).JSON(set.jwks())
+}
@@ -0,0 +1,297 @@
+package shared
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BookMetadata is what a provider knows about an ISBN
+type BookMetadata struct {
+	ISBN   string `json:"isbn"`
+	Title  string `json:"title"`
+	Author string `json:"author"`
+	// Pages is 0 when the provider doesn't know it
+	Pages int `json:"pages"`
+}
+
+// MetadataProvider looks a book up by its ISBN, which is always the normalised ISBN-13
+type MetadataProvider interface {
+	Lookup(ctx context.Context, isbn string) (*BookMetadata, error)
+}
+
+var (
+	ErrMetadataNotFound = errors.New("no book found for the isbn")
+	ErrInvalidISBN      = errors.New("not a valid ISBN-10 or ISBN-13")
+)
+
+// PlaceholderAuthor stands in for the author of a book whose details are still being fetched
+const PlaceholderAuthor = "Unknown author"
+
+// PlaceholderTitle stands in for the title of a book whose details are still being fetched
+func PlaceholderTitle(isbn string) string {
+	return "ISBN " + isbn
+}
+
+// NormalizeISBN checks the check digit of an ISBN-10 or ISBN-13 and returns it as ISBN-13, hyphens and spaces are dropped
+func NormalizeISBN(raw string) (string, error) {
+	isbn := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(raw)))
+
+	switch len(isbn) {
+	case 10:
+		sum := 0
+		for i, r := range isbn {
+			digit := int(r - '0')
+			if r == 'X' && i == 9 {
+				digit = 10
+			} else if r < '0' || r > '9' {
+				return "", ErrInvalidISBN
+			}
+			sum += digit * (10 - i)
+		}
+		if sum%11 != 0 {
+			return "", ErrInvalidISBN
+		}
+		// Same book as 978 plus the first nine digits, with the check digit done again
+		isbn = "978" + isbn[:9]
+		return isbn + string(rune('0'+isbn13Check(isbn))), nil
+	case 13:
+		for _, r := range isbn {
+			if r < '0' || r > '9' {
+				return "", ErrInvalidISBN
+			}
+		}
+		if int(isbn[12]-'0') != isbn13Check(isbn[:12]) {
+			return "", ErrInvalidISBN
+		}
+		return isbn, nil
+	}
+
+	return "", ErrInvalidISBN
+}
+
+// isbn13Check is the check digit of the first twelve digits of an ISBN-13
+func isbn13Check(digits string) int {
+	sum := 0
+	for i, r := range digits {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += int(r-'0') * weight
+	}
+
+	return (10 - sum%10) % 10
+}
+
+// OpenLibraryProvider asks the Open Library books API, or anything answering like it, e.g. a stub server in tests
+type OpenLibraryProvider struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+type openLibraryBook struct {
+	Title         string `json:"title"`
+	NumberOfPages int    `json:"number_of_pages"`
+	Authors       []struct {
+		Name string `json:"name"`
+	} `json:"authors"`
+}
+
+func (p *OpenLibraryProvider) Lookup(ctx context.Context, isbn string) (*BookMetadata, error) {
+	bibkey := "ISBN:" + isbn
+	query := url.Values{"bibkeys": {bibkey}, "format": {"json"}, "jscmd": {"data"}}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(p.BaseURL, "/")+"/api/books?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrMetadataNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	// Books it doesn't know are simply missing from the answer
+	var books map[string]openLibraryBook
+	if err := json.NewDecoder(resp.Body).Decode(&books); err != nil {
+		return nil, err
+	}
+	book, ok := books[bibkey]
+	if !ok || book.Title == "" {
+		return nil, ErrMetadataNotFound
+	}
+
+	var authors []string
+	for _, author := range book.Authors {
+		authors = append(authors, author.Name)
+	}
+
+	return cleanMetadata(&BookMetadata{
+		ISBN:   isbn,
+		Title:  book.Title,
+		Author: JoinAuthors(authors),
+		Pages:  book.NumberOfPages,
+	}), nil
+}
+
+// FileMetadataProvider answers from a JSON file holding an array of BookMetadata, for setups without internet access.
+// The file is read on the first lookup, ISBN-10s in it work too.
+type FileMetadataProvider struct {
+	Path string
+
+	once  sync.Once
+	books map[string]*BookMetadata
+	err   error
+}
+
+func (p *FileMetadataProvider) Lookup(ctx context.Context, isbn string) (*BookMetadata, error) {
+	p.once.Do(func() {
+		raw, err := os.ReadFile(p.Path)
+		if err != nil {
+			p.err = err
+			return
+		}
+
+		var books []*BookMetadata
+		if err := json.Unmarshal(raw, &books); err != nil {
+			p.err = err
+			return
+		}
+
+		p.books = map[string]*BookMetadata{}
+		for _, book := range books {
+			normalized, err := NormalizeISBN(book.ISBN)
+			if err != nil || book.Title == "" {
+				slog.Warn("Skipping book with invalid ISBN or no title in metadata file", "isbn", book.ISBN)
+				continue
+			}
+			book.ISBN = normalized
+			p.books[normalized] = cleanMetadata(book)
+		}
+	})
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	book, ok := p.books[isbn]
+	if !ok {
+		return nil, ErrMetadataNotFound
+	}
+
+	copied := *book
+	return &copied, nil
+}
+
+// cleanMetadata trims what a provider returned so it fits the books table
+func cleanMetadata(meta *BookMetadata) *BookMetadata {
+	clip := func(value string) string {
+		runes := []rune(strings.TrimSpace(value))
+		if len(runes) > 255 {
+			runes = runes[:255]
+		}
+		return string(runes)
+	}
+
+	meta.Title = clip(meta.Title)
+	meta.Author = clip(meta.Author)
+	if meta.Pages < 0 {
+		meta.Pages = 0
+	}
+
+	return meta
+}
+
+var (
+	metadataProvider     MetadataProvider
+	metadataProviderOnce sync.Once
+)
+
+// GetMetadataProvider picks the provider from METADATA_PROVIDER: openlibrary (the default), file or none.
+// It returns nil for none, books then can't be created from an ISBN alone.
+func GetMetadataProvider() MetadataProvider {
+	metadataProviderOnce.Do(func() {
+		config := NewConfig()
+
+		switch config.GetString("METADATA_PROVIDER") {
+		case "none":
+			return
+		case "file":
+			metadataProvider = &FileMetadataProvider{Path: config.GetString("METADATA_FILE")}
+		default:
+			baseURL := config.GetString("METADATA_BASE_URL")
+			if baseURL == "" {
+				baseURL = "https://openlibrary.org"
+			}
+			// The callers put their own deadline on every lookup
+			metadataProvider = &OpenLibraryProvider{BaseURL: baseURL, Client: &http.Client{}}
+		}
+	})
+
+	return metadataProvider
+}
+
+// metadataMissTTL is how long a miss stays cached, books do get added to the providers
+const metadataMissTTL = 24 * time.Hour
+
+// LookupMetadata asks the isbn_metadata cache first and the provider after, what the provider says is cached either way.
+// Only ErrMetadataNotFound is cached as a miss, a timeout or a provider being down is tried again next time.
+func LookupMetadata(ctx context.Context, db *sql.DB, provider MetadataProvider, isbn string) (*BookMetadata, error) {
+	var title, author sql.NullString
+	var pages sql.NullInt64
+	var found bool
+
+	query := "SELECT title, author, total_pages, found FROM isbn_metadata WHERE isbn = ? AND (found OR fetched_at > ?)"
+
+	err := db.QueryRowContext(ctx, query, isbn, time.Now().Add(-metadataMissTTL)).Scan(&title, &author, &pages, &found)
+	if err == nil {
+		if !found {
+			return nil, ErrMetadataNotFound
+		}
+		return &BookMetadata{ISBN: isbn, Title: title.String, Author: author.String, Pages: int(pages.Int64)}, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	meta, err := provider.Lookup(ctx, isbn)
+	if err != nil && !errors.Is(err, ErrMetadataNotFound) {
+		return nil, err
+	}
+
+	query = `INSERT INTO isbn_metadata (isbn, title, author, total_pages, found, fetched_at) VALUES (?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE title = VALUES(title), author = VALUES(author), total_pages = VALUES(total_pages),
+		found = VALUES(found), fetched_at = VALUES(fetched_at)`
+
+	args := []any{isbn, nil, nil, nil, false, time.Now()}
+	if meta != nil {
+		args = []any{isbn, meta.Title, meta.Author, meta.Pages, true, time.Now()}
+	}
+
+	// The caller has its answer already, a cache that can't be written only costs a lookup later
+	if _, cacheErr := db.ExecContext(context.WithoutCancel(ctx), query, args...); cacheErr != nil {
+		slog.Error("Error while caching isbn metadata", "isbn", isbn, "err", cacheErr)
+	}
+
+	if meta == nil {
+		return nil, ErrMetadataNotFound
+	}
+	return meta, nil
+}
@@ -0,0 +1,43 @@
+package shared
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalizeISBN(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    string
+		wantErr error
+	}{
+		{name: "isbn-13", raw: "9780306406157", want: "9780306406157"},
+		{name: "isbn-13 with hyphens", raw: "978-0-306-40615-7", want: "9780306406157"},
+		{name: "isbn-13 with spaces around and inside", raw: " 978 0 306 40615 7 ", want: "9780306406157"},
+		{name: "isbn-10", raw: "0306406152", want: "9780306406157"},
+		{name: "isbn-10 with hyphens", raw: "0-441-47812-3", want: "9780441478125"},
+		{name: "isbn-10 ending in X", raw: "043942089X", want: "9780439420891"},
+		{name: "isbn-10 ending in lowercase x", raw: "043942089x", want: "9780439420891"},
+		{name: "isbn-13 with a wrong check digit", raw: "9780306406158", wantErr: ErrInvalidISBN},
+		{name: "isbn-10 with a wrong check digit", raw: "0306406153", wantErr: ErrInvalidISBN},
+		{name: "X anywhere but the end", raw: "03064X6152", wantErr: ErrInvalidISBN},
+		{name: "X on an isbn-13", raw: "978030640615X", wantErr: ErrInvalidISBN},
+		{name: "letters", raw: "97803064O6157", wantErr: ErrInvalidISBN},
+		{name: "too short", raw: "030640615", wantErr: ErrInvalidISBN},
+		{name: "too long", raw: "97803064061570", wantErr: ErrInvalidISBN},
+		{name: "empty", raw: "", wantErr: ErrInvalidISBN},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeISBN(tt.raw)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NormalizeISBN(%q) err = %v, want %v", tt.raw, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("NormalizeISBN(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
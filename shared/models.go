@@ -2,11 +2,54 @@ package shared
 
 import "time"
 
+// HeaderRetrigger marks a goal message an admin sent again by hand.
+// The consumer then mails it even though the goal already got its final status.
+const HeaderRetrigger = "x-retrigger"
+
 type Msg struct {
+	Recipient
 	Id         int       `json:"id"`
 	Email      string    `json:"email"`
 	Name       string    `json:"name"`
 	BookTitle  string    `json:"book_title"`
+	CoverUrl   *string   `json:"cover_url"`
 	TargetPage int       `json:"target_page"`
+	Target     string    `json:"target"` // TargetPage written in the book's unit, e.g. "45%" or "3:20:00"
 	ExpiredAt  time.Time `json:"expired_at"`
 }
+
+type VerificationMsg struct {
+	Recipient
+	Email     string    `json:"email"`
+	Link      string    `json:"link"`
+	ExpiredAt time.Time `json:"expired_at"`
+}
+
+type PasswordResetMsg struct {
+	Recipient
+	Email     string    `json:"email"`
+	Token     string    `json:"token"`
+	Link      string    `json:"link"`
+	ExpiredAt time.Time `json:"expired_at"`
+}
+
+type LockoutMsg struct {
+	Recipient
+	Email       string    `json:"email"`
+	IpAddress   string    `json:"ip_address"`
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// ImportMsg asks the consumer to run an import job, the CSV itself waits in import_jobs
+type ImportMsg struct {
+	Recipient
+	JobId int    `json:"job_id"`
+	Email string `json:"email"`
+}
+
+// MetadataMsg asks the consumer to fetch the details of a book the provider was too slow for
+type MetadataMsg struct {
+	BookId int    `json:"book_id"`
+	ISBN   string `json:"isbn"`
+}
@@ -0,0 +1,88 @@
+package shared
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// bcrypt silently truncates anything past 72 bytes, so there is no point in accepting longer passwords.
+const maxPasswordBytes = 72
+
+var ErrPasswordTooLong = errors.New("password exceeds 72 bytes")
+
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// getBcryptCost reads BCRYPT_COST from config and keeps it inside the range bcrypt accepts.
+func getBcryptCost() int {
+	cost := NewConfig().GetInt("BCRYPT_COST")
+	if cost == 0 {
+		return bcrypt.DefaultCost
+	}
+
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		slog.Warn("BCRYPT_COST out of range, falling back to default", "cost", cost)
+		return bcrypt.DefaultCost
+	}
+
+	return cost
+}
+
+// IsPasswordHash tells whether the stored value is a bcrypt hash or a legacy plaintext password.
+func IsPasswordHash(stored string) bool {
+	return strings.HasPrefix(stored, "$2a$") ||
+		strings.HasPrefix(stored, "$2b$") ||
+		strings.HasPrefix(stored, "$2y$")
+}
+
+func HashPassword(password string) (string, error) {
+	if len(password) > maxPasswordBytes {
+		return "", ErrPasswordTooLong
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), getBcryptCost())
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+// VerifyPassword checks the password against whatever is stored in users.password.
+// The second return value tells the caller the stored value should be replaced with a fresh hash,
+// either because it is a legacy plaintext password or because it was hashed with a weaker cost.
+func VerifyPassword(stored string, password string) (bool, bool) {
+	// Legacy rows still hold the raw password, compare it in constant time and ask for an upgrade
+	if !IsPasswordHash(stored) {
+		ok := subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+		return ok, ok
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)); err != nil {
+		return false, false
+	}
+
+	cost, err := bcrypt.Cost([]byte(stored))
+	if err != nil {
+		return true, true
+	}
+
+	return true, cost < getBcryptCost()
+}
+
+// SimulatePasswordCheck spends the same time a real bcrypt comparison would.
+// Used when the account doesn't exist, so response times don't tell which emails are registered.
+func SimulatePasswordCheck(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("hon-dummy-password"), getBcryptCost())
+	})
+
+	_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+}
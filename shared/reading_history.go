@@ -0,0 +1,203 @@
+package shared
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reading history exports other trackers let their users download, both are CSV with a header row
+const (
+	ImportSourceGoodreads  = "goodreads"
+	ImportSourceStoryGraph = "storygraph"
+)
+
+// ImportSourceNames are the sources the way they are written to users
+var ImportSourceNames = map[string]string{
+	ImportSourceGoodreads:  "Goodreads",
+	ImportSourceStoryGraph: "StoryGraph",
+}
+
+var ErrUnknownImportFormat = errors.New("the file is not a Goodreads or StoryGraph export")
+
+// ImportedBook is one row of an export, already mapped to what Hon knows
+type ImportedBook struct {
+	Row    int
+	Title  string
+	Author string
+	ISBN   string
+	// Pages is 0 when the export doesn't know it, StoryGraph never does
+	Pages int
+	// Format is paper, ebook or audiobook
+	Format string
+	// Status is the Hon book status the shelf maps to
+	Status     string
+	Shelf      string
+	AddedAt    time.Time
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// ImportStatus maps a shelf (Goodreads) or read status (StoryGraph) to a Hon book status.
+// Custom shelves nobody can guess the meaning of end up on the want-to-read list.
+func ImportStatus(shelf string) string {
+	switch strings.ToLower(strings.TrimSpace(shelf)) {
+	case "read":
+		return "completed"
+	case "currently-reading", "reading":
+		return "reading"
+	case "did-not-finish", "dnf", "abandoned":
+		return "abandoned"
+	case "paused", "on-hold":
+		return "paused"
+	}
+
+	return "want-to-read"
+}
+
+// ExportShelf is the other way around, the Goodreads shelf a Hon book status goes on.
+// Goodreads only has read, currently-reading and to-read, the rest become custom shelves ImportStatus reads back.
+func ExportShelf(status string) string {
+	switch status {
+	case "completed":
+		return "read"
+	case "reading":
+		return "currently-reading"
+	case "abandoned":
+		return "did-not-finish"
+	case "paused":
+		return "paused"
+	}
+
+	return "to-read"
+}
+
+// ParseReadingHistory reads a Goodreads or StoryGraph export and tells which one it was.
+// Dates in the exports carry no time, they are placed at noon in loc so they stay on the same day.
+func ParseReadingHistory(r io.Reader, loc *time.Location) (string, []*ImportedBook, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return "", nil, ErrUnknownImportFormat
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))] = i
+	}
+
+	source := ""
+	switch {
+	case hasColumn(columns, "Exclusive Shelf"):
+		source = ImportSourceGoodreads
+	case hasColumn(columns, "Read Status"):
+		source = ImportSourceStoryGraph
+	default:
+		return "", nil, ErrUnknownImportFormat
+	}
+
+	var books []*ImportedBook
+	for row := 2; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return source, nil, err
+		}
+
+		field := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		book := &ImportedBook{Row: row, Title: field("Title")}
+		if source == ImportSourceGoodreads {
+			// Co-authors, translators and the like, a comma separated list of full names
+			authors := []string{field("Author")}
+			if additional := field("Additional Authors"); additional != "" {
+				authors = append(authors, strings.Split(additional, ",")...)
+			}
+			book.Author = JoinAuthors(CleanAuthors(authors))
+			book.ISBN = cleanISBN(field("ISBN13"))
+			if book.ISBN == "" {
+				book.ISBN = cleanISBN(field("ISBN"))
+			}
+			book.Pages, _ = strconv.Atoi(field("Number of Pages"))
+			book.Format = importFormat(field("Binding"))
+			book.Shelf = field("Exclusive Shelf")
+			book.AddedAt = parseImportDate(field("Date Added"), loc)
+			book.FinishedAt = parseImportDate(field("Date Read"), loc)
+		} else {
+			// A comma separated list of full names, like Goodreads' additional authors
+			book.Author = JoinAuthors(CleanAuthors(strings.Split(field("Authors"), ",")))
+			book.ISBN = cleanISBN(field("ISBN/UID"))
+			book.Format = importFormat(field("Format"))
+			book.Shelf = field("Read Status")
+			book.AddedAt = parseImportDate(field("Date Added"), loc)
+			book.FinishedAt = parseImportDate(field("Last Date Read"), loc)
+			// Dates Read looks like 2021/01/01-2021/01/15, several reads are separated by commas and the last one counts
+			if dates := field("Dates Read"); dates != "" {
+				reads := strings.Split(dates, ",")
+				started, _, _ := strings.Cut(strings.TrimSpace(reads[len(reads)-1]), "-")
+				book.StartedAt = parseImportDate(started, loc)
+			}
+		}
+		book.Status = ImportStatus(book.Shelf)
+
+		books = append(books, book)
+	}
+
+	return source, books, nil
+}
+
+func hasColumn(columns map[string]int, name string) bool {
+	_, ok := columns[name]
+	return ok
+}
+
+// cleanISBN drops the ="..." Goodreads wraps ISBNs in to keep spreadsheets from eating the leading zeros
+func cleanISBN(raw string) string {
+	raw = strings.TrimPrefix(raw, "=")
+	raw = strings.Trim(raw, `"`)
+	// StoryGraph puts its own ids in the same column when there is no ISBN
+	for _, r := range raw {
+		if (r < '0' || r > '9') && r != 'X' && r != 'x' {
+			return ""
+		}
+	}
+	return raw
+}
+
+// importFormat guesses the format from a Goodreads binding or a StoryGraph format
+func importFormat(raw string) string {
+	raw = strings.ToLower(raw)
+	switch {
+	case strings.Contains(raw, "audio"):
+		return "audiobook"
+	case strings.Contains(raw, "kindle"), strings.Contains(raw, "ebook"), strings.Contains(raw, "nook"), strings.Contains(raw, "digital"):
+		return "ebook"
+	}
+
+	return "paper"
+}
+
+func parseImportDate(raw string, loc *time.Location) time.Time {
+	raw = strings.TrimSpace(raw)
+	for _, layout := range []string{"2006/01/02", "2006-01-02", "2006/1/2"} {
+		if t, err := time.ParseInLocation(layout, raw, loc); err == nil {
+			return t.Add(12 * time.Hour)
+		}
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,133 @@
+package shared
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Headers as Goodreads and StoryGraph write them in their exports
+const (
+	goodreadsHeader  = "Book Id,Title,Author,Author l-f,Additional Authors,ISBN,ISBN13,My Rating,Average Rating,Publisher,Binding,Number of Pages,Year Published,Original Publication Year,Date Read,Date Added,Bookshelves,Bookshelves with positions,Exclusive Shelf,My Review,Spoiler,Private Notes,Read Count,Owned Copies\n"
+	storyGraphHeader = "Title,Authors,Contributors,ISBN/UID,Format,Read Status,Date Added,Last Date Read,Dates Read,Read Count,Moods,Pace,Character- or Plot-Driven?,Strong Character Development?,Loveable Characters?,Diverse Characters?,Flawed Characters?,Star Rating,Review,Content Warnings,Content Warning Description,Tags,Owned?\n"
+)
+
+func noon(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 12, 0, 0, 0, time.UTC)
+}
+
+func TestParseReadingHistory(t *testing.T) {
+	tests := []struct {
+		name       string
+		csv        string
+		wantSource string
+		wantBooks  []*ImportedBook
+		wantErr    error
+	}{
+		{
+			name: "goodreads",
+			csv: goodreadsHeader +
+				`4,"The Left Hand of Darkness","Ursula K. Le Guin","Le Guin, Ursula K.","","=""0441478123""","=""9780441478125""",5,4.09,"Ace",Mass Market Paperback,304,1987,1969,2021/03/14,2020/12/01,,,read,,,,1,0` + "\n" +
+				`12067,"Good Omens","Neil Gaiman","Gaiman, Neil","Terry Pratchett","=""""","=""""",0,4.25,"William Morrow",Kindle Edition,,2006,1990,,2023/01/05,currently-reading,currently-reading (#1),currently-reading,,,,0,0` + "\n",
+			wantSource: ImportSourceGoodreads,
+			wantBooks: []*ImportedBook{
+				{
+					Row: 2, Title: "The Left Hand of Darkness", Author: "Ursula K. Le Guin", ISBN: "9780441478125", Pages: 304,
+					Format: "paper", Status: "completed", Shelf: "read", AddedAt: noon(2020, time.December, 1), FinishedAt: noon(2021, time.March, 14),
+				},
+				{
+					Row: 3, Title: "Good Omens", Author: "Neil Gaiman; Terry Pratchett",
+					Format: "ebook", Status: "reading", Shelf: "currently-reading", AddedAt: noon(2023, time.January, 5),
+				},
+			},
+		},
+		{
+			name: "goodreads saved back with a BOM by a spreadsheet, only an ISBN-10",
+			csv: "\ufeff" + goodreadsHeader +
+				`1,"Dune","Frank Herbert","Herbert, Frank","","=""0441013597""","=""""",4,4.27,"Ace",Paperback,658,2005,1965,,2024/02/29,to-read,to-read (#3),to-read,,,,0,0` + "\n",
+			wantSource: ImportSourceGoodreads,
+			wantBooks: []*ImportedBook{
+				{
+					Row: 2, Title: "Dune", Author: "Frank Herbert", ISBN: "0441013597", Pages: 658,
+					Format: "paper", Status: "want-to-read", Shelf: "to-read", AddedAt: noon(2024, time.February, 29),
+				},
+			},
+		},
+		{
+			name: "storygraph",
+			csv: storyGraphHeader +
+				`Good Omens,"Neil Gaiman, Terry Pratchett",,9780060853983,digital,read,2022/05/01,2022/06/10,"2021/01/01-2021/01/15, 2022/05/20-2022/06/10",2,funny,medium,Plot,No,Yes,No,Yes,4.5,,,,,No` + "\n" +
+				`The Dispossessed,Ursula K. Le Guin,,a3c5e2f0-uid,audio,did-not-finish,2023/07/14,,,0,,,,,,,,,,,,,No` + "\n",
+			wantSource: ImportSourceStoryGraph,
+			wantBooks: []*ImportedBook{
+				{
+					Row: 2, Title: "Good Omens", Author: "Neil Gaiman; Terry Pratchett", ISBN: "9780060853983",
+					Format: "ebook", Status: "completed", Shelf: "read",
+					AddedAt: noon(2022, time.May, 1), StartedAt: noon(2022, time.May, 20), FinishedAt: noon(2022, time.June, 10),
+				},
+				{
+					Row: 3, Title: "The Dispossessed", Author: "Ursula K. Le Guin",
+					Format: "audiobook", Status: "abandoned", Shelf: "did-not-finish", AddedAt: noon(2023, time.July, 14),
+				},
+			},
+		},
+		{
+			name:       "header only",
+			csv:        storyGraphHeader,
+			wantSource: ImportSourceStoryGraph,
+		},
+		{
+			name:    "some other csv",
+			csv:     "title,author\nDune,Frank Herbert\n",
+			wantErr: ErrUnknownImportFormat,
+		},
+		{
+			name:    "empty file",
+			csv:     "",
+			wantErr: ErrUnknownImportFormat,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, books, err := ParseReadingHistory(strings.NewReader(tt.csv), time.UTC)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if source != tt.wantSource {
+				t.Errorf("source = %q, want %q", source, tt.wantSource)
+			}
+			if !reflect.DeepEqual(books, tt.wantBooks) {
+				for i, book := range books {
+					t.Logf("book %d: %+v", i, *book)
+				}
+				t.Errorf("books don't match, want %d books", len(tt.wantBooks))
+			}
+		})
+	}
+}
+
+func TestCleanISBN(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{`="9780441478125"`, "9780441478125"},
+		{`="044101359X"`, "044101359X"},
+		{`=""`, ""},
+		{"9780060853983", "9780060853983"},
+		{"a3c5e2f0-uid", ""},
+		{"978-0-06-085398-3", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			if got := cleanISBN(tt.raw); got != tt.want {
+				t.Errorf("cleanISBN(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
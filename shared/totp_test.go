@@ -0,0 +1,79 @@
+package shared
+
+import (
+	"testing"
+	"time"
+)
+
+// The ASCII secret "12345678901234567890" of the RFC 6238 test vectors, base32 encoded like authenticator apps take it
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// RFC 6238 appendix B, SHA1. The RFC prints 8 digits, the last 6 of them are the 6 digit code.
+func TestTOTPCode(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		t.Run(time.Unix(tt.unix, 0).UTC().Format(time.RFC3339), func(t *testing.T) {
+			got, err := totpCode(rfc6238Secret, TOTPStep(time.Unix(tt.unix, 0)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("totpCode at %d = %s, want %s", tt.unix, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	// 1111111111 is in step 37037037, the codes of the steps around it
+	now := time.Unix(1111111111, 0)
+	codeAt := func(step int64) string {
+		code, err := totpCode(rfc6238Secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		wantStep int64
+		wantOk   bool
+	}{
+		{name: "current step", secret: rfc6238Secret, code: "050471", wantStep: 37037037, wantOk: true},
+		{name: "one step early", secret: rfc6238Secret, code: codeAt(37037036), wantStep: 37037036, wantOk: true},
+		{name: "one step late", secret: rfc6238Secret, code: codeAt(37037038), wantStep: 37037038, wantOk: true},
+		{name: "two steps early", secret: rfc6238Secret, code: codeAt(37037035)},
+		{name: "two steps late", secret: rfc6238Secret, code: codeAt(37037039)},
+		{name: "spaces around", secret: rfc6238Secret, code: " 050471 ", wantStep: 37037037, wantOk: true},
+		{name: "lowercase secret", secret: "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", code: "050471", wantStep: 37037037, wantOk: true},
+		{name: "the 8 digit code of the RFC", secret: rfc6238Secret, code: "14050471"},
+		{name: "too short", secret: rfc6238Secret, code: "50471"},
+		{name: "empty", secret: rfc6238Secret, code: ""},
+		{name: "wrong code", secret: rfc6238Secret, code: "050472"},
+		{name: "letters", secret: rfc6238Secret, code: "05o471"},
+		{name: "secret that isn't base32", secret: "not base32!", code: "050471"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := ValidateTOTP(tt.secret, tt.code, now)
+			if ok != tt.wantOk || step != tt.wantStep {
+				t.Errorf("ValidateTOTP(%q) = %d, %v, want %d, %v", tt.code, step, ok, tt.wantStep, tt.wantOk)
+			}
+		})
+	}
+}